@@ -0,0 +1,171 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/spf13/viper"
+)
+
+var errNotConfirmed = errors.New("broker didn't confirm the message")
+
+// Publishes every asset to an AMQP exchange, such as RabbitMQ's. Under
+// AMQP.Confirm each message waits for the broker to confirm it, and a lost
+// connection is dialed again before giving up on the message.
+type amqpOutput struct {
+	address    string
+	exchange   string
+	routingKey string
+	confirm    bool
+	timeout    time.Duration
+	reconnects int
+	delay      time.Duration
+	connection *amqp.Connection
+	channel    *amqp.Channel
+}
+
+func newAMQPOutput(address string) *amqpOutput {
+	return &amqpOutput{
+		address:    address,
+		exchange:   viper.GetString("AMQP.Exchange"),
+		routingKey: viper.GetString("AMQP.RoutingKey"),
+		confirm:    viper.GetBool("AMQP.Confirm"),
+		timeout:    time.Duration(viper.GetFloat64("AMQP.ConfirmTimeout") * float64(time.Second)),
+		reconnects: viper.GetInt("AMQP.Reconnects"),
+		delay:      time.Duration(viper.GetFloat64("AMQP.ReconnectDelay") * float64(time.Second)),
+	}
+}
+
+// Connects right away, so a broker that can't be reached is found out
+// before the crawl starts.
+func (this *amqpOutput) Open() error {
+	return this.connect()
+}
+
+func (this *amqpOutput) connect() error {
+	if this.channel != nil && !this.channel.IsClosed() {
+		return nil
+	}
+	this.disconnect()
+	connection, err := amqp.Dial(this.address)
+	if err != nil {
+		return err
+	}
+	channel, err := connection.Channel()
+	if err != nil {
+		connection.Close()
+		return err
+	}
+	if this.confirm {
+		err = channel.Confirm(false)
+		if err != nil {
+			connection.Close()
+			return err
+		}
+	}
+	this.connection, this.channel = connection, channel
+	return nil
+}
+
+func (this *amqpOutput) disconnect() {
+	if this.connection != nil {
+		this.connection.Close()
+	}
+	this.connection, this.channel = nil, nil
+}
+
+// The routing key may name the {host} of the asset's address.
+func (this *amqpOutput) keyFor(address string) string {
+	return strings.ReplaceAll(this.routingKey, "{host}", hostOf(address))
+}
+
+func (this *amqpOutput) publish(key string, body []byte) error {
+	err := this.connect()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), this.timeout)
+	defer cancel()
+	confirmation, err := this.channel.PublishWithDeferredConfirmWithContext(ctx, this.exchange, key, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now(),
+		Body:         body,
+	})
+	if err != nil || confirmation == nil {
+		return err
+	}
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return errNotConfirmed
+	}
+	return nil
+}
+
+// Tries again on a new connection, waiting twice as long every time, as
+// the connection breaks more often than the broker refuses a message.
+func (this *amqpOutput) Write(asset *asset, rawAsset []byte) error {
+	body := bytes.TrimRight(append([]byte(nil), rawAsset...), "\n")
+	key := this.keyFor(asset.Address)
+	delay := this.delay
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = this.publish(key, body)
+		if err == nil || errors.Is(err, errNotConfirmed) || attempt >= this.reconnects {
+			break
+		}
+		slog.Warn("Reconnecting to AMQP broker", "exchange", this.exchange, "attempt", attempt+1, "wait", delay, failed(err))
+		this.disconnect()
+		time.Sleep(delay)
+		delay *= 2
+	}
+	if err != nil {
+		return fmt.Errorf("cannot publish to exchange %q: %w", this.exchange, err)
+	}
+	return nil
+}
+
+// Every message is sent on its own.
+func (this *amqpOutput) Flush() error {
+	return nil
+}
+
+func (this *amqpOutput) Close() error {
+	this.disconnect()
+	return nil
+}
+
+func init() {
+	registerSink("amqp", "AMQP", func(target string) (sink, error) {
+		if target == "" {
+			return nil, fmt.Errorf("amqp output has no broker address")
+		}
+		return newAMQPOutput(target), nil
+	})
+}
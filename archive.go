@@ -0,0 +1,255 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/html"
+)
+
+// Attributes whose URLs are rewritten to point back into the archive.
+var replayAttributes = map[string]bool{
+	"action":   true,
+	"data-src": true,
+	"href":     true,
+	"poster":   true,
+	"src":      true,
+}
+
+var archiveIndex = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html><head><title>pagecrawl archive</title></head><body>
+<h1>{{len .}} archived pages</h1>
+<ul>{{range .}}<li><a href="/{{.}}">{{.}}</a></li>{{end}}</ul>
+</body></html>`))
+
+type archivedPage struct {
+	contentType string
+	data        []byte
+}
+
+type archive struct {
+	pages map[string]*archivedPage
+}
+
+func newArchive() *archive {
+	return &archive{
+		pages: make(map[string]*archivedPage),
+	}
+}
+
+func (this *archive) loadAssets(source io.Reader) error {
+	return decodeAssets(source, func(next *asset) {
+		if len(next.Data) == 0 {
+			return
+		}
+		contentType := next.ContentType
+		if contentType == "" {
+			contentType = http.DetectContentType(next.Data)
+		}
+		page := &archivedPage{
+			contentType: contentType,
+			data:        next.Data,
+		}
+		this.pages[next.Address] = page
+		if next.FinalAddress != "" {
+			this.pages[next.FinalAddress] = page
+		}
+	})
+}
+
+// Reads the response records out of a WARC file, ignoring every other
+// record type.
+func (this *archive) loadWarc(source io.Reader) error {
+	reader := bufio.NewReader(source)
+	for {
+		version, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(version) == "" {
+			continue
+		}
+		if !strings.HasPrefix(version, "WARC/") {
+			return fmt.Errorf("not a WARC record: %q", strings.TrimSpace(version))
+		}
+		header, err := textproto.NewReader(reader).ReadMIMEHeader()
+		if err != nil {
+			return err
+		}
+		length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad WARC Content-Length: %s", err.Error())
+		}
+		block := make([]byte, length)
+		_, err = io.ReadFull(reader, block)
+		if err != nil {
+			return err
+		}
+		if header.Get("WARC-Type") != "response" {
+			continue
+		}
+		response, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(block)), nil)
+		if err != nil {
+			continue
+		}
+		data, err := io.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			continue
+		}
+		contentType := response.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = http.DetectContentType(data)
+		}
+		target := strings.Trim(header.Get("WARC-Target-URI"), "<>")
+		this.pages[target] = &archivedPage{
+			contentType: contentType,
+			data:        data,
+		}
+	}
+}
+
+func (this *archive) load(path string) error {
+	archiveFile, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer archiveFile.Close()
+	var source io.Reader = archiveFile
+	if strings.HasSuffix(path, ".gz") {
+		unzipped, err := gzip.NewReader(archiveFile)
+		if err != nil {
+			return err
+		}
+		defer unzipped.Close()
+		source = unzipped
+	}
+	if strings.HasSuffix(strings.TrimSuffix(path, ".gz"), ".warc") {
+		return this.loadWarc(source)
+	}
+	return this.loadAssets(source)
+}
+
+func (this *archive) lookup(target string) (*archivedPage, bool) {
+	for _, nextCandidate := range []string{target, target + "/", strings.TrimSuffix(target, "/")} {
+		if page, ok := this.pages[nextCandidate]; ok {
+			return page, true
+		}
+	}
+	return nil, false
+}
+
+func rewriteForReplay(where string, data []byte) []byte {
+	base, err := url.Parse(where)
+	if err != nil {
+		return data
+	}
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return data
+	}
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		for i, nextAttribute := range node.Attr {
+			if !replayAttributes[strings.ToLower(nextAttribute.Key)] {
+				continue
+			}
+			kind, resolved := classifyLink(base, nextAttribute.Val)
+			if kind != linkOther {
+				node.Attr[i].Val = "/" + resolved.String()
+			}
+		}
+		for next := node.FirstChild; next != nil; next = next.NextSibling {
+			walk(next)
+		}
+	}
+	walk(doc)
+	buf := &bytes.Buffer{}
+	err = html.Render(buf, doc)
+	if err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
+
+// Archived pages are served at their original URL below the root, e.g.
+// /https://example.com/about.
+func (this *archive) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	target := strings.TrimPrefix(request.RequestURI, "/")
+	if target == "" {
+		addresses := make([]string, 0, len(this.pages))
+		for nextAddress := range this.pages {
+			addresses = append(addresses, nextAddress)
+		}
+		sort.Strings(addresses)
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		archiveIndex.Execute(writer, addresses)
+		return
+	}
+	page, ok := this.lookup(target)
+	if !ok {
+		http.NotFound(writer, request)
+		return
+	}
+	data := page.data
+	if strings.HasPrefix(page.contentType, "text/html") {
+		data = rewriteForReplay(target, data)
+	}
+	writer.Header().Set("Content-Type", page.contentType)
+	writer.Write(data)
+}
+
+func runServeArchive(paths []string) {
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: pagecrawl serve-archive <asset or WARC files...>")
+		os.Exit(1)
+	}
+	replay := newArchive()
+	for _, nextPath := range paths {
+		err := replay.load(nextPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot load archive %s: %s\n", nextPath, err.Error())
+			os.Exit(1)
+		}
+	}
+	listen := viper.GetString("Archive.Listen")
+	fmt.Fprintf(os.Stderr, "Serving %d archived pages on %s\n", len(replay.pages), listen)
+	err := http.ListenAndServe(listen, replay)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "Cannot serve archive: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,65 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestArchiveLoadAssets(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+		encoding    string
+		served      string
+	}{
+		{name: "recorded type", contentType: "text/css", data: []byte("body {}"), encoding: dataText, served: "text/css"},
+		{name: "base64", contentType: "image/svg+xml", data: []byte("<svg></svg>"), encoding: dataBase64, served: "image/svg+xml"},
+		{name: "hex", contentType: "application/json", data: []byte(`{"a":1}`), encoding: dataHex, served: "application/json"},
+		{name: "sniffed without one", data: []byte("<html><body>hi</body></html>"), encoding: dataText, served: "text/html; charset=utf-8"},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			rawAsset, err := encodeAsset(&asset{
+				Address:      "https://example.com/page",
+				FinalAddress: "https://example.com/page/",
+				ContentType:  nextTest.contentType,
+				Data:         nextTest.data,
+			}, nextTest.encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			replay := newArchive()
+			err = replay.loadAssets(bytes.NewReader(append(rawAsset, '\n')))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, nextAddress := range []string{"https://example.com/page", "https://example.com/page/"} {
+				page, ok := replay.lookup(nextAddress)
+				if !ok {
+					t.Fatalf("lookup(%s) found nothing", nextAddress)
+				}
+				if page.contentType != nextTest.served || !bytes.Equal(page.data, nextTest.data) {
+					t.Errorf("lookup(%s) = %s %q, want %s %q", nextAddress, page.contentType, page.data, nextTest.served, nextTest.data)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// Credentials to send along with requests, as "user:password" for Basic or
+// a token for Bearer. Values may refer to environment variables as ${NAME}.
+type authConfig struct {
+	Basic  string `json:"basic"`
+	Bearer string `json:"bearer"`
+}
+
+// The hosts given as input, which are the only ones the credentials in the
+// Auth section go to unless Auth.Hosts names others.
+var authHosts = &sync.Map{}
+
+func authSeeded(where string) {
+	authHosts.Store(hostOf(where), true)
+}
+
+func globalAuthFor(host string) *authConfig {
+	auth := &authConfig{
+		Basic:  viper.GetString("Auth.Basic"),
+		Bearer: viper.GetString("Auth.Bearer"),
+	}
+	if auth.Basic == "" && auth.Bearer == "" {
+		return nil
+	}
+	if listed := parseFields(viper.GetString("Auth.Hosts")); len(listed) > 0 {
+		for _, nextHost := range listed {
+			if strings.EqualFold(nextHost, host) {
+				return auth
+			}
+		}
+		return nil
+	}
+	if _, ok := authHosts.Load(host); ok {
+		return auth
+	}
+	return nil
+}
+
+func (this *authConfig) apply(request *http.Request) {
+	if this.Bearer != "" {
+		request.Header.Set("Authorization", "Bearer "+os.ExpandEnv(this.Bearer))
+		return
+	}
+	if this.Basic != "" {
+		user, password, _ := strings.Cut(os.ExpandEnv(this.Basic), ":")
+		request.SetBasicAuth(user, password)
+	}
+}
+
+// The hosts file's credentials for a host win over the ones in the Auth
+// section.
+func authorize(request *http.Request) {
+	host := strings.ToLower(request.URL.Hostname())
+	auth := configFor(host).Auth
+	if auth == nil {
+		auth = globalAuthFor(host)
+	}
+	if auth != nil {
+		auth.apply(request)
+	}
+}
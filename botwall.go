@@ -0,0 +1,73 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"net/http"
+	"regexp"
+
+	"golang.org/x/net/html"
+)
+
+// Pages shorter than this are mostly interstitial rather than content that
+// happens to embed a CAPTCHA, like a contact form.
+const interstitialTextLength = 2000
+
+type botWall struct {
+	name        string
+	header      string
+	headerValue *regexp.Regexp
+	markup      *regexp.Regexp
+	// Embeddable walls only count on blocking statuses or near-empty pages.
+	embeddable bool
+}
+
+var botWalls = []botWall{
+	{name: "Cloudflare challenge", header: "Cf-Mitigated", headerValue: regexp.MustCompile(`(?i)challenge`)},
+	{name: "Cloudflare challenge", markup: regexp.MustCompile(`(?i)cf-browser-verification|/cdn-cgi/challenge-platform/|<title>Just a moment\.\.\.</title>|<title>Attention Required! \| Cloudflare</title>`)},
+	{name: "Incapsula", markup: regexp.MustCompile(`(?i)_Incapsula_Resource|Incapsula incident ID`)},
+	{name: "Sucuri", markup: regexp.MustCompile(`(?i)Sucuri WebSite Firewall - Access Denied`)},
+	{name: "DataDome", markup: regexp.MustCompile(`(?i)captcha-delivery\.com`)},
+	{name: "PerimeterX", markup: regexp.MustCompile(`(?i)px-captcha|/_px/captcha`)},
+	{name: "Akamai", markup: regexp.MustCompile(`(?i)<title>Access Denied</title>[\s\S]*Reference #[0-9a-f.]+`)},
+	{name: "reCAPTCHA", markup: regexp.MustCompile(`(?i)www\.google\.com/recaptcha/|www\.recaptcha\.net/recaptcha/`), embeddable: true},
+	{name: "hCaptcha", markup: regexp.MustCompile(`(?i)hcaptcha\.com/1/api\.js|js\.hcaptcha\.com`), embeddable: true},
+}
+
+func blockingStatus(status int) bool {
+	return status == http.StatusForbidden || status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// Names the anti-bot wall the response is, or returns an empty string if it
+// looks like the real page.
+func detectBotWall(response *http.Response, doc *html.Node, rawResponse []byte) string {
+	markup := string(rawResponse)
+	interstitial := blockingStatus(response.StatusCode) || len(pageText(doc)) < interstitialTextLength
+	for _, nextWall := range botWalls {
+		if nextWall.embeddable && !interstitial {
+			continue
+		}
+		if nextWall.header != "" && nextWall.headerValue.MatchString(response.Header.Get(nextWall.header)) {
+			return nextWall.name
+		}
+		if nextWall.markup != nil && nextWall.markup.MatchString(markup) {
+			return nextWall.name
+		}
+	}
+	return ""
+}
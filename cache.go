@@ -0,0 +1,136 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+type cacheEntry struct {
+	Job          string    `json:"job"`
+	Run          string    `json:"run"`
+	Checked      time.Time `json:"checked"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	Hash         string    `json:"hash,omitempty"`
+}
+
+// Remembers the validators and content hash of every page crawled, so later
+// crawls can tell what changed.
+type validatorCache struct {
+	lock    *sync.Mutex
+	path    string
+	entries map[string]*cacheEntry
+}
+
+var validators *validatorCache
+
+func loadValidatorCache(path string) (*validatorCache, error) {
+	loaded := &validatorCache{
+		lock:    &sync.Mutex{},
+		path:    path,
+		entries: make(map[string]*cacheEntry),
+	}
+	rawCache, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return loaded, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(rawCache, &loaded.entries)
+	if err != nil {
+		return nil, err
+	}
+	return loaded, nil
+}
+
+func (this *validatorCache) save() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	rawCache, err := json.MarshalIndent(this.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(this.path, rawCache, 0644)
+}
+
+func (this *validatorCache) lookup(where string) (*cacheEntry, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	entry, ok := this.entries[where]
+	return entry, ok
+}
+
+func (this *validatorCache) condition(request *http.Request) {
+	entry, ok := this.lookup(request.URL.String())
+	if !ok {
+		return
+	}
+	if entry.ETag != "" {
+		request.Header.Set("If-None-Match", entry.ETag)
+	}
+	if entry.LastModified != "" {
+		request.Header.Set("If-Modified-Since", entry.LastModified)
+	}
+}
+
+// Records the response and tells whether its content differs from the last
+// time the page was crawled.
+func (this *validatorCache) update(where string, response *http.Response, hash string) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	previous, seen := this.entries[where]
+	if response.StatusCode == http.StatusNotModified {
+		if seen {
+			previous.Job, previous.Run = jobName, runID
+			previous.Checked = time.Now().UTC()
+		}
+		return false
+	}
+	this.entries[where] = &cacheEntry{
+		Job:          jobName,
+		Run:          runID,
+		Checked:      time.Now().UTC(),
+		ETag:         response.Header.Get("ETag"),
+		LastModified: response.Header.Get("Last-Modified"),
+		Hash:         hash,
+	}
+	return !seen || previous.Hash != hash
+}
+
+func initValidatorCache() {
+	cachePath := viper.GetString("Cache.Path")
+	if cachePath == "" {
+		return
+	}
+	loaded, err := loadValidatorCache(cachePath)
+	if err != nil {
+		panic(fmt.Sprintf("Cannot load cache %s: %s", cachePath, err.Error()))
+	}
+	validators = loaded
+}
@@ -0,0 +1,121 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+type tlsDetails struct {
+	Version      string    `json:"version"`
+	CipherSuite  string    `json:"cipherSuite"`
+	Protocol     string    `json:"protocol,omitempty"`
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	Names        []string  `json:"names"`
+	NotBefore    time.Time `json:"notBefore"`
+	NotAfter     time.Time `json:"notAfter"`
+	Expiring     bool      `json:"expiring"`
+	SerialNumber string    `json:"serialNumber,omitempty"`
+	Fingerprint  string    `json:"fingerprint,omitempty"`
+}
+
+func inspectTLS(response *http.Response) *tlsDetails {
+	state := response.TLS
+	if state == nil {
+		return nil
+	}
+	details := &tlsDetails{
+		Version:     tlsVersions[state.Version],
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		Protocol:    state.NegotiatedProtocol,
+		Names:       make([]string, 0),
+	}
+	if details.Version == "" {
+		details.Version = fmt.Sprintf("0x%04x", state.Version)
+	}
+	if len(state.PeerCertificates) == 0 {
+		return details
+	}
+	leaf := state.PeerCertificates[0]
+	details.Subject = leaf.Subject.String()
+	details.Issuer = leaf.Issuer.String()
+	details.NotBefore = leaf.NotBefore.UTC()
+	details.NotAfter = leaf.NotAfter.UTC()
+	details.SerialNumber = fmt.Sprintf("%X", leaf.SerialNumber)
+	rawFingerprint := sha256.Sum256(leaf.Raw)
+	details.Fingerprint = hex.EncodeToString(rawFingerprint[:])
+	details.Names = append(details.Names, leaf.DNSNames...)
+	for _, nextAddress := range leaf.IPAddresses {
+		details.Names = append(details.Names, nextAddress.String())
+	}
+	window := time.Duration(viper.GetInt("Audit.CertificateExpiryDays")) * 24 * time.Hour
+	details.Expiring = time.Until(leaf.NotAfter) < window
+	return details
+}
+
+type expiringCertificate struct {
+	Subject  string    `json:"subject"`
+	Issuer   string    `json:"issuer"`
+	NotAfter time.Time `json:"notAfter"`
+	DaysLeft int       `json:"daysLeft"`
+}
+
+type certificateReport struct {
+	hosts map[string]*expiringCertificate
+}
+
+func newCertificateReport() *certificateReport {
+	return &certificateReport{
+		hosts: make(map[string]*expiringCertificate),
+	}
+}
+
+func (this *certificateReport) name() string {
+	return "certificates"
+}
+
+func (this *certificateReport) observe(asset *asset) {
+	if asset.TLS == nil || !asset.TLS.Expiring {
+		return
+	}
+	this.hosts[hostOf(asset.Address)] = &expiringCertificate{
+		Subject:  asset.TLS.Subject,
+		Issuer:   asset.TLS.Issuer,
+		NotAfter: asset.TLS.NotAfter,
+		DaysLeft: int(time.Until(asset.TLS.NotAfter).Hours() / 24),
+	}
+}
+
+func (this *certificateReport) summary() any {
+	return this.hosts
+}
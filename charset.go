@@ -0,0 +1,85 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/saintfish/chardet"
+	"golang.org/x/net/html/charset"
+)
+
+// How sure the detector has to be before its guess beats windows-1252, which
+// is what browsers fall back to.
+const charsetConfidence = 50
+
+func textual(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/") ||
+		mediaType == "application/xhtml+xml" ||
+		mediaType == "application/xml" ||
+		strings.HasSuffix(mediaType, "+xml")
+}
+
+// Takes the charset from a byte order mark, the Content-Type or a
+// <meta charset>, in that order, and failing those guesses it from the
+// bytes.
+func detectCharset(raw []byte, contentType string) string {
+	_, name, certain := charset.DetermineEncoding(raw, contentType)
+	if certain || name != "windows-1252" {
+		return name
+	}
+	if utf8.Valid(raw) {
+		return "utf-8"
+	}
+	guess, err := chardet.NewTextDetector().DetectBest(raw)
+	if err != nil || guess.Confidence < charsetConfidence {
+		return name
+	}
+	if found, guessName := charset.Lookup(guess.Charset); found != nil {
+		return guessName
+	}
+	return name
+}
+
+// Rewrites text bodies in UTF-8 and reports the charset they came in, which
+// is empty for anything that isn't text.
+func transcodeBody(response *http.Response, raw []byte) ([]byte, string) {
+	contentType := response.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(raw))
+	}
+	if !textual(mediaType) || len(raw) == 0 {
+		return raw, ""
+	}
+	name := detectCharset(raw, contentType)
+	if name == "utf-8" {
+		return raw, name
+	}
+	encoding, _ := charset.Lookup(name)
+	decoded, err := encoding.NewDecoder().Bytes(raw)
+	if err != nil {
+		slog.Warn("Cannot transcode body", "url", response.Request.URL.String(), "charset", name, failed(err))
+		return raw, ""
+	}
+	return decoded, name
+}
@@ -0,0 +1,110 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDetectCharset(t *testing.T) {
+	tests := []struct {
+		name        string
+		raw         []byte
+		contentType string
+		charset     string
+	}{
+		{
+			name:        "content type",
+			raw:         []byte("<p>caf\xe9</p>"),
+			contentType: "text/html; charset=ISO-8859-1",
+			charset:     "windows-1252",
+		},
+		{
+			name:        "byte order mark beats content type",
+			raw:         []byte("\xef\xbb\xbf<p>café</p>"),
+			contentType: "text/html; charset=ISO-8859-1",
+			charset:     "utf-8",
+		},
+		{
+			name:        "meta charset",
+			raw:         []byte(`<html><head><meta charset="shift_jis"></head><body>x</body></html>`),
+			contentType: "text/html",
+			charset:     "shift_jis",
+		},
+		{
+			name:        "valid utf-8 without a declaration",
+			raw:         []byte("<p>café crème brûlée</p>"),
+			contentType: "text/html",
+			charset:     "utf-8",
+		},
+		{
+			name:        "ascii without a declaration",
+			raw:         []byte("<p>plain</p>"),
+			contentType: "text/html",
+			charset:     "utf-8",
+		},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			if got := detectCharset(nextTest.raw, nextTest.contentType); got != nextTest.charset {
+				t.Errorf("detectCharset() = %s, want %s", got, nextTest.charset)
+			}
+		})
+	}
+}
+
+func TestTranscodeBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		raw         string
+		contentType string
+		body        string
+		charset     string
+	}{
+		{
+			name:        "latin-1 becomes utf-8",
+			raw:         "<p>caf\xe9</p>",
+			contentType: "text/html; charset=iso-8859-1",
+			body:        "<p>café</p>",
+			charset:     "windows-1252",
+		},
+		{
+			name:        "utf-8 stays as it is",
+			raw:         "<p>café</p>",
+			contentType: "text/html; charset=utf-8",
+			body:        "<p>café</p>",
+			charset:     "utf-8",
+		},
+		{
+			name:        "binary is left alone",
+			raw:         "\x89PNG\r\n\x1a\n\xe9",
+			contentType: "image/png",
+			body:        "\x89PNG\r\n\x1a\n\xe9",
+		},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			response := &http.Response{Header: http.Header{"Content-Type": {nextTest.contentType}}}
+			body, name := transcodeBody(response, []byte(nextTest.raw))
+			if string(body) != nextTest.body || name != nextTest.charset {
+				t.Errorf("transcodeBody() = %q, %s, want %q, %s", body, name, nextTest.body, nextTest.charset)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// What the flags leave for the crawl besides configuration. The output
+// options are as they stand while the flags are read, since they only apply
+// to the outputs named after them.
+type flagOptions struct {
+	data   string
+	fields []string
+	inputs []*os.File
+}
+
+// A flag that doesn't take a value, but does something when given.
+type switchFlag func()
+
+func (this switchFlag) String() string {
+	return ""
+}
+
+func (this switchFlag) Set(value string) error {
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	if enabled {
+		this()
+	}
+	return nil
+}
+
+func (this switchFlag) IsBoolFlag() bool {
+	return true
+}
+
+func printAndExit(text string) func() {
+	return func() {
+		fmt.Fprintln(os.Stdout, strings.TrimRight(text, "\n"))
+		os.Exit(0)
+	}
+}
+
+// Both -name and --name work for every flag, as the flag package does.
+func newFlagSet(options *flagOptions) *flag.FlagSet {
+	flags := flag.NewFlagSet("pagecrawl", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	cache := switchFlag(func() {
+		shouldCache = true
+	})
+	flags.Var(cache, "c", "")
+	flags.Var(cache, "cache", "")
+	help := switchFlag(printAndExit(helpInfo))
+	flags.Var(help, "h", "")
+	flags.Var(help, "help", "")
+	flags.Var(switchFlag(printAndExit(licenseInfo)), "l", "")
+	flags.Var(switchFlag(printAndExit("PageCrawl pre-release")), "v", "")
+	flags.Var(switchFlag(func() {
+		viper.Set("Cache.Incremental", true)
+	}), "incremental", "")
+	flags.Var(switchFlag(func() {
+		viper.Set("Crawl.Scope", scopeHost)
+	}), "same-host", "")
+	flags.Var(switchFlag(func() {
+		viper.Set("Crawl.Scope", scopeDomain)
+	}), "same-domain", "")
+	flags.Var(switchFlag(func() {
+		viper.Set("Frontier.Resume", true)
+	}), "resume", "")
+	flags.Var(switchFlag(func() {
+		viper.Set("Audit.CheckLinks", true)
+		initLinkCheck()
+	}), "check-links", "")
+	flags.Var(switchFlag(func() {
+		viper.Set("Log.Stderr", true)
+		logWriter = os.Stderr
+		initLogger()
+	}), "log-stderr", "")
+	flags.Func("log-level", "", func(value string) error {
+		viper.Set("Log.Level", value)
+		return initLogger()
+	})
+	flags.Func("log-format", "", func(value string) error {
+		viper.Set("Log.Format", value)
+		return initLogger()
+	})
+	flags.Func("data", "", func(value string) error {
+		value = strings.ToLower(value)
+		err := validDataEncoding(value)
+		if err != nil {
+			return err
+		}
+		options.data = value
+		return nil
+	})
+	// Deprecated, every output writing ndjson, but still sets Output.Format
+	// for all of them so older command lines keep working.
+	flags.Func("format", "", func(value string) error {
+		format := strings.ToLower(value)
+		err := validOutputFormat(format)
+		if err != nil {
+			return err
+		}
+		viper.Set("Output.Format", format)
+		return nil
+	})
+	flags.Func("fields", "", func(value string) error {
+		options.fields = parseFields(value)
+		return nil
+	})
+	flags.Func("order", "", func(value string) error {
+		return initOrdering(strings.ToLower(value))
+	})
+	flags.Func("depth", "", func(value string) error {
+		depth, err := parseDepth(value)
+		if err != nil {
+			return err
+		}
+		viper.Set("Crawl.Depth", depth)
+		return nil
+	})
+	flags.Func("workers", "", func(value string) error {
+		workers, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		viper.Set("Frontier.Workers", workers)
+		return nil
+	})
+	flags.Func("max-per-host", "", func(value string) error {
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		viper.Set("Network.MaxPerHost", limit)
+		return nil
+	})
+	flags.Func("state", "", func(value string) error {
+		viper.Set("Frontier.State", value)
+		return nil
+	})
+	flags.Func("previous", "", func(value string) error {
+		viper.Set("Output.Previous", value)
+		initPreviousCrawl()
+		return nil
+	})
+	flags.Func("serve", "", func(value string) error {
+		viper.Set("Control.Serve", value)
+		return nil
+	})
+	flags.Func("summary-json", "", func(value string) error {
+		viper.Set("Report.SummaryPath", value)
+		initSummary()
+		return nil
+	})
+	flags.Var(switchFlag(func() {
+		viper.Set("Report.Summary", false)
+	}), "no-summary", "")
+	flags.Func("graph-out", "", func(value string) error {
+		viper.Set("Output.Graph", value)
+		initGraph()
+		return nil
+	})
+	flags.Func("http", "", func(value string) error {
+		err := validHTTPVersion(strings.TrimPrefix(strings.ToLower(value), "http/"))
+		if err != nil {
+			return err
+		}
+		viper.Set("Network.HTTPVersion", value)
+		viper.Set("Network.HTTP3", false)
+		initTransports()
+		return nil
+	})
+	flags.Var(switchFlag(func() {
+		viper.Set("TLS.Insecure", true)
+		initTransports()
+	}), "insecure", "")
+	flags.Func("proxy", "", func(value string) error {
+		viper.Set("Network.Proxy", value)
+		return nil
+	})
+	flags.Func("seed-sitemap", "", func(value string) error {
+		viper.Set("Input.Sitemaps", value)
+		return nil
+	})
+	flags.Func("allow-domains", "", func(value string) error {
+		viper.Set("Crawl.AllowDomains", value)
+		return nil
+	})
+	flags.Func("header", "", addHeader)
+	flags.Func("cookies", "", importCookies)
+	flags.Func("user-agent", "", func(value string) error {
+		viper.Set("Network.UserAgent", value)
+		initUserAgent()
+		return nil
+	})
+	flags.Func("auth-basic", "", func(value string) error {
+		if !strings.Contains(value, ":") {
+			return fmt.Errorf("expected user:password")
+		}
+		viper.Set("Auth.Basic", value)
+		return nil
+	})
+	flags.Func("auth-bearer", "", func(value string) error {
+		viper.Set("Auth.Bearer", value)
+		return nil
+	})
+	flags.Func("input", "", func(value string) error {
+		if value == "-" {
+			options.inputs = append(options.inputs, os.Stdin)
+			return nil
+		}
+		file, err := os.Open(value)
+		if err != nil {
+			return err
+		}
+		options.inputs = append(options.inputs, file)
+		return nil
+	})
+	flags.Func("out", "", func(value string) error {
+		return addOutput(value, options)
+	})
+	for _, nextKind := range []string{"file", "file-gzip", "sqlite", "kafka", "s3", "amqp", "url"} {
+		kind := nextKind
+		flags.Func("out-"+kind, "", func(value string) error {
+			return addOutputs(kind, value, options)
+		})
+	}
+	return flags
+}
+
+// Flags are read in order, so the output options given before an output
+// apply to it. Whatever follows the flags are addresses to crawl.
+func parseFlags(arguments []string, options *flagOptions) []string {
+	flags := newFlagSet(options)
+	err := flags.Parse(arguments)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, fmt.Sprintf("pagecrawl: %s", err.Error()))
+		fmt.Fprintln(os.Stderr, "Run pagecrawl -h to see every flag.")
+		os.Exit(2)
+	}
+	return flags.Args()
+}
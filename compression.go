@@ -0,0 +1,158 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+const acceptedEncodings = "gzip, deflate, br"
+
+type transferStats struct {
+	Encoding    string `json:"encoding,omitempty"`
+	Transferred int    `json:"transferred"`
+	Decoded     int    `json:"decoded"`
+}
+
+func decoderFor(encoding string, raw []byte) (io.Reader, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(bytes.NewReader(raw))
+	case "deflate":
+		// Deflate is meant to be zlib wrapped, but plenty of servers send it raw.
+		reader, err := zlib.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return flate.NewReader(bytes.NewReader(raw)), nil
+		}
+		return reader, nil
+	case "br":
+		return brotli.NewReader(bytes.NewReader(raw)), nil
+	}
+	return nil, fmt.Errorf("unsupported content encoding %s", encoding)
+}
+
+// Undoes the response's content encodings, last applied first, and strips
+// them from the headers so the body reads as if it was never encoded. The
+// decoded body is held to the same size limit as the one sent, so small
+// responses can't decompress into huge ones, and whether it had to be cut
+// short is returned along with it.
+func decodeBody(response *http.Response, raw []byte) ([]byte, *transferStats, bool, error) {
+	stats := &transferStats{
+		Transferred: len(raw),
+		Decoded:     len(raw),
+	}
+	header := strings.ToLower(strings.TrimSpace(response.Header.Get("Content-Encoding")))
+	if header == "" || header == "identity" || len(raw) == 0 {
+		return raw, stats, false, nil
+	}
+	stats.Encoding = header
+	encodings := strings.Split(header, ",")
+	decoded := raw
+	truncated := false
+	for i := len(encodings) - 1; i >= 0; i-- {
+		encoding := strings.TrimSpace(encodings[i])
+		if encoding == "identity" {
+			continue
+		}
+		reader, err := decoderFor(encoding, decoded)
+		if err != nil {
+			return raw, stats, false, err
+		}
+		var cut bool
+		decoded, cut, err = readBody(reader)
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// What arrived of a body that was cut short still decodes.
+			truncated = true
+			break
+		}
+		if err != nil {
+			return raw, stats, false, err
+		}
+		truncated = truncated || cut
+	}
+	response.Header.Del("Content-Encoding")
+	response.Header.Del("Content-Length")
+	response.ContentLength = -1
+	response.Uncompressed = true
+	stats.Decoded = len(decoded)
+	return decoded, stats, truncated, nil
+}
+
+type hostCompression struct {
+	Pages       int            `json:"pages"`
+	Transferred int            `json:"transferred"`
+	Decoded     int            `json:"decoded"`
+	Ratio       float64        `json:"ratio"`
+	Encodings   map[string]int `json:"encodings"`
+	// Pages that were sent without any compression at all.
+	Uncompressed []string `json:"uncompressed"`
+}
+
+type compressionReport struct {
+	hosts map[string]*hostCompression
+}
+
+func newCompressionReport() *compressionReport {
+	return &compressionReport{
+		hosts: make(map[string]*hostCompression),
+	}
+}
+
+func (this *compressionReport) name() string {
+	return "compression"
+}
+
+func (this *compressionReport) observe(asset *asset) {
+	if asset.Transfer == nil {
+		return
+	}
+	host := hostOf(asset.Address)
+	summary, ok := this.hosts[host]
+	if !ok {
+		summary = &hostCompression{
+			Encodings:    make(map[string]int),
+			Uncompressed: make([]string, 0),
+		}
+		this.hosts[host] = summary
+	}
+	summary.Pages++
+	summary.Transferred += asset.Transfer.Transferred
+	summary.Decoded += asset.Transfer.Decoded
+	if summary.Decoded > 0 {
+		summary.Ratio = float64(summary.Transferred) / float64(summary.Decoded)
+	}
+	if asset.Transfer.Encoding == "" {
+		summary.Uncompressed = append(summary.Uncompressed, asset.Address)
+		return
+	}
+	summary.Encodings[asset.Transfer.Encoding]++
+}
+
+func (this *compressionReport) summary() any {
+	return this.hosts
+}
@@ -0,0 +1,67 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Whether Network.ContentTypes lets a body of this type be downloaded. A
+// type can be given in full or as type/* for all of its subtypes, and
+// responses that don't say what they are are always let through.
+func contentTypeAllowed(contentType string) bool {
+	allowed := parseFields(viper.GetString("Network.ContentTypes"))
+	if len(allowed) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return true
+	}
+	for _, nextType := range allowed {
+		nextType = strings.ToLower(nextType)
+		if nextType == mediaType || strings.HasSuffix(nextType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(nextType, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Asks for the headers alone before downloading a page with Network.HeadFirst,
+// and tells whether the page isn't worth downloading. Hosts that can't
+// answer HEAD requests get the GET anyway.
+func skippedByHead(target *crawlTarget) bool {
+	if !viper.GetBool("Network.HeadFirst") || (target.Method != "" && !strings.EqualFold(target.Method, http.MethodGet)) {
+		return false
+	}
+	response, err := probe(http.MethodHead, target.Address)
+	if err != nil || response.StatusCode >= 400 {
+		return false
+	}
+	contentType := response.Header.Get("Content-Type")
+	if contentTypeAllowed(contentType) {
+		return false
+	}
+	slog.Info("Not fetching, content type isn't allowed", "url", target.Address, "contentType", contentType)
+	return true
+}
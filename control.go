@@ -0,0 +1,211 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	jobRunning = "running"
+	jobPaused  = "paused"
+)
+
+// Holds fetches back while the job is paused. Fetches already in flight
+// finish, and queued URLs stay queued until the job is resumed.
+type jobGate struct {
+	lock   *sync.Mutex
+	resume *sync.Cond
+	paused bool
+}
+
+type jobStatus struct {
+	Name  string `json:"name"`
+	Run   string `json:"run"`
+	State string `json:"state"`
+	// Only jobs submitted to the service have these.
+	Submitted *time.Time `json:"submitted,omitempty"`
+	Spec      string     `json:"spec,omitempty"`
+	Next      *time.Time `json:"next,omitempty"`
+	Runs      int        `json:"runs,omitempty"`
+	Seeds     int        `json:"seeds,omitempty"`
+	Pending   int        `json:"pending,omitempty"`
+	Fetched   int        `json:"fetched,omitempty"`
+	Failed    int        `json:"failed,omitempty"`
+	Assets    int        `json:"assets,omitempty"`
+}
+
+// The gate of every job, keyed by its id, the crawl's own by its name.
+var (
+	gates     = make(map[string]*jobGate)
+	gatesLock = &sync.Mutex{}
+)
+
+// Targets that belong to no job go through the crawl's own gate.
+func gateFor(job string) *jobGate {
+	if job == "" {
+		job = jobName
+	}
+	gatesLock.Lock()
+	defer gatesLock.Unlock()
+	gate, ok := gates[job]
+	if !ok {
+		gate = newJobGate()
+		gates[job] = gate
+	}
+	return gate
+}
+
+func newJobGate() *jobGate {
+	lock := &sync.Mutex{}
+	return &jobGate{
+		lock:   lock,
+		resume: sync.NewCond(lock),
+	}
+}
+
+func (this *jobGate) wait() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for this.paused {
+		this.resume.Wait()
+	}
+}
+
+func (this *jobGate) isPaused() bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.paused
+}
+
+// The frontier holds back what a paused job has queued, so it is told once
+// the job may go on.
+func (this *jobGate) set(paused bool) {
+	this.lock.Lock()
+	this.paused = paused
+	if !paused {
+		this.resume.Broadcast()
+	}
+	this.lock.Unlock()
+	if !paused && frontier != nil {
+		frontier.wake()
+	}
+}
+
+func (this *jobGate) status() *jobStatus {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	state := jobRunning
+	if this.paused {
+		state = jobPaused
+	}
+	return &jobStatus{
+		Name:  jobName,
+		Run:   runID,
+		State: state,
+	}
+}
+
+func writeJSON(writer http.ResponseWriter, status int, value any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(value)
+}
+
+// Serves GET /jobs, GET /jobs/{name} and POST /jobs/{name}/pause or
+// /jobs/{name}/resume. Jobs submitted to the service are listed after the
+// crawl's own and are paused and resumed by their id, each on its own, and
+// DELETE /jobs/{id} stops one from running on its schedule.
+func serveControl(writer http.ResponseWriter, request *http.Request) {
+	parts := strings.Split(strings.Trim(request.URL.Path, "/"), "/")
+	if len(parts) == 1 && parts[0] == "jobs" && request.Method == http.MethodGet {
+		statuses := []*jobStatus{gateFor(jobName).status()}
+		if service != nil {
+			statuses = append(statuses, service.statuses()...)
+		}
+		writeJSON(writer, http.StatusOK, statuses)
+		return
+	}
+	if len(parts) < 2 || parts[0] != "jobs" {
+		http.NotFound(writer, request)
+		return
+	}
+	if service != nil && parts[1] != jobName {
+		if status := service.jobStatus(parts[1]); status != nil {
+			switch {
+			case len(parts) == 2 && request.Method == http.MethodGet:
+			case len(parts) == 2 && request.Method == http.MethodDelete:
+				service.cancel(parts[1])
+				slog.Info("Cancelled job", "id", parts[1])
+				status = service.jobStatus(parts[1])
+			case len(parts) == 3 && parts[2] == "pause" && request.Method == http.MethodPost:
+				gateFor(parts[1]).set(true)
+				slog.Info("Paused job", "id", parts[1])
+				status = service.jobStatus(parts[1])
+			case len(parts) == 3 && parts[2] == "resume" && request.Method == http.MethodPost:
+				gateFor(parts[1]).set(false)
+				slog.Info("Resumed job", "id", parts[1])
+				status = service.jobStatus(parts[1])
+			default:
+				http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			writeJSON(writer, http.StatusOK, status)
+			return
+		}
+	}
+	if parts[1] != jobName {
+		writeJSON(writer, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("no job named %s", parts[1])})
+		return
+	}
+	gate := gateFor(jobName)
+	switch {
+	case len(parts) == 2 && request.Method == http.MethodGet:
+	case len(parts) == 3 && parts[2] == "pause" && request.Method == http.MethodPost:
+		gate.set(true)
+		slog.Info("Paused job")
+	case len(parts) == 3 && parts[2] == "resume" && request.Method == http.MethodPost:
+		gate.set(false)
+		slog.Info("Resumed job")
+	default:
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(writer, http.StatusOK, gate.status())
+}
+
+func initControl() {
+	listen := viper.GetString("Control.Listen")
+	if listen == "" {
+		return
+	}
+	go func() {
+		err := http.ListenAndServe(listen, http.HandlerFunc(serveControl))
+		if err != nil {
+			slog.Error("Cannot serve control API", "listen", listen, failed(err))
+		}
+	}()
+}
@@ -0,0 +1,340 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/net/publicsuffix"
+)
+
+type storedCookie struct {
+	Name     string    `json:"name"`
+	Value    string    `json:"value"`
+	Path     string    `json:"path,omitempty"`
+	Domain   string    `json:"domain,omitempty"`
+	Expires  time.Time `json:"expires,omitempty"`
+	Secure   bool      `json:"secure,omitempty"`
+	HttpOnly bool      `json:"httpOnly,omitempty"`
+}
+
+type hostCookies struct {
+	Address string                   `json:"address"`
+	Cookies map[string]*storedCookie `json:"cookies"`
+}
+
+// A cookie jar that remembers every cookie it was given, since the standard
+// jar can't be asked for its cookies with their attributes intact.
+type persistentJar struct {
+	*cookiejar.Jar
+	lock  *sync.Mutex
+	hosts map[string]*hostCookies
+}
+
+func newPersistentJar() *persistentJar {
+	jar, _ := cookiejar.New(&cookiejar.Options{
+		PublicSuffixList: publicsuffix.List,
+	})
+	return &persistentJar{
+		Jar:   jar,
+		lock:  &sync.Mutex{},
+		hosts: make(map[string]*hostCookies),
+	}
+}
+
+func (this *persistentJar) SetCookies(address *url.URL, cookies []*http.Cookie) {
+	this.Jar.SetCookies(address, cookies)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	host := hostOf(address.String())
+	stored, ok := this.hosts[host]
+	if !ok {
+		stored = &hostCookies{
+			Address: (&url.URL{Scheme: address.Scheme, Host: address.Host}).String(),
+			Cookies: make(map[string]*storedCookie),
+		}
+		this.hosts[host] = stored
+	}
+	for _, nextCookie := range cookies {
+		key := nextCookie.Domain + ";" + nextCookie.Path + ";" + nextCookie.Name
+		if nextCookie.MaxAge < 0 || nextCookie.Value == "" {
+			delete(stored.Cookies, key)
+			continue
+		}
+		expires := nextCookie.Expires
+		if nextCookie.MaxAge > 0 {
+			expires = time.Now().Add(time.Duration(nextCookie.MaxAge) * time.Second)
+		}
+		stored.Cookies[key] = &storedCookie{
+			Name:     nextCookie.Name,
+			Value:    nextCookie.Value,
+			Path:     nextCookie.Path,
+			Domain:   nextCookie.Domain,
+			Expires:  expires.UTC(),
+			Secure:   nextCookie.Secure,
+			HttpOnly: nextCookie.HttpOnly,
+		}
+	}
+}
+
+func (this *persistentJar) restore(hosts map[string]*hostCookies) {
+	now := time.Now()
+	for _, nextHost := range hosts {
+		address, err := url.Parse(nextHost.Address)
+		if err != nil {
+			continue
+		}
+		cookies := make([]*http.Cookie, 0, len(nextHost.Cookies))
+		for _, nextCookie := range nextHost.Cookies {
+			if !nextCookie.Expires.IsZero() && nextCookie.Expires.Before(now) {
+				continue
+			}
+			cookies = append(cookies, &http.Cookie{
+				Name:     nextCookie.Name,
+				Value:    nextCookie.Value,
+				Path:     nextCookie.Path,
+				Domain:   nextCookie.Domain,
+				Expires:  nextCookie.Expires,
+				Secure:   nextCookie.Secure,
+				HttpOnly: nextCookie.HttpOnly,
+			})
+		}
+		this.SetCookies(address, cookies)
+	}
+}
+
+// Sealed cookie files start with this, then the salt the key was derived
+// with, then the nonce.
+const cookieMagic = "pagecrawl-cookies-1\n"
+
+const cookieSaltSize = 16
+
+// Seals with AES-GCM under a key derived from Cookies.Key with scrypt and a
+// salt of its own for every save. Without a key the cookies are stored as
+// plain JSON.
+func sealCookies(plain []byte) ([]byte, error) {
+	key := viper.GetString("Cookies.Key")
+	if key == "" {
+		return plain, nil
+	}
+	salt := make([]byte, cookieSaltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cookieCipher(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	sealed := append(append([]byte(cookieMagic), salt...), nonce...)
+	return aead.Seal(sealed, nonce, plain, nil), nil
+}
+
+func openCookies(sealed []byte) ([]byte, error) {
+	key := viper.GetString("Cookies.Key")
+	if key == "" {
+		return sealed, nil
+	}
+	if !bytes.HasPrefix(sealed, []byte(cookieMagic)) {
+		return nil, errors.New("cookie file isn't sealed with a key")
+	}
+	sealed = sealed[len(cookieMagic):]
+	if len(sealed) < cookieSaltSize {
+		return nil, errors.New("cookie file is too short")
+	}
+	aead, err := cookieCipher(key, sealed[:cookieSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	sealed = sealed[cookieSaltSize:]
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("cookie file is too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
+
+// The scrypt parameters recommended for interactive logins, which take
+// well under a second for the one save and load of a crawl.
+const (
+	cookieScryptN = 1 << 15
+	cookieScryptR = 8
+	cookieScryptP = 1
+)
+
+func cookieCipher(key string, salt []byte) (cipher.AEAD, error) {
+	derived, err := scrypt.Key([]byte(key), salt, cookieScryptN, cookieScryptR, cookieScryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (this *persistentJar) save(path string) error {
+	this.lock.Lock()
+	rawCookies, err := json.Marshal(this.hosts)
+	this.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	sealed, err := sealCookies(rawCookies)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, sealed, 0600)
+}
+
+func (this *persistentJar) load(path string) error {
+	sealed, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	rawCookies, err := openCookies(sealed)
+	if err != nil {
+		return fmt.Errorf("cannot decrypt cookies: %s", err.Error())
+	}
+	hosts := make(map[string]*hostCookies)
+	err = json.Unmarshal(rawCookies, &hosts)
+	if err != nil {
+		return err
+	}
+	this.restore(hosts)
+	return nil
+}
+
+// Reads cookies in the cookies.txt format browsers export and curl writes,
+// e.g. to reuse a session logged into by hand.
+func (this *persistentJar) importFile(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	count := 0
+	input := bufio.NewScanner(file)
+	for input.Scan() {
+		line := strings.TrimSpace(input.Text())
+		httpOnly := strings.HasPrefix(line, httpOnlyPrefix)
+		line = strings.TrimPrefix(line, httpOnlyPrefix)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return count, fmt.Errorf("expected 7 tab separated fields, got %d in %s", len(fields), line)
+		}
+		host := strings.TrimPrefix(fields[0], ".")
+		secure := strings.EqualFold(fields[3], "TRUE")
+		cookie := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Path:     fields[2],
+			Secure:   secure,
+			HttpOnly: httpOnly,
+		}
+		if strings.EqualFold(fields[1], "TRUE") {
+			cookie.Domain = host
+		}
+		if expires, err := strconv.ParseInt(fields[4], 10, 64); err == nil && expires > 0 {
+			cookie.Expires = time.Unix(expires, 0)
+		}
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+		this.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: "/"}, []*http.Cookie{cookie})
+		count++
+	}
+	return count, input.Err()
+}
+
+const httpOnlyPrefix = "#HttpOnly_"
+
+var cookies = newPersistentJar()
+
+func initCookies() {
+	if viper.GetBool("Cookies.Enabled") {
+		client.Jar = cookies
+	}
+	cookiePath := viper.GetString("Cookies.Path")
+	if cookiePath != "" {
+		if viper.GetString("Cookies.Key") == "" {
+			slog.Warn("Cookies are stored unencrypted, set Cookies.Key to encrypt them", "path", cookiePath)
+		}
+		client.Jar = cookies
+		err := cookies.load(os.ExpandEnv(cookiePath))
+		if err != nil {
+			panic(fmt.Sprintf("Cannot load cookies %s: %s", cookiePath, err.Error()))
+		}
+	}
+	err := importCookies(viper.GetString("Cookies.Import"))
+	if err != nil {
+		panic(err.Error())
+	}
+}
+
+func importCookies(path string) error {
+	if path == "" {
+		return nil
+	}
+	client.Jar = cookies
+	count, err := cookies.importFile(os.ExpandEnv(path))
+	if err != nil {
+		return fmt.Errorf("cannot import cookies %s: %s", path, err.Error())
+	}
+	slog.Info("Imported cookies", "count", count, "path", path)
+	return nil
+}
+
+func saveCookies() error {
+	cookiePath := viper.GetString("Cookies.Path")
+	if cookiePath == "" {
+		return nil
+	}
+	return cookies.save(os.ExpandEnv(cookiePath))
+}
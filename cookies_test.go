@@ -0,0 +1,104 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestSealCookies(t *testing.T) {
+	plain := []byte(`{"example.com":{"address":"https://example.com","cookies":{}}}`)
+	defer viper.Set("Cookies.Key", "")
+	tests := []struct {
+		name    string
+		sealKey string
+		openKey string
+		tamper  func(sealed []byte) []byte
+		opens   bool
+	}{
+		{name: "no key", opens: true},
+		{name: "same key", sealKey: "secret", openKey: "secret", opens: true},
+		{name: "wrong key", sealKey: "secret", openKey: "other"},
+		{name: "plain file with a key", openKey: "secret"},
+		{
+			name:    "other salt",
+			sealKey: "secret",
+			openKey: "secret",
+			tamper: func(sealed []byte) []byte {
+				sealed[len(cookieMagic)] ^= 1
+				return sealed
+			},
+		},
+		{
+			name:    "truncated",
+			sealKey: "secret",
+			openKey: "secret",
+			tamper: func(sealed []byte) []byte {
+				return sealed[:len(cookieMagic)+4]
+			},
+		},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			viper.Set("Cookies.Key", nextTest.sealKey)
+			sealed, err := sealCookies(plain)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if nextTest.sealKey != "" && bytes.Contains(sealed, []byte("example.com")) {
+				t.Error("sealCookies() left the cookies readable")
+			}
+			if nextTest.tamper != nil {
+				sealed = nextTest.tamper(sealed)
+			}
+			viper.Set("Cookies.Key", nextTest.openKey)
+			opened, err := openCookies(sealed)
+			if !nextTest.opens {
+				if err == nil {
+					t.Errorf("openCookies() = %q, want an error", opened)
+				}
+				return
+			}
+			if err != nil || !bytes.Equal(opened, plain) {
+				t.Errorf("openCookies() = %q, %v, want the cookies", opened, err)
+			}
+		})
+	}
+}
+
+func TestSealCookiesSalts(t *testing.T) {
+	viper.Set("Cookies.Key", "secret")
+	defer viper.Set("Cookies.Key", "")
+	first, err := sealCookies([]byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := sealCookies([]byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt := func(sealed []byte) []byte {
+		return sealed[len(cookieMagic) : len(cookieMagic)+cookieSaltSize]
+	}
+	if bytes.Equal(salt(first), salt(second)) {
+		t.Error("sealCookies() used the same salt twice")
+	}
+}
@@ -0,0 +1,213 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lunar-parklife/pagecrawl/pkg/crawler"
+	"github.com/spf13/viper"
+	"golang.org/x/net/html"
+)
+
+// Every address the crawl has queued, so none is fetched twice, however it
+// is spelled.
+type visitedSet struct {
+	lock      *sync.Mutex
+	addresses map[string]bool
+}
+
+var visited = &visitedSet{
+	lock:      &sync.Mutex{},
+	addresses: make(map[string]bool),
+}
+
+// Marks the address and tells whether it was new.
+func (this *visitedSet) mark(where string) bool {
+	key := normalizeAddress(where)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.addresses[key] {
+		return false
+	}
+	this.addresses[key] = true
+	return true
+}
+
+func (this *visitedSet) all() []string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	buf := make([]string, 0, len(this.addresses))
+	for nextAddress := range this.addresses {
+		buf = append(buf, nextAddress)
+	}
+	sort.Strings(buf)
+	return buf
+}
+
+func (this *visitedSet) restore(addresses []string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for _, nextAddress := range addresses {
+		this.addresses[normalizeAddress(nextAddress)] = true
+	}
+}
+
+func followable(address *url.URL) bool {
+	switch address.Scheme {
+	case "http", "https", "ftp", "ftps":
+		return address.Host != ""
+	}
+	return false
+}
+
+const (
+	scopeAny    = ""
+	scopeHost   = "host"
+	scopeDomain = "domain"
+)
+
+func withinDomain(host string, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// Whether a link found on a page may be followed. Links are compared with
+// the address the page was queued under, so the crawl can't leave the input
+// address's site through one of its redirects.
+func inScope(from string, to *url.URL) bool {
+	scope := strings.ToLower(viper.GetString("Crawl.Scope"))
+	allowed := parseFields(strings.ToLower(viper.GetString("Crawl.AllowDomains")))
+	if scope == scopeAny && len(allowed) == 0 {
+		return true
+	}
+	host := strings.ToLower(to.Hostname())
+	for _, nextDomain := range allowed {
+		if withinDomain(host, strings.TrimPrefix(nextDomain, ".")) {
+			return true
+		}
+	}
+	origin, err := url.Parse(from)
+	if err != nil {
+		return false
+	}
+	originHost := strings.ToLower(origin.Hostname())
+	switch scope {
+	case scopeHost:
+		return host == originHost
+	case scopeDomain:
+		return registrableDomain(host) == registrableDomain(originHost)
+	}
+	return false
+}
+
+func validScope(scope string) error {
+	switch scope {
+	case scopeAny, scopeHost, scopeDomain:
+		return nil
+	}
+	return fmt.Errorf("unknown scope %s, expected host or domain", scope)
+}
+
+// Queues the references of a page for fetching while it is closer to the
+// input than Crawl.Depth.
+func follow(target *crawlTarget, base *url.URL, references []crawler.Reference) {
+	if target.Depth >= viper.GetInt("Crawl.Depth") {
+		return
+	}
+	queued := 0
+	enclosures := viper.GetBool("Crawl.FollowEnclosures")
+	for _, nextReference := range references {
+		if !nextReference.Navigational() && !(enclosures && nextReference.Element == "enclosure") {
+			continue
+		}
+		resolved, err := crawler.ResolveReference(base, nextReference.Address)
+		if err != nil || !followable(resolved) || !inScope(target.Address, resolved) {
+			continue
+		}
+		address := normalizeAddress(resolved.String())
+		if !hostAllowed(address) || languages != nil && languages.skips(address) {
+			continue
+		}
+		if !visitedFor(target).mark(address) {
+			continue
+		}
+		enqueue(&crawlTarget{
+			Address:  address,
+			Depth:    target.Depth + 1,
+			Referrer: target.Address,
+			Job:      target.Job,
+			Run:      target.Run,
+		})
+		queued++
+	}
+	if queued > 0 {
+		slog.Debug("Queued links", "url", target.Address, "count", queued)
+	}
+}
+
+// Queues an address read from the input, unless it was already queued.
+func seedTarget(target *crawlTarget) {
+	if orphans != nil {
+		orphans.seeded(target.Address)
+	}
+	authSeeded(target.Address)
+	// Only requests without a body are the same whenever their address is.
+	if !visitedFor(target).mark(target.Address) && target.Body == nil {
+		slog.Debug("Not fetching again", "url", target.Address)
+		return
+	}
+	enqueue(target)
+}
+
+func enqueue(target *crawlTarget) {
+	if service != nil {
+		service.queued(target)
+	}
+	if ordering != nil {
+		ordering.queued(target.Address)
+	}
+	frontier.push(target)
+}
+
+func parseDepth(value string) (int, error) {
+	depth, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || depth < 0 {
+		return 0, fmt.Errorf("depth %s is not a number of hops", value)
+	}
+	return depth, nil
+}
+
+// Only HTML and plain text, for its text, are parsed as documents.
+// Everything else gets an empty one, so the audits that look at documents
+// find nothing in it.
+func parseDocument(mediaType string, rawResponse []byte) *html.Node {
+	if crawler.IsHTML(mediaType) || mediaType == "text/plain" {
+		doc, err := html.Parse(bytes.NewReader(rawResponse))
+		if err == nil {
+			return doc
+		}
+	}
+	return &html.Node{Type: html.DocumentNode}
+}
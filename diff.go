@@ -0,0 +1,192 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+type linkChanges struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+type crawlDiff struct {
+	Added   []string                `json:"added"`
+	Removed []string                `json:"removed"`
+	Changed []string                `json:"changed"`
+	Links   map[string]*linkChanges `json:"links"`
+}
+
+func decodeAssets(source io.Reader, each func(next *asset)) error {
+	decoder := json.NewDecoder(source)
+	for {
+		var rawAsset json.RawMessage
+		err := decoder.Decode(&rawAsset)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		next, err := decodeAsset(rawAsset)
+		if err != nil {
+			return err
+		}
+		each(next)
+	}
+}
+
+func readAssets(path string) (map[string]*asset, error) {
+	assetFile, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer assetFile.Close()
+	var source io.Reader = assetFile
+	if strings.HasSuffix(path, ".gz") {
+		unzipped, err := gzip.NewReader(assetFile)
+		if err != nil {
+			return nil, err
+		}
+		defer unzipped.Close()
+		source = unzipped
+	}
+	assets := make(map[string]*asset)
+	err = decodeAssets(source, func(next *asset) {
+		assets[next.Address] = next
+	})
+	return assets, err
+}
+
+// Pages are compared by hash when both runs recorded one, then by cached
+// body, and only by their references when neither is available.
+func contentChanged(before *asset, after *asset) bool {
+	if before.Hash != "" && after.Hash != "" {
+		return before.Hash != after.Hash
+	}
+	if len(before.Data) > 0 && len(after.Data) > 0 {
+		return !bytes.Equal(before.Data, after.Data)
+	}
+	return !sameReferences(before.References, after.References)
+}
+
+func sameReferences(before []string, after []string) bool {
+	changes := diffReferences(before, after)
+	return len(changes.Added) == 0 && len(changes.Removed) == 0
+}
+
+func diffReferences(before []string, after []string) *linkChanges {
+	changes := &linkChanges{
+		Added:   make([]string, 0),
+		Removed: make([]string, 0),
+	}
+	seenBefore := make(map[string]bool)
+	for _, nextReference := range before {
+		seenBefore[nextReference] = true
+	}
+	seenAfter := make(map[string]bool)
+	for _, nextReference := range after {
+		if !seenBefore[nextReference] && !seenAfter[nextReference] {
+			changes.Added = append(changes.Added, nextReference)
+		}
+		seenAfter[nextReference] = true
+	}
+	for nextReference := range seenBefore {
+		if !seenAfter[nextReference] {
+			changes.Removed = append(changes.Removed, nextReference)
+		}
+	}
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Removed)
+	return changes
+}
+
+func diffCrawls(before map[string]*asset, after map[string]*asset) *crawlDiff {
+	diff := &crawlDiff{
+		Added:   make([]string, 0),
+		Removed: make([]string, 0),
+		Changed: make([]string, 0),
+		Links:   make(map[string]*linkChanges),
+	}
+	for address, nextAfter := range after {
+		nextBefore, ok := before[address]
+		if !ok {
+			diff.Added = append(diff.Added, address)
+			continue
+		}
+		if contentChanged(nextBefore, nextAfter) {
+			diff.Changed = append(diff.Changed, address)
+		}
+		if changes := diffReferences(nextBefore.References, nextAfter.References); len(changes.Added)+len(changes.Removed) > 0 {
+			diff.Links[address] = changes
+		}
+	}
+	for address := range before {
+		if _, ok := after[address]; !ok {
+			diff.Removed = append(diff.Removed, address)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
+
+func runDiff(arguments []string) {
+	if len(arguments) != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: pagecrawl report diff <run A> <run B>")
+		os.Exit(1)
+	}
+	runs := make([]map[string]*asset, 0, 2)
+	for _, nextPath := range arguments {
+		assets, err := readAssets(nextPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot read crawl %s: %s\n", nextPath, err.Error())
+			os.Exit(1)
+		}
+		runs = append(runs, assets)
+	}
+	rawDiff, err := json.MarshalIndent(diffCrawls(runs[0], runs[1]), "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot encode diff: %s\n", err.Error())
+		os.Exit(1)
+	}
+	fmt.Println(string(rawDiff))
+}
+
+func runReport(arguments []string) {
+	if len(arguments) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: pagecrawl report <diff> [arguments...]")
+		os.Exit(1)
+	}
+	switch arguments[0] {
+	case "diff":
+		runDiff(arguments[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown report %s\n", arguments[0])
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,269 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Resolves the hosts crawled, through Network.Resolver if it is set, and
+// remembers the answers for Network.DNSCacheTTL seconds so large crawls
+// don't look the same hosts up over and over. Fetches of a host that is
+// being looked up wait for that lookup rather than starting their own.
+type dnsCache struct {
+	lock    *sync.Mutex
+	lookup  func(ctx context.Context, host string) ([]net.IP, time.Duration, error)
+	ttl     time.Duration
+	entries map[string]*dnsEntry
+}
+
+type dnsEntry struct {
+	ready   chan struct{}
+	ips     []net.IP
+	err     error
+	expires time.Time
+}
+
+var resolver *dnsCache
+
+func initResolver() {
+	server := viper.GetString("Network.Resolver")
+	ttl := timeout("Network.DNSCacheTTL")
+	if server == "" && ttl <= 0 {
+		resolver = nil
+		return
+	}
+	resolver = &dnsCache{
+		lock:    &sync.Mutex{},
+		lookup:  systemLookup,
+		ttl:     ttl,
+		entries: make(map[string]*dnsEntry),
+	}
+	switch {
+	case strings.HasPrefix(server, "https://") || strings.HasPrefix(server, "http://"):
+		resolver.lookup = func(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+			return dohLookup(ctx, server, host)
+		}
+	case server != "":
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		custom := &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+				return directDialer.DialContext(ctx, network, server)
+			},
+		}
+		resolver.lookup = func(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+			return resolverLookup(ctx, custom, host)
+		}
+	}
+}
+
+func systemLookup(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	return resolverLookup(ctx, net.DefaultResolver, host)
+}
+
+// Go's resolver doesn't say how long its answers are good for, so they are
+// kept for as long as the cache keeps anything.
+func resolverLookup(ctx context.Context, resolver *net.Resolver, host string) ([]net.IP, time.Duration, error) {
+	resolved, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, 0, err
+	}
+	ips := make([]net.IP, 0, len(resolved))
+	for _, nextAddress := range resolved {
+		ips = append(ips, nextAddress.IP)
+	}
+	return ips, 0, nil
+}
+
+// Answers found in the cache are shared, so callers must not change them.
+func (this *dnsCache) resolve(ctx context.Context, host string) ([]net.IP, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	this.lock.Lock()
+	entry, ok := this.entries[host]
+	if ok {
+		select {
+		case <-entry.ready:
+			ok = time.Now().Before(entry.expires)
+		default:
+		}
+	}
+	if !ok {
+		entry = &dnsEntry{ready: make(chan struct{})}
+		this.entries[host] = entry
+		this.lock.Unlock()
+		this.fill(host, entry)
+	} else {
+		this.lock.Unlock()
+	}
+	select {
+	case <-entry.ready:
+		return entry.ips, entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Looks the host up apart from whichever fetch asked first, so it giving
+// up doesn't fail the others waiting. Failures aren't kept.
+func (this *dnsCache) fill(host string, entry *dnsEntry) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout("Network.TimeoutConnect"))
+	defer cancel()
+	ips, ttl, err := this.lookup(ctx, host)
+	if err == nil && len(ips) == 0 {
+		err = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	entry.ips, entry.err = ips, err
+	if err == nil {
+		if ttl <= 0 || ttl > this.ttl {
+			ttl = this.ttl
+		}
+		entry.expires = time.Now().Add(ttl)
+	}
+	close(entry.ready)
+}
+
+// Looks up the host, IP addresses standing for themselves.
+func lookupHost(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	if resolver == nil {
+		ips, _, err := systemLookup(ctx, host)
+		return ips, err
+	}
+	return resolver.resolve(ctx, host)
+}
+
+// Connects to the first address the host resolves to that will have it.
+func dialResolved(ctx context.Context, network string, address string) (net.Conn, error) {
+	if resolver == nil {
+		return directDialer.DialContext(ctx, network, address)
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := lookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, nextIP := range ips {
+		var conn net.Conn
+		conn, err = directDialer.DialContext(ctx, network, net.JoinHostPort(nextIP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// Asks a DNS over HTTPS server, as RFC 8484 has it, for the host's IPv4
+// and IPv6 addresses. The answers are good for the lowest TTL among them.
+func dohLookup(ctx context.Context, server string, host string) ([]net.IP, time.Duration, error) {
+	name, err := dnsmessage.NewName(host + ".")
+	if err != nil {
+		return nil, 0, err
+	}
+	ips := make([]net.IP, 0)
+	var ttl time.Duration
+	var lastErr error
+	for _, nextType := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		answers, err := dohQuery(ctx, server, name, nextType)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, nextAnswer := range answers {
+			switch body := nextAnswer.Body.(type) {
+			case *dnsmessage.AResource:
+				ips = append(ips, net.IP(body.A[:]))
+			case *dnsmessage.AAAAResource:
+				ips = append(ips, net.IP(body.AAAA[:]))
+			default:
+				continue
+			}
+			answerTTL := time.Duration(nextAnswer.Header.TTL) * time.Second
+			if ttl == 0 || answerTTL < ttl {
+				ttl = answerTTL
+			}
+		}
+	}
+	if len(ips) == 0 && lastErr != nil {
+		return nil, 0, lastErr
+	}
+	return ips, ttl, nil
+}
+
+func dohQuery(ctx context.Context, server string, name dnsmessage.Name, kind dnsmessage.Type) ([]dnsmessage.Resource, error) {
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  name,
+			Type:  kind,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, server, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/dns-message")
+	request.Header.Set("Accept", "application/dns-message")
+	response, err := serviceClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DNS server %s answered %s", server, response.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	var reply dnsmessage.Message
+	err = reply.Unpack(body)
+	if err != nil {
+		return nil, err
+	}
+	switch reply.RCode {
+	case dnsmessage.RCodeSuccess:
+		return reply.Answers, nil
+	case dnsmessage.RCodeNameError:
+		return nil, &net.DNSError{Err: "no such host", Name: strings.TrimSuffix(name.String(), "."), Server: server, IsNotFound: true}
+	}
+	return nil, fmt.Errorf("DNS server %s answered %s", server, reply.RCode)
+}
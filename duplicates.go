@@ -0,0 +1,219 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lunar-parklife/pagecrawl/pkg/crawler"
+	"github.com/spf13/viper"
+	"golang.org/x/net/html"
+)
+
+const shingleSize = 3
+
+func contentHash(body []byte) string {
+	digest := sha256.Sum256(body)
+	return hex.EncodeToString(digest[:])
+}
+
+// Fingerprints text so that near-identical texts end up a few bits apart.
+func simhash(text string) uint64 {
+	words := tokenize(text)
+	shingles := make([]string, 0)
+	for i := 0; i+shingleSize <= len(words); i++ {
+		shingles = append(shingles, strings.Join(words[i:i+shingleSize], " "))
+	}
+	if len(shingles) == 0 && len(words) > 0 {
+		shingles = append(shingles, strings.Join(words, " "))
+	}
+	weights := [64]int{}
+	for _, nextShingle := range shingles {
+		hasher := fnv.New64a()
+		hasher.Write([]byte(nextShingle))
+		sum := hasher.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+	var fingerprint uint64
+	for bit := 0; bit < 64; bit++ {
+		if weights[bit] > 0 {
+			fingerprint |= 1 << bit
+		}
+	}
+	return fingerprint
+}
+
+func canonicalLink(where string, doc *html.Node) string {
+	if doc.Type == html.ElementNode && doc.Data == "link" && strings.EqualFold(crawler.Attribute(doc, "rel"), "canonical") {
+		base, err := url.Parse(where)
+		if err != nil {
+			return ""
+		}
+		resolved, err := crawler.ResolveReference(base, crawler.Attribute(doc, "href"))
+		if err != nil {
+			return ""
+		}
+		return resolved.String()
+	}
+	for next := doc.FirstChild; next != nil; next = next.NextSibling {
+		if canonical := canonicalLink(where, next); canonical != "" {
+			return canonical
+		}
+	}
+	return ""
+}
+
+type duplicatePage struct {
+	address   string
+	hash      string
+	simhash   uint64
+	canonical string
+}
+
+type duplicateCluster struct {
+	Exact     bool     `json:"exact"`
+	Canonical string   `json:"canonical"`
+	Addresses []string `json:"addresses"`
+}
+
+type duplicateReport struct {
+	pages []*duplicatePage
+}
+
+func newDuplicateReport() *duplicateReport {
+	return &duplicateReport{
+		pages: make([]*duplicatePage, 0),
+	}
+}
+
+func (this *duplicateReport) name() string {
+	return "duplicates"
+}
+
+func (this *duplicateReport) observe(asset *asset) {
+	if asset.Hash == "" {
+		return
+	}
+	fingerprint, _ := strconv.ParseUint(asset.Simhash, 16, 64)
+	this.pages = append(this.pages, &duplicatePage{
+		address:   asset.Address,
+		hash:      asset.Hash,
+		simhash:   fingerprint,
+		canonical: asset.Canonical,
+	})
+}
+
+func (this *duplicateReport) summary() any {
+	distance := viper.GetInt("Audit.NearDuplicateDistance")
+	parents := make([]int, len(this.pages))
+	for i := range parents {
+		parents[i] = i
+	}
+	var root func(i int) int
+	root = func(i int) int {
+		if parents[i] != i {
+			parents[i] = root(parents[i])
+		}
+		return parents[i]
+	}
+	for i := range this.pages {
+		for j := i + 1; j < len(this.pages); j++ {
+			left, right := this.pages[i], this.pages[j]
+			if left.hash == right.hash || bits.OnesCount64(left.simhash^right.simhash) <= distance {
+				parents[root(j)] = root(i)
+			}
+		}
+	}
+	members := make(map[int][]*duplicatePage)
+	for i, nextPage := range this.pages {
+		members[root(i)] = append(members[root(i)], nextPage)
+	}
+	clusters := make([]*duplicateCluster, 0)
+	for _, nextMembers := range members {
+		if len(nextMembers) < 2 {
+			continue
+		}
+		cluster := &duplicateCluster{
+			Exact:     true,
+			Canonical: suggestCanonical(nextMembers),
+			Addresses: make([]string, 0, len(nextMembers)),
+		}
+		for _, nextPage := range nextMembers {
+			cluster.Exact = cluster.Exact && nextPage.hash == nextMembers[0].hash
+			cluster.Addresses = append(cluster.Addresses, nextPage.address)
+		}
+		sort.Strings(cluster.Addresses)
+		clusters = append(clusters, cluster)
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Canonical < clusters[j].Canonical
+	})
+	return clusters
+}
+
+// Trusts a canonical link most of the cluster agrees on, or else prefers
+// HTTPS, then the fewest query parameters, then the shortest address.
+func suggestCanonical(pages []*duplicatePage) string {
+	votes := make(map[string]int)
+	for _, nextPage := range pages {
+		if nextPage.canonical != "" {
+			votes[nextPage.canonical]++
+		}
+	}
+	best, bestVotes := "", 0
+	for canonical, count := range votes {
+		if count > bestVotes || (count == bestVotes && canonical < best) {
+			best, bestVotes = canonical, count
+		}
+	}
+	if best != "" {
+		return best
+	}
+	rank := func(address string) string {
+		parsed, err := url.Parse(address)
+		if err != nil {
+			return "9" + address
+		}
+		secure := "1"
+		if parsed.Scheme == "https" {
+			secure = "0"
+		}
+		return fmt.Sprintf("%s%04d%08d%s", secure, len(parsed.Query()), len(address), address)
+	}
+	best = pages[0].address
+	for _, nextPage := range pages[1:] {
+		if rank(nextPage.address) < rank(best) {
+			best = nextPage.address
+		}
+	}
+	return best
+}
@@ -0,0 +1,97 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"math/bits"
+	"testing"
+)
+
+const duplicateText = "The quick brown fox jumps over the lazy dog while the farmer watches from the porch of his old red barn, sipping coffee and wondering whether the rain will hold off until the harvest is in and the hay is safely stored away for the long winter ahead"
+
+func TestSimhashDistance(t *testing.T) {
+	tests := []struct {
+		name    string
+		other   string
+		maximum int
+		minimum int
+	}{
+		{name: "identical", other: duplicateText, minimum: 0, maximum: 0},
+		{name: "case and punctuation", other: "THE QUICK BROWN FOX, jumps over the lazy dog; while the farmer watches from the porch of his old red barn, sipping coffee and wondering whether the rain will hold off until the harvest is in and the hay is safely stored away for the long winter ahead!", minimum: 0, maximum: 0},
+		{name: "one word changed", other: "The quick brown fox jumps over the lazy dog while the farmer watches from the porch of his old red barn, sipping tea and wondering whether the rain will hold off until the harvest is in and the hay is safely stored away for the long winter ahead", minimum: 0, maximum: 12},
+		{name: "unrelated", other: "Quarterly earnings rose sharply as the company expanded its cloud services into new markets across Europe and Asia, analysts said on Tuesday, citing strong demand from enterprise customers and a weaker dollar", minimum: 16, maximum: 64},
+	}
+	fingerprint := simhash(duplicateText)
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			distance := bits.OnesCount64(fingerprint ^ simhash(nextTest.other))
+			if distance < nextTest.minimum || distance > nextTest.maximum {
+				t.Errorf("distance = %d, want between %d and %d", distance, nextTest.minimum, nextTest.maximum)
+			}
+		})
+	}
+}
+
+func TestSuggestCanonical(t *testing.T) {
+	tests := []struct {
+		name      string
+		pages     []*duplicatePage
+		canonical string
+	}{
+		{
+			name: "most agreed canonical link",
+			pages: []*duplicatePage{
+				{address: "https://example.com/a?ref=1", canonical: "https://example.com/a"},
+				{address: "https://example.com/a?ref=2", canonical: "https://example.com/a"},
+				{address: "https://example.com/b", canonical: "https://example.com/b"},
+			},
+			canonical: "https://example.com/a",
+		},
+		{
+			name: "https first",
+			pages: []*duplicatePage{
+				{address: "http://example.com/a"},
+				{address: "https://example.com/longer/path"},
+			},
+			canonical: "https://example.com/longer/path",
+		},
+		{
+			name: "fewest query parameters",
+			pages: []*duplicatePage{
+				{address: "https://example.com/a?x=1&y=2"},
+				{address: "https://example.com/a?utm_source=newsletter"},
+			},
+			canonical: "https://example.com/a?utm_source=newsletter",
+		},
+		{
+			name: "shortest address",
+			pages: []*duplicatePage{
+				{address: "https://example.com/index.html"},
+				{address: "https://example.com/"},
+			},
+			canonical: "https://example.com/",
+		},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			if canonical := suggestCanonical(nextTest.pages); canonical != nextTest.canonical {
+				t.Errorf("suggestCanonical() = %s, want %s", canonical, nextTest.canonical)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Appends assets to a file, buffering them until they are flushed, and
+// compressing them when the file is gzipped. Gzipped files opened more
+// than once hold a gzip member for each time, which gzip reads as one.
+// Once the file grows past File.MaxSize megabytes or gets older than
+// File.MaxAge hours, the sink moves on to another one. Rotated files are
+// named after the path with the date and a sequence number before its
+// extensions, so crawl.ndjson.gz becomes crawl-20240101-0001.ndjson.gz.
+type fileSink struct {
+	path       string
+	compressed bool
+	maxSize    int64
+	maxAge     time.Duration
+
+	file       *os.File
+	counted    *countingWriter
+	compressor *gzip.Writer
+	buffered   *bufio.Writer
+	opened     time.Time
+	written    int
+	date       string
+	seq        int
+}
+
+// Counts the bytes that make it to the file, after compression.
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (this *countingWriter) Write(p []byte) (int, error) {
+	written, err := this.writer.Write(p)
+	this.count += int64(written)
+	return written, err
+}
+
+func newFileSink(target string, compressed bool) (sink, error) {
+	if target == "" {
+		return nil, fmt.Errorf("file output has no path")
+	}
+	return &fileSink{
+		path:       target,
+		compressed: compressed || viper.GetBool("File.Gzip"),
+		maxSize:    viper.GetInt64("File.MaxSize") << 20,
+		maxAge:     time.Duration(viper.GetFloat64("File.MaxAge") * float64(time.Hour)),
+	}, nil
+}
+
+func (this *fileSink) rotating() bool {
+	return this.maxSize > 0 || this.maxAge > 0
+}
+
+// Splits the extensions off the file name, rather than just the last one,
+// so they stay together after the date and sequence number.
+func (this *fileSink) rotatedPath(date string, seq int) string {
+	dir, name := filepath.Split(this.path)
+	stem, extension := name, ""
+	if dot := strings.Index(name, "."); dot > 0 {
+		stem, extension = name[:dot], name[dot:]
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s-%s-%04d%s", stem, date, seq, extension))
+}
+
+// Rotated files are never appended to, the sequence number skipping past
+// any left by earlier crawls.
+func (this *fileSink) openNext() (*os.File, error) {
+	if !this.rotating() {
+		return os.OpenFile(this.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	}
+	date := time.Now().UTC().Format("20060102")
+	if date != this.date {
+		this.date = date
+		this.seq = 0
+	}
+	for {
+		this.seq++
+		file, err := os.OpenFile(this.rotatedPath(this.date, this.seq), os.O_EXCL|os.O_CREATE|os.O_WRONLY, 0644)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		return file, err
+	}
+}
+
+func (this *fileSink) Open() error {
+	file, err := this.openNext()
+	if err != nil {
+		return err
+	}
+	this.file = file
+	this.counted = &countingWriter{writer: file}
+	this.opened = time.Now()
+	this.written = 0
+	var writer io.Writer = this.counted
+	if this.compressed {
+		this.compressor = gzip.NewWriter(this.counted)
+		writer = this.compressor
+	}
+	this.buffered = bufio.NewWriter(writer)
+	return nil
+}
+
+// Sizes are of what has been flushed to the file, so with gzip they lag
+// the assets written by however much the compressor holds on to. Files
+// with nothing in them yet are kept whatever their age.
+func (this *fileSink) due() bool {
+	if this.written == 0 {
+		return false
+	}
+	if this.maxSize > 0 && this.counted.count >= this.maxSize {
+		return true
+	}
+	return this.maxAge > 0 && time.Since(this.opened) > this.maxAge
+}
+
+func (this *fileSink) Write(asset *asset, rawAsset []byte) error {
+	if this.file == nil {
+		err := this.Open()
+		if err != nil {
+			return err
+		}
+	} else if this.due() {
+		err := this.Close()
+		if err != nil {
+			return err
+		}
+		err = this.Open()
+		if err != nil {
+			return err
+		}
+	}
+	_, err := this.buffered.Write(rawAsset)
+	this.written++
+	return err
+}
+
+func (this *fileSink) Flush() error {
+	if this.file == nil {
+		return nil
+	}
+	err := this.buffered.Flush()
+	if err == nil && this.compressor != nil {
+		err = this.compressor.Flush()
+	}
+	return err
+}
+
+func (this *fileSink) Close() error {
+	if this.file == nil {
+		return nil
+	}
+	err := this.buffered.Flush()
+	if err == nil && this.compressor != nil {
+		err = this.compressor.Close()
+	}
+	closeErr := this.file.Close()
+	this.file = nil
+	this.compressor = nil
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func init() {
+	registerSink("file", "File", func(target string) (sink, error) {
+		return newFileSink(target, false)
+	})
+	registerSink("file-gzip", "File", func(target string) (sink, error) {
+		return newFileSink(target, true)
+	})
+}
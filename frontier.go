@@ -0,0 +1,361 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lunar-parklife/pagecrawl/pkg/crawler"
+	"github.com/spf13/viper"
+)
+
+const (
+	importantWeight = 10
+	inlinkWeight    = 1
+	depthWeight     = 1
+)
+
+type frontierEntry struct {
+	target   *crawlTarget
+	key      string
+	segments int
+	inlinks  int
+	bonus    int
+	order    int
+	index    int
+}
+
+func (this *frontierEntry) score() int {
+	return this.bonus + this.inlinks*inlinkWeight - this.segments*depthWeight
+}
+
+type frontierQueue []*frontierEntry
+
+func (this frontierQueue) Len() int {
+	return len(this)
+}
+
+// Ties go to whichever address was queued first.
+func (this frontierQueue) Less(i int, j int) bool {
+	if this[i].score() != this[j].score() {
+		return this[i].score() > this[j].score()
+	}
+	return this[i].order < this[j].order
+}
+
+func (this frontierQueue) Swap(i int, j int) {
+	this[i], this[j] = this[j], this[i]
+	this[i].index = i
+	this[j].index = j
+}
+
+func (this *frontierQueue) Push(value any) {
+	entry := value.(*frontierEntry)
+	entry.index = len(*this)
+	*this = append(*this, entry)
+}
+
+func (this *frontierQueue) Pop() any {
+	old := *this
+	entry := old[len(old)-1]
+	*this = old[:len(old)-1]
+	entry.index = -1
+	return entry
+}
+
+// Queues every address of the crawl for a fixed number of workers. While
+// prioritizing it hands out queued addresses best first: addresses score
+// higher the shallower their path, the more crawled pages link to them and
+// the more important patterns they match. Otherwise they go in the order
+// they were queued.
+type crawlFrontier struct {
+	lock       *sync.Mutex
+	ready      *sync.Cond
+	prioritize bool
+	queue      frontierQueue
+	queued     map[string]*frontierEntry
+	inlinks    map[string]int
+	important  []*regexp.Regexp
+	budget     int
+	handedOut  int
+	// What the workers are fetching right now.
+	inFlight map[*crawlTarget]bool
+	pushed   int
+	closed   bool
+	stopped  bool
+	// Fetches handed out but not yet finished, which may still queue the
+	// addresses they find.
+	active int
+	// Wakes the workers when the soonest address held back by a crawl
+	// window may go out.
+	timer  *time.Timer
+	wakeAt time.Time
+}
+
+var frontier *crawlFrontier
+
+func pathDepth(where string) int {
+	parsed, err := url.Parse(where)
+	if err != nil {
+		return 0
+	}
+	depth := 0
+	for _, nextSegment := range strings.Split(parsed.Path, "/") {
+		if nextSegment != "" {
+			depth++
+		}
+	}
+	return depth
+}
+
+func (this *crawlFrontier) push(target *crawlTarget) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.stopped {
+		return
+	}
+	entry := &frontierEntry{
+		target: target,
+		key:    normalizeAddress(target.Address),
+		order:  this.pushed,
+	}
+	this.pushed++
+	if this.prioritize {
+		entry.segments = pathDepth(target.Address)
+		entry.inlinks = this.inlinks[entry.key]
+		for _, nextPattern := range this.important {
+			if nextPattern.MatchString(target.Address) {
+				entry.bonus += importantWeight
+			}
+		}
+		this.queued[entry.key] = entry
+	}
+	heap.Push(&this.queue, entry)
+	this.ready.Signal()
+}
+
+// Counts a crawled page's links towards the addresses it links to, whether
+// or not they are queued yet.
+func (this *crawlFrontier) linked(where string, references []string) {
+	if !this.prioritize {
+		return
+	}
+	base, err := url.Parse(where)
+	if err != nil {
+		return
+	}
+	targets := make(map[string]bool)
+	for _, nextReference := range references {
+		resolved, err := crawler.ResolveReference(base, nextReference)
+		if err != nil {
+			continue
+		}
+		targets[normalizeAddress(resolved.String())] = true
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for nextTarget := range targets {
+		this.inlinks[nextTarget]++
+		if entry, ok := this.queued[nextTarget]; ok && entry.index >= 0 {
+			entry.inlinks++
+			heap.Fix(&this.queue, entry.index)
+		}
+	}
+}
+
+// Addresses whose host is outside its crawl window stay queued until the
+// window opens, those of paused jobs until the job is resumed, and the best
+// address that may be crawled now goes first.
+func (this *crawlFrontier) pop() (*crawlTarget, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for {
+		for len(this.queue) == 0 && (!this.closed || this.active > 0) {
+			this.ready.Wait()
+		}
+		if len(this.queue) == 0 || this.stopped {
+			return nil, false
+		}
+		if this.budget > 0 && this.handedOut >= this.budget {
+			slog.Warn("Crawl budget used up, dropping queued addresses", "budget", this.budget, "dropped", len(this.queue))
+			this.queue = this.queue[:0]
+			return nil, false
+		}
+		held := make([]*frontierEntry, 0)
+		var entry *frontierEntry
+		soonest := time.Duration(-1)
+		for len(this.queue) > 0 {
+			next := heap.Pop(&this.queue).(*frontierEntry)
+			if gateFor(next.target.Job).isPaused() {
+				held = append(held, next)
+				continue
+			}
+			wait := untilWindowOpens(next.target.Address)
+			if wait <= 0 {
+				entry = next
+				break
+			}
+			if soonest < 0 || wait < soonest {
+				soonest = wait
+			}
+			held = append(held, next)
+		}
+		for _, nextHeld := range held {
+			heap.Push(&this.queue, nextHeld)
+		}
+		if entry != nil {
+			delete(this.queued, entry.key)
+			this.handedOut++
+			this.active++
+			this.inFlight[entry.target] = true
+			return entry.target, true
+		}
+		// Addresses held only by paused jobs wait to be woken by a resume.
+		if soonest >= 0 {
+			this.wakeIn(soonest)
+		}
+		this.ready.Wait()
+	}
+}
+
+// There is only ever the one timer, moved earlier when an address may go
+// out sooner than the timer is set for and left alone otherwise.
+func (this *crawlFrontier) wakeIn(wait time.Duration) {
+	at := time.Now().Add(wait)
+	if !this.wakeAt.IsZero() && !at.Before(this.wakeAt) {
+		return
+	}
+	this.wakeAt = at
+	if this.timer == nil {
+		this.timer = time.AfterFunc(wait, this.wake)
+		return
+	}
+	this.timer.Reset(wait)
+}
+
+func (this *crawlFrontier) wake() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.wakeAt = time.Time{}
+	this.ready.Broadcast()
+}
+
+func (this *crawlFrontier) finished(target *crawlTarget) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.inFlight, target)
+	this.active--
+	this.ready.Broadcast()
+}
+
+// Hands nothing out anymore and refuses anything queued from now on,
+// telling how many addresses are left queued.
+func (this *crawlFrontier) stop() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	dropped := len(this.queue)
+	this.stopped = true
+	this.closed = true
+	if this.timer != nil {
+		this.timer.Stop()
+		this.wakeAt = time.Time{}
+	}
+	this.ready.Broadcast()
+	return dropped
+}
+
+// Everything queued or still being fetched, best first.
+func (this *crawlFrontier) pending() []*crawlTarget {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	buf := make([]*crawlTarget, 0, len(this.inFlight)+len(this.queue))
+	for nextTarget := range this.inFlight {
+		buf = append(buf, nextTarget)
+	}
+	entries := make(frontierQueue, len(this.queue))
+	copy(entries, this.queue)
+	sort.Slice(entries, entries.Less)
+	for _, nextEntry := range entries {
+		buf = append(buf, nextEntry.target)
+	}
+	return buf
+}
+
+func (this *crawlFrontier) close() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.closed = true
+	this.ready.Broadcast()
+}
+
+// Starts the workers that fetch what the frontier hands out. The group is
+// done once the frontier is closed and drained.
+func (this *crawlFrontier) run(workers int, group *sync.WaitGroup) {
+	for i := 0; i < workers; i++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			for {
+				target, ok := this.pop()
+				if !ok {
+					return
+				}
+				group.Add(1)
+				fetch(target, group)
+				this.finished(target)
+				if service != nil {
+					service.finished(target)
+				}
+			}
+		}()
+	}
+}
+
+func initFrontier() {
+	lock := &sync.Mutex{}
+	frontier = &crawlFrontier{
+		lock:       lock,
+		ready:      sync.NewCond(lock),
+		prioritize: viper.GetBool("Frontier.Prioritize"),
+		queue:      make(frontierQueue, 0),
+		queued:     make(map[string]*frontierEntry),
+		inlinks:    make(map[string]int),
+		important:  make([]*regexp.Regexp, 0),
+		budget:     viper.GetInt("Frontier.Budget"),
+		inFlight:   make(map[*crawlTarget]bool),
+	}
+	for _, nextPattern := range strings.Split(viper.GetString("Frontier.Important"), ",") {
+		nextPattern = strings.TrimSpace(nextPattern)
+		if nextPattern == "" {
+			continue
+		}
+		compiled, err := regexp.Compile(nextPattern)
+		if err != nil {
+			panic(fmt.Sprintf("Invalid important pattern %s: %s", nextPattern, err.Error()))
+		}
+		frontier.important = append(frontier.important, compiled)
+	}
+}
@@ -0,0 +1,199 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"container/heap"
+	"reflect"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestFrontier(prioritize bool, important ...string) *crawlFrontier {
+	lock := &sync.Mutex{}
+	created := &crawlFrontier{
+		lock:       lock,
+		ready:      sync.NewCond(lock),
+		prioritize: prioritize,
+		queue:      make(frontierQueue, 0),
+		queued:     make(map[string]*frontierEntry),
+		inlinks:    make(map[string]int),
+		important:  make([]*regexp.Regexp, 0),
+		inFlight:   make(map[*crawlTarget]bool),
+	}
+	for _, nextPattern := range important {
+		created.important = append(created.important, regexp.MustCompile(nextPattern))
+	}
+	return created
+}
+
+func popAll(t *testing.T, popped *crawlFrontier, count int) []string {
+	buf := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		target, ok := popped.pop()
+		if !ok {
+			t.Fatalf("pop() handed out %d addresses, want %d", i, count)
+		}
+		buf = append(buf, target.Address)
+	}
+	return buf
+}
+
+func TestPathDepth(t *testing.T) {
+	tests := []struct {
+		address string
+		depth   int
+	}{
+		{address: "https://example.com", depth: 0},
+		{address: "https://example.com/", depth: 0},
+		{address: "https://example.com/a/b/c", depth: 3},
+		{address: "https://example.com/a//b/", depth: 2},
+		{address: "https://example.com/a?x=/y/z", depth: 1},
+	}
+	for _, nextTest := range tests {
+		if depth := pathDepth(nextTest.address); depth != nextTest.depth {
+			t.Errorf("pathDepth(%s) = %d, want %d", nextTest.address, depth, nextTest.depth)
+		}
+	}
+}
+
+func TestFrontierOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		prioritize bool
+		important  []string
+		queued     []string
+		links      map[string][]string
+		want       []string
+	}{
+		{
+			name:   "queued order without prioritizing",
+			queued: []string{"https://example.com/a/b/c", "https://example.com/a", "https://example.com/"},
+			want:   []string{"https://example.com/a/b/c", "https://example.com/a", "https://example.com/"},
+		},
+		{
+			name:       "shallow paths first",
+			prioritize: true,
+			queued:     []string{"https://example.com/a/b/c", "https://example.com/a", "https://example.com/a/b"},
+			want:       []string{"https://example.com/a", "https://example.com/a/b", "https://example.com/a/b/c"},
+		},
+		{
+			name:       "ties in queued order",
+			prioritize: true,
+			queued:     []string{"https://example.com/b", "https://example.com/a", "https://example.com/c"},
+			want:       []string{"https://example.com/b", "https://example.com/a", "https://example.com/c"},
+		},
+		{
+			name:       "important patterns first",
+			prioritize: true,
+			important:  []string{`/products/`},
+			queued:     []string{"https://example.com/about", "https://example.com/shop/products/1"},
+			want:       []string{"https://example.com/shop/products/1", "https://example.com/about"},
+		},
+		{
+			name:       "inlinks raise queued addresses",
+			prioritize: true,
+			queued:     []string{"https://example.com/a", "https://example.com/b"},
+			links: map[string][]string{
+				"https://example.com/one": {"/b"},
+				"https://example.com/two": {"b", "/b#top"},
+			},
+			want: []string{"https://example.com/b", "https://example.com/a"},
+		},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			tested := newTestFrontier(nextTest.prioritize, nextTest.important...)
+			for _, nextAddress := range nextTest.queued {
+				tested.push(&crawlTarget{Address: nextAddress})
+			}
+			for nextPage, nextReferences := range nextTest.links {
+				tested.linked(nextPage, nextReferences)
+			}
+			if got := popAll(t, tested, len(nextTest.want)); !reflect.DeepEqual(got, nextTest.want) {
+				t.Errorf("popped %v, want %v", got, nextTest.want)
+			}
+		})
+	}
+}
+
+func TestFrontierHoldsPausedJobs(t *testing.T) {
+	tested := newTestFrontier(false)
+	gate := gateFor("frontier-test-paused")
+	gate.set(true)
+	tested.push(&crawlTarget{Address: "https://example.com/held", Job: "frontier-test-paused"})
+	tested.push(&crawlTarget{Address: "https://example.com/free", Job: "frontier-test-free"})
+	if got := popAll(t, tested, 1); got[0] != "https://example.com/free" {
+		t.Fatalf("popped %s, want the address of the job that isn't paused", got[0])
+	}
+	gate.set(false)
+	if got := popAll(t, tested, 1); got[0] != "https://example.com/held" {
+		t.Fatalf("popped %s after resuming, want the held address", got[0])
+	}
+}
+
+func TestFrontierQueueHeap(t *testing.T) {
+	queue := make(frontierQueue, 0)
+	scores := []int{3, -1, 7, 0, 7}
+	for i, nextScore := range scores {
+		heap.Push(&queue, &frontierEntry{bonus: nextScore, order: i})
+	}
+	want := []int{2, 4, 0, 3, 1}
+	for _, nextOrder := range want {
+		entry := heap.Pop(&queue).(*frontierEntry)
+		if entry.order != nextOrder || entry.index != -1 {
+			t.Fatalf("popped entry %d at index %d, want entry %d at index -1", entry.order, entry.index, nextOrder)
+		}
+	}
+}
+
+func TestFrontierWakeIn(t *testing.T) {
+	tests := []struct {
+		name  string
+		waits []time.Duration
+		want  time.Duration
+	}{
+		{name: "one", waits: []time.Duration{time.Hour}, want: time.Hour},
+		{name: "sooner moves it", waits: []time.Duration{3 * time.Hour, time.Hour}, want: time.Hour},
+		{name: "later leaves it", waits: []time.Duration{time.Hour, 2 * time.Hour, 3 * time.Hour}, want: time.Hour},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			tested := newTestFrontier(false)
+			start := time.Now()
+			var timer *time.Timer
+			for _, nextWait := range nextTest.waits {
+				tested.wakeIn(nextWait)
+				if timer == nil {
+					timer = tested.timer
+				} else if tested.timer != timer {
+					t.Fatal("wakeIn() made another timer")
+				}
+			}
+			if at := tested.wakeAt.Sub(start); at < nextTest.want || at > nextTest.want+time.Minute {
+				t.Errorf("wakeIn() wakes after %s, want %s", at, nextTest.want)
+			}
+			tested.stop()
+			if !tested.wakeAt.IsZero() {
+				t.Error("stop() left the timer set")
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+	"github.com/lunar-parklife/pagecrawl/pkg/crawler"
+	"github.com/spf13/viper"
+)
+
+const ftpTimeout = time.Minute
+
+func dialFTP(address *url.URL) (*ftp.ServerConn, error) {
+	host := address.Host
+	options := []ftp.DialOption{ftp.DialWithTimeout(ftpTimeout)}
+	if address.Scheme == "ftps" {
+		if address.Port() == "" {
+			host = net.JoinHostPort(address.Hostname(), "990")
+		}
+		config := tlsConfig.Clone()
+		config.ServerName = address.Hostname()
+		options = append(options, ftp.DialWithTLS(config))
+	} else if address.Port() == "" {
+		host = net.JoinHostPort(address.Hostname(), "21")
+	}
+	options = append(options, ftp.DialWithDialFunc(func(network string, address string) (net.Conn, error) {
+		return dialContext(context.Background(), network, address)
+	}))
+	conn, err := ftp.Dial(host, options...)
+	if err != nil {
+		return nil, err
+	}
+	// Anonymous FTP etiquette asks for contact info as the password.
+	user, password := "anonymous", viper.GetString("Network.From")
+	if address.User != nil {
+		user = address.User.Username()
+		password, _ = address.User.Password()
+	}
+	err = conn.Login(user, password)
+	if err != nil {
+		conn.Quit()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Directories become assets referencing their entries, anything else
+// becomes an asset of the file itself. The file's contents are returned
+// whether or not -c puts them into the asset, for the page monitor.
+func retrieveFTP(where string) (*asset, []byte, error) {
+	address, err := url.Parse(where)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := dialFTP(address)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Quit()
+	target := address.Path
+	if target == "" {
+		target = "/"
+	}
+	found := &asset{
+		Accessed:   time.Now().UTC(),
+		Address:    where,
+		References: make([]string, 0),
+	}
+	if conn.ChangeDir(target) == nil {
+		entries, err := conn.List("")
+		if err != nil {
+			return nil, nil, err
+		}
+		base := *address
+		base.Path = strings.TrimSuffix(target, "/") + "/"
+		for _, nextEntry := range entries {
+			if nextEntry.Name == "." || nextEntry.Name == ".." {
+				continue
+			}
+			entryAddress := base
+			entryAddress.Path = path.Join(base.Path, nextEntry.Name)
+			if nextEntry.Type == ftp.EntryTypeFolder {
+				entryAddress.Path += "/"
+			}
+			found.References = append(found.References, entryAddress.String())
+		}
+		return found, nil, nil
+	}
+	file, err := conn.Retr(target)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	rawFile, truncated, err := readBody(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	found.Truncated = truncated
+	found.ContentLength = int64(len(rawFile))
+	found.Hash = contentHash(rawFile)
+	if shouldCache {
+		found.Data = rawFile
+	}
+	return found, rawFile, nil
+}
+
+// Takes the same turns with the host as HTTP fetches do, and queues the
+// entries of directories like links.
+func fetchFTP(target *crawlTarget) {
+	where := target.Address
+	host := politenessFor(hostOf(where))
+	host.wait()
+	start := time.Now()
+	found, rawFile, err := retrieveFTP(where)
+	host.record(time.Since(start), err != nil)
+	if stats != nil {
+		var size int64
+		if err == nil {
+			size = found.ContentLength
+		}
+		stats.fetched(where, 0, size, time.Since(start), err)
+	}
+	if service != nil {
+		service.attempted(target, 0, err)
+	}
+	if retries != nil {
+		if err != nil {
+			retries.failed(target, 0, err)
+		} else {
+			retries.succeeded(where)
+		}
+	}
+	if err != nil {
+		slog.Error("Cannot fetch", "url", where, failed(err))
+		if pageMonitor != nil {
+			pageMonitor.check(where, 0, nil, nil, err)
+		}
+		return
+	}
+	found.Job, found.Run = target.Job, target.Run
+	found.Depth = target.Depth
+	found.Referrer = target.Referrer
+	if pageMonitor != nil {
+		pageMonitor.check(where, 0, rawFile, found.References, nil)
+	}
+	observe(found)
+	if !output(found) {
+		return
+	}
+	slog.Info("Fetched", "url", where)
+	if len(found.References) > 0 {
+		base, err := url.Parse(where)
+		if err != nil {
+			return
+		}
+		frontier.linked(where, found.References)
+		if discoveries != nil {
+			discoveries.found(where, found.References)
+		}
+		entries := make([]crawler.Reference, len(found.References))
+		for i, nextReference := range found.References {
+			entries[i] = crawler.Reference{Address: nextReference, Element: "a", Attribute: "href"}
+		}
+		follow(target, base, entries)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+type graphEdge struct {
+	from int
+	to   int
+}
+
+// Collects which page references which during the crawl, and writes the
+// link graph as Graphviz DOT or, for .graphml files, GraphML at the end.
+type linkGraph struct {
+	lock  *sync.Mutex
+	path  string
+	nodes map[string]int
+	names []string
+	edges map[graphEdge]bool
+	order []graphEdge
+}
+
+var graph *linkGraph
+
+func (this *linkGraph) node(address string) int {
+	id, ok := this.nodes[address]
+	if !ok {
+		id = len(this.names)
+		this.nodes[address] = id
+		this.names = append(this.names, address)
+	}
+	return id
+}
+
+func (this *linkGraph) add(asset *asset) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	from := this.node(asset.Address)
+	for _, nextReference := range asset.References {
+		edge := graphEdge{
+			from: from,
+			to:   this.node(withoutFragment(nextReference)),
+		}
+		if !this.edges[edge] {
+			this.edges[edge] = true
+			this.order = append(this.order, edge)
+		}
+	}
+}
+
+func (this *linkGraph) save() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	file, err := os.Create(this.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+	if strings.EqualFold(filepath.Ext(this.path), ".graphml") {
+		this.writeGraphML(writer)
+	} else {
+		this.writeDOT(writer)
+	}
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func (this *linkGraph) writeDOT(writer *bufio.Writer) {
+	writer.WriteString("digraph crawl {\n")
+	for id, nextName := range this.names {
+		fmt.Fprintf(writer, "\tn%d [label=%s];\n", id, strconv.Quote(nextName))
+	}
+	for _, nextEdge := range this.order {
+		fmt.Fprintf(writer, "\tn%d -> n%d;\n", nextEdge.from, nextEdge.to)
+	}
+	writer.WriteString("}\n")
+}
+
+func (this *linkGraph) writeGraphML(writer *bufio.Writer) {
+	writer.WriteString(xml.Header)
+	writer.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	writer.WriteString(`  <key id="address" for="node" attr.name="address" attr.type="string"/>` + "\n")
+	writer.WriteString(`  <graph id="crawl" edgedefault="directed">` + "\n")
+	for id, nextName := range this.names {
+		fmt.Fprintf(writer, `    <node id="n%d"><data key="address">`, id)
+		xml.EscapeText(writer, []byte(nextName))
+		writer.WriteString("</data></node>\n")
+	}
+	for i, nextEdge := range this.order {
+		fmt.Fprintf(writer, `    <edge id="e%d" source="n%d" target="n%d"/>`+"\n", i, nextEdge.from, nextEdge.to)
+	}
+	writer.WriteString("  </graph>\n</graphml>\n")
+}
+
+func initGraph() {
+	path := viper.GetString("Output.Graph")
+	if path == "" {
+		graph = nil
+		return
+	}
+	graph = &linkGraph{
+		lock:  &sync.Mutex{},
+		path:  path,
+		nodes: make(map[string]int),
+		names: make([]string, 0),
+		edges: make(map[graphEdge]bool),
+		order: make([]graphEdge, 0),
+	}
+}
@@ -0,0 +1,243 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/spf13/viper"
+)
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	SSL     float64 `json:"ssl"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harRecorder struct {
+	lock    *sync.Mutex
+	entries []harEntry
+}
+
+var har *harRecorder
+
+func initHAR() {
+	if viper.GetString("HAR.Path") == "" {
+		return
+	}
+	har = &harRecorder{
+		lock:    &sync.Mutex{},
+		entries: make([]harEntry, 0),
+	}
+}
+
+func (this *requestTrace) harTimings(done time.Time) harTimings {
+	timings := harTimings{
+		Blocked: milliseconds(this.connStart, this.dnsStart),
+		DNS:     milliseconds(this.dnsStart, this.dnsDone),
+		Connect: milliseconds(this.connectStart, this.connectDone),
+		SSL:     milliseconds(this.tlsStart, this.tlsDone),
+		Send:    milliseconds(this.gotConn, this.wroteRequest),
+		Wait:    milliseconds(this.wroteRequest, this.firstByte),
+		Receive: milliseconds(this.firstByte, done),
+	}
+	if timings.Blocked < 0 {
+		timings.Blocked = milliseconds(this.connStart, this.gotConn)
+	}
+	// Send, wait and receive are required, so they can't be left unknown.
+	for _, nextTiming := range []*float64{&timings.Send, &timings.Wait, &timings.Receive} {
+		if *nextTiming < 0 {
+			*nextTiming = 0
+		}
+	}
+	return timings
+}
+
+func harHeaders(header http.Header) []harNameValue {
+	headers := make([]harNameValue, 0, len(header))
+	for nextName, nextValues := range header {
+		for _, nextValue := range nextValues {
+			headers = append(headers, harNameValue{Name: nextName, Value: nextValue})
+		}
+	}
+	return headers
+}
+
+func harCookies(cookies []*http.Cookie) []harNameValue {
+	pairs := make([]harNameValue, 0, len(cookies))
+	for _, nextCookie := range cookies {
+		pairs = append(pairs, harNameValue{Name: nextCookie.Name, Value: nextCookie.Value})
+	}
+	return pairs
+}
+
+func harEntryFor(response *http.Response, body []byte) harEntry {
+	request := response.Request
+	query := make([]harNameValue, 0)
+	for nextName, nextValues := range request.URL.Query() {
+		for _, nextValue := range nextValues {
+			query = append(query, harNameValue{Name: nextName, Value: nextValue})
+		}
+	}
+	entry := harEntry{
+		Request: harRequest{
+			Method:      request.Method,
+			URL:         request.URL.String(),
+			HTTPVersion: response.Proto,
+			Cookies:     harCookies(request.Cookies()),
+			Headers:     harHeaders(request.Header),
+			QueryString: query,
+			HeadersSize: -1,
+			BodySize:    int(request.ContentLength),
+		},
+		Response: harResponse{
+			Status:      response.StatusCode,
+			StatusText:  strings.TrimSpace(strings.TrimPrefix(response.Status, strconv.Itoa(response.StatusCode))),
+			HTTPVersion: response.Proto,
+			Cookies:     harCookies(response.Cookies()),
+			Headers:     harHeaders(response.Header),
+			Content: harContent{
+				Size:     -1,
+				MimeType: response.Header.Get("Content-Type"),
+			},
+			RedirectURL: response.Header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+	}
+	if body != nil {
+		entry.Response.Content.Size = len(body)
+		entry.Response.BodySize = len(body)
+		if viper.GetBool("HAR.Body") {
+			if utf8.Valid(body) {
+				entry.Response.Content.Text = string(body)
+			} else {
+				entry.Response.Content.Text = base64.StdEncoding.EncodeToString(body)
+				entry.Response.Content.Encoding = "base64"
+			}
+		}
+	}
+	return entry
+}
+
+// Records the final response along with every redirect that led to it.
+// Only the final hop has timings, the redirects are recorded by their
+// headers alone.
+func (this *harRecorder) record(start time.Time, trace *requestTrace, response *http.Response, body []byte) {
+	done := time.Now()
+	entries := make([]harEntry, 0)
+	for previous := response.Request.Response; previous != nil; previous = previous.Request.Response {
+		entry := harEntryFor(previous, nil)
+		entry.StartedDateTime = start
+		entries = append([]harEntry{entry}, entries...)
+	}
+	entry := harEntryFor(response, body)
+	entry.StartedDateTime = trace.connStart
+	if entry.StartedDateTime.IsZero() {
+		entry.StartedDateTime = start
+	}
+	entry.Timings = trace.harTimings(done)
+	entry.Time = milliseconds(entry.StartedDateTime, done)
+	entries = append(entries, entry)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.entries = append(this.entries, entries...)
+}
+
+func (this *harRecorder) save() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	rawHAR, err := json.MarshalIndent(map[string]harLog{
+		"log": {
+			Version: "1.2",
+			Creator: harCreator{Name: "pagecrawl", Version: "0.1.0"},
+			Entries: this.entries,
+		},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(viper.GetString("HAR.Path"), rawHAR, 0644)
+}
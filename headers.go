@@ -0,0 +1,50 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Sent with every request on top of From and User-Agent, which they can
+// replace.
+var extraHeaders = make(http.Header)
+
+// Takes a header the way it is written in a request, "Name: value".
+func addHeader(line string) error {
+	name, value, ok := strings.Cut(line, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return fmt.Errorf("expected a header like \"Name: value\", got %s", line)
+	}
+	extraHeaders.Add(name, os.ExpandEnv(strings.TrimSpace(value)))
+	return nil
+}
+
+// Values in the Headers section may refer to environment variables as
+// ${NAME}.
+func initHeaders() {
+	for nextName, nextValue := range viper.GetStringMapString("Headers") {
+		extraHeaders.Set(nextName, os.ExpandEnv(nextValue))
+	}
+}
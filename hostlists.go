@@ -0,0 +1,108 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// A list of host names read from a file, one per line. A *. prefix matches
+// every subdomain of the rest, but not the rest itself. Lines starting with
+// # are comments.
+type hostList struct {
+	exact     map[string]bool
+	wildcards []string
+}
+
+var (
+	allowedHosts *hostList
+	deniedHosts  *hostList
+)
+
+func readHostList(path string) (*hostList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	list := &hostList{
+		exact:     make(map[string]bool),
+		wildcards: make([]string, 0),
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "*.") {
+			list.wildcards = append(list.wildcards, line[1:])
+			continue
+		}
+		list.exact[line] = true
+	}
+	return list, scanner.Err()
+}
+
+func (this *hostList) matches(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if this.exact[host] {
+		return true
+	}
+	for _, nextWildcard := range this.wildcards {
+		if strings.HasSuffix(host, nextWildcard) {
+			return true
+		}
+	}
+	return false
+}
+
+// Denied hosts are never crawled, and while there is an allow list only the
+// hosts on it are.
+func hostAllowed(where string) bool {
+	host := hostOf(where)
+	if deniedHosts != nil && deniedHosts.matches(host) {
+		return false
+	}
+	return allowedHosts == nil || allowedHosts.matches(host)
+}
+
+func initHostLists() {
+	for _, nextList := range []struct {
+		key  string
+		list **hostList
+	}{
+		{"Network.AllowList", &allowedHosts},
+		{"Network.DenyList", &deniedHosts},
+	} {
+		path := viper.GetString(nextList.key)
+		if path == "" {
+			continue
+		}
+		list, err := readHostList(path)
+		if err != nil {
+			panic(fmt.Sprintf("Cannot read host list %s: %s", path, err.Error()))
+		}
+		*nextList.list = list
+	}
+}
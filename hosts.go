@@ -0,0 +1,191 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lunar-parklife/pagecrawl/pkg/crawler"
+	"github.com/spf13/viper"
+	"golang.org/x/net/html"
+)
+
+// Settings for a single host, read from the JSON file in Network.HostsFile
+// and keyed by host name.
+type hostConfig struct {
+	Login    *loginConfig `json:"login"`
+	Auth     *authConfig  `json:"auth"`
+	Steps    []fetchStep  `json:"steps"`
+	Windows  []string     `json:"windows"`
+	TimeZone string       `json:"timeZone"`
+
+	windows  []timeWindow
+	location *time.Location
+}
+
+// A step is one request made before a host is crawled, e.g. to log in.
+// Values may refer to environment variables as ${NAME}.
+type fetchStep struct {
+	Method     string            `json:"method"`
+	URL        string            `json:"url"`
+	Form       map[string]string `json:"form"`
+	Headers    map[string]string `json:"headers"`
+	KeepHidden bool              `json:"keepHidden"`
+}
+
+var (
+	hostConfigs   = make(map[string]*hostConfig)
+	preparedHosts = make(map[string]*sync.Once)
+	preparedLock  = &sync.Mutex{}
+)
+
+func configFor(host string) *hostConfig {
+	config, ok := hostConfigs[strings.ToLower(host)]
+	if !ok {
+		return &hostConfig{}
+	}
+	return config
+}
+
+func hiddenFields(doc *html.Node, fields url.Values) {
+	if doc.Type == html.ElementNode && doc.Data == "input" && strings.EqualFold(crawler.Attribute(doc, "type"), "hidden") {
+		if name := crawler.Attribute(doc, "name"); name != "" {
+			fields.Set(name, crawler.Attribute(doc, "value"))
+		}
+	}
+	for next := doc.FirstChild; next != nil; next = next.NextSibling {
+		hiddenFields(next, fields)
+	}
+}
+
+func runStep(step fetchStep, previous []byte) ([]byte, error) {
+	method := strings.ToUpper(step.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if len(step.Form) > 0 || step.KeepHidden {
+		fields := url.Values{}
+		if step.KeepHidden && previous != nil {
+			doc, err := html.Parse(strings.NewReader(string(previous)))
+			if err == nil {
+				hiddenFields(doc, fields)
+			}
+		}
+		for key, value := range step.Form {
+			fields.Set(key, os.ExpandEnv(value))
+		}
+		body = strings.NewReader(fields.Encode())
+	}
+	request, err := newRequest(method, os.ExpandEnv(step.URL), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for key, value := range step.Headers {
+		request.Header.Set(key, os.ExpandEnv(value))
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	rawResponse, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 400 {
+		return rawResponse, fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return rawResponse, nil
+}
+
+// Logs in and runs the host's steps the first time any of its pages is
+// fetched. Other fetches of the host wait until that is done.
+func prepareHost(where string) {
+	host := hostOf(where)
+	config := configFor(host)
+	if config.Login == nil && len(config.Steps) == 0 {
+		return
+	}
+	preparedLock.Lock()
+	once, ok := preparedHosts[host]
+	if !ok {
+		once = &sync.Once{}
+		preparedHosts[host] = once
+	}
+	preparedLock.Unlock()
+	once.Do(func() {
+		if config.Login != nil {
+			err := login(config.Login)
+			if err != nil {
+				slog.Error("Cannot log in", "host", host, failed(err))
+			} else {
+				slog.Info("Logged in", "host", host)
+			}
+		}
+		var previous []byte
+		for i, nextStep := range config.Steps {
+			rawResponse, err := runStep(nextStep, previous)
+			if err != nil {
+				slog.Error("Cannot run step", "host", host, "step", i+1, failed(err))
+				return
+			}
+			previous = rawResponse
+		}
+		if len(config.Steps) > 0 {
+			slog.Info("Ran steps", "host", host, "count", len(config.Steps))
+		}
+	})
+}
+
+func initHosts() {
+	hostsPath := viper.GetString("Network.HostsFile")
+	if hostsPath == "" {
+		return
+	}
+	rawHosts, err := os.ReadFile(hostsPath)
+	if err != nil {
+		panic(fmt.Sprintf("Cannot read hosts file %s: %s", hostsPath, err.Error()))
+	}
+	loaded := make(map[string]*hostConfig)
+	err = json.Unmarshal(rawHosts, &loaded)
+	if err != nil {
+		panic(fmt.Sprintf("Cannot parse hosts file %s: %s", hostsPath, err.Error()))
+	}
+	for host, config := range loaded {
+		err := config.initWindows()
+		if err != nil {
+			panic(fmt.Sprintf("Invalid crawl windows for %s: %s", host, err.Error()))
+		}
+		hostConfigs[strings.ToLower(host)] = config
+	}
+	// Logins and steps are only useful if the session they set up sticks around.
+	client.Jar = cookies
+}
@@ -0,0 +1,96 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// How long a QUIC handshake may take before the host is given up on and
+// fetched over TCP instead.
+const quicHandshakeTimeout = 3 * time.Second
+
+// Tries HTTP/3 first for https addresses and falls back to HTTP/1.1 or
+// HTTP/2 over TCP. Hosts that failed over HTTP/3 once go straight to TCP for
+// the rest of the crawl. Tor only carries TCP, so hosts crawled through it
+// never get HTTP/3.
+type fallbackTransport struct {
+	tcp    http.RoundTripper
+	quic   *http3.RoundTripper
+	lock   *sync.Mutex
+	broken map[string]bool
+}
+
+func newFallbackTransport(tcp http.RoundTripper) *fallbackTransport {
+	transport := &fallbackTransport{
+		tcp: tcp,
+		quic: &http3.RoundTripper{
+			QuicConfig: &quic.Config{
+				HandshakeIdleTimeout: quicHandshakeTimeout,
+			},
+			TLSClientConfig: tlsConfig.Clone(),
+			Dial:            dialQUIC,
+		},
+		lock:   &sync.Mutex{},
+		broken: make(map[string]bool),
+	}
+	return transport
+}
+
+func (this *fallbackTransport) tryQUIC(request *http.Request) bool {
+	if request.URL.Scheme != "https" || throughTor(request.URL.Hostname()) || proxied(request) {
+		return false
+	}
+	// The request can only be sent twice if its body can be had again.
+	if request.Body != nil && request.Body != http.NoBody && request.GetBody == nil {
+		return false
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return !this.broken[strings.ToLower(request.URL.Host)]
+}
+
+func (this *fallbackTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if !this.tryQUIC(request) {
+		return this.tcp.RoundTrip(request)
+	}
+	response, err := this.quic.RoundTrip(request)
+	if err == nil {
+		return response, nil
+	}
+	slog.Warn("Cannot fetch over HTTP/3, falling back to TCP", "url", request.URL.String(), failed(err))
+	this.lock.Lock()
+	this.broken[strings.ToLower(request.URL.Host)] = true
+	this.lock.Unlock()
+	if request.GetBody != nil {
+		body, err := request.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		request = request.Clone(request.Context())
+		request.Body = body
+	}
+	return this.tcp.RoundTrip(request)
+}
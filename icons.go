@@ -0,0 +1,137 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/lunar-parklife/pagecrawl/pkg/crawler"
+	"github.com/spf13/viper"
+	"golang.org/x/net/html"
+)
+
+type siteIcon struct {
+	Address     string `json:"address"`
+	Rel         string `json:"rel"`
+	Sizes       string `json:"sizes,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Size        int    `json:"size,omitempty"`
+	Hash        string `json:"hash,omitempty"`
+	Data        []byte `json:"data,omitempty"`
+}
+
+type hostIcons struct {
+	once  *sync.Once
+	icons []siteIcon
+}
+
+var (
+	siteIcons     = make(map[string]*hostIcons)
+	siteIconsLock = &sync.Mutex{}
+)
+
+func iconLinks(base *url.URL, doc *html.Node) []siteIcon {
+	buf := make([]siteIcon, 0)
+	if doc.Type == html.ElementNode && doc.Data == "link" {
+		rel := strings.ToLower(crawler.Attribute(doc, "rel"))
+		if strings.Contains(rel, "icon") {
+			resolved, err := crawler.ResolveReference(base, crawler.Attribute(doc, "href"))
+			if err == nil && crawler.Attribute(doc, "href") != "" {
+				buf = append(buf, siteIcon{
+					Address: resolved.String(),
+					Rel:     rel,
+					Sizes:   crawler.Attribute(doc, "sizes"),
+				})
+			}
+		}
+	}
+	for next := doc.FirstChild; next != nil; next = next.NextSibling {
+		buf = append(buf, iconLinks(base, next)...)
+	}
+	return buf
+}
+
+func fetchIcon(icon *siteIcon) error {
+	request, err := newRequest(http.MethodGet, icon.Address, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+	rawIcon, _, err := readBody(response.Body)
+	if err != nil {
+		return err
+	}
+	icon.ContentType = response.Header.Get("Content-Type")
+	icon.Size = len(rawIcon)
+	icon.Hash = contentHash(rawIcon)
+	if viper.GetBool("Audit.IconData") {
+		icon.Data = rawIcon
+	}
+	return nil
+}
+
+// Resolves the icons of the page's host the first time the host is seen and
+// hands out the same icons for every other page on it.
+func findIcons(where string, doc *html.Node) []siteIcon {
+	base, err := url.Parse(where)
+	if err != nil {
+		return nil
+	}
+	host := strings.ToLower(base.Host)
+	siteIconsLock.Lock()
+	entry, ok := siteIcons[host]
+	if !ok {
+		entry = &hostIcons{
+			once: &sync.Once{},
+		}
+		siteIcons[host] = entry
+	}
+	siteIconsLock.Unlock()
+	entry.once.Do(func() {
+		candidates := iconLinks(base, doc)
+		if len(candidates) == 0 {
+			fallback, _ := crawler.ResolveReference(base, "/favicon.ico")
+			candidates = append(candidates, siteIcon{
+				Address: fallback.String(),
+				Rel:     "icon",
+			})
+		}
+		entry.icons = make([]siteIcon, 0, len(candidates))
+		for _, nextIcon := range candidates {
+			err := fetchIcon(&nextIcon)
+			if err != nil {
+				slog.Warn("Cannot fetch icon", "url", nextIcon.Address, failed(err))
+				continue
+			}
+			entry.icons = append(entry.icons, nextIcon)
+		}
+	})
+	return entry.icons
+}
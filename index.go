@@ -0,0 +1,247 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/html"
+)
+
+const excerptLength = 200
+
+// Elements whose text never shows up on the rendered page.
+var hiddenElements = map[string]bool{
+	"head":     true,
+	"noscript": true,
+	"script":   true,
+	"style":    true,
+	"template": true,
+}
+
+type indexedDocument struct {
+	Job      string
+	Run      string
+	Accessed time.Time
+	Title    string
+	Excerpt  string
+	Length   int
+	Terms    map[string]int
+}
+
+type searchIndex struct {
+	lock      *sync.Mutex
+	path      string
+	Documents map[string]*indexedDocument
+}
+
+type searchResult struct {
+	address  string
+	document *indexedDocument
+	score    float64
+}
+
+var index *searchIndex
+
+func pageText(doc *html.Node) string {
+	builder := &strings.Builder{}
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode && hiddenElements[node.Data] {
+			return
+		}
+		if node.Type == html.TextNode {
+			builder.WriteString(node.Data)
+			builder.WriteString(" ")
+		}
+		for next := node.FirstChild; next != nil; next = next.NextSibling {
+			walk(next)
+		}
+	}
+	walk(doc)
+	return strings.Join(strings.Fields(builder.String()), " ")
+}
+
+func pageTitle(doc *html.Node) string {
+	if doc.Type == html.ElementNode && doc.Data == "title" {
+		if doc.FirstChild != nil {
+			return strings.TrimSpace(doc.FirstChild.Data)
+		}
+		return ""
+	}
+	for next := doc.FirstChild; next != nil; next = next.NextSibling {
+		if title := pageTitle(next); title != "" {
+			return title
+		}
+	}
+	return ""
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func loadIndex(path string) (*searchIndex, error) {
+	loaded := &searchIndex{
+		lock:      &sync.Mutex{},
+		path:      path,
+		Documents: make(map[string]*indexedDocument),
+	}
+	indexFile, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return loaded, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer indexFile.Close()
+	err = gob.NewDecoder(indexFile).Decode(loaded)
+	if err != nil {
+		return nil, err
+	}
+	return loaded, nil
+}
+
+func (this *searchIndex) add(address string, accessed time.Time, doc *html.Node) {
+	text := pageText(doc)
+	terms := make(map[string]int)
+	tokens := tokenize(text)
+	for _, nextToken := range tokens {
+		terms[nextToken]++
+	}
+	excerpt := text
+	if len(excerpt) > excerptLength {
+		excerpt = strings.ToValidUTF8(excerpt[:excerptLength], "") + "..."
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.Documents[address] = &indexedDocument{
+		Job:      jobName,
+		Run:      runID,
+		Accessed: accessed,
+		Title:    pageTitle(doc),
+		Excerpt:  excerpt,
+		Length:   len(tokens),
+		Terms:    terms,
+	}
+}
+
+func (this *searchIndex) save() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	indexFile, err := os.Create(this.path)
+	if err != nil {
+		return err
+	}
+	defer indexFile.Close()
+	return gob.NewEncoder(indexFile).Encode(this)
+}
+
+// Ranks documents with BM25, so rare terms and short documents win.
+func (this *searchIndex) search(query string) []searchResult {
+	const k1, b = 1.2, 0.75
+	terms := tokenize(query)
+	total := float64(len(this.Documents))
+	averageLength := 0.0
+	frequencies := make(map[string]int)
+	for _, nextDocument := range this.Documents {
+		averageLength += float64(nextDocument.Length)
+		for _, nextTerm := range terms {
+			if nextDocument.Terms[nextTerm] > 0 {
+				frequencies[nextTerm]++
+			}
+		}
+	}
+	if total > 0 {
+		averageLength /= total
+	}
+	results := make([]searchResult, 0)
+	for address, nextDocument := range this.Documents {
+		score := 0.0
+		for _, nextTerm := range terms {
+			termFrequency := float64(nextDocument.Terms[nextTerm])
+			if termFrequency == 0 {
+				continue
+			}
+			documentFrequency := float64(frequencies[nextTerm])
+			idf := math.Log(1 + (total-documentFrequency+0.5)/(documentFrequency+0.5))
+			score += idf * termFrequency * (k1 + 1) / (termFrequency + k1*(1-b+b*float64(nextDocument.Length)/averageLength))
+		}
+		if score > 0 {
+			results = append(results, searchResult{
+				address:  address,
+				document: nextDocument,
+				score:    score,
+			})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].score > results[j].score
+	})
+	return results
+}
+
+func initIndex() {
+	indexPath := viper.GetString("Index.Path")
+	if indexPath == "" {
+		return
+	}
+	loaded, err := loadIndex(indexPath)
+	if err != nil {
+		panic(fmt.Sprintf("Cannot load search index %s: %s", indexPath, err.Error()))
+	}
+	index = loaded
+}
+
+func runSearch(query string) {
+	indexPath := viper.GetString("Index.Path")
+	if indexPath == "" {
+		fmt.Fprintln(os.Stderr, "No search index configured, set Index.Path first.")
+		os.Exit(1)
+	}
+	loaded, err := loadIndex(indexPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot load search index %s: %s\n", indexPath, err.Error())
+		os.Exit(1)
+	}
+	results := loaded.search(query)
+	limit := viper.GetInt("Index.Results")
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	for _, nextResult := range results {
+		fmt.Printf("%.3f %s\n", nextResult.score, nextResult.address)
+		if nextResult.document.Title != "" {
+			fmt.Printf("      %s\n", nextResult.document.Title)
+		}
+		fmt.Printf("      %s\n", nextResult.document.Excerpt)
+	}
+}
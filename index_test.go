@@ -0,0 +1,122 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+func parsedPage(t *testing.T, raw string) *html.Node {
+	doc, err := html.Parse(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text   string
+		tokens []string
+	}{
+		{text: "Hello, World!", tokens: []string{"hello", "world"}},
+		{text: "HTTP/2 and TLS-1.3", tokens: []string{"http", "2", "and", "tls", "1", "3"}},
+		{text: "Crème brûlée", tokens: []string{"crème", "brûlée"}},
+		{text: "  ... ", tokens: []string{}},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.text, func(t *testing.T) {
+			tokens := tokenize(nextTest.text)
+			if !reflect.DeepEqual(tokens, nextTest.tokens) {
+				t.Errorf("tokenize(%q) = %q, want %q", nextTest.text, tokens, nextTest.tokens)
+			}
+		})
+	}
+}
+
+func TestPageText(t *testing.T) {
+	doc := parsedPage(t, `<html><head><title>Title</title><style>p { color: red }</style></head>
+<body><h1>Heading</h1><script>var hidden = 1;</script><p>Some   spaced
+text</p><noscript>Enable scripts</noscript></body></html>`)
+	if got, want := pageText(doc), "Heading Some spaced text"; got != want {
+		t.Errorf("pageText() = %q, want %q", got, want)
+	}
+	if got, want := pageTitle(doc), "Title"; got != want {
+		t.Errorf("pageTitle() = %q, want %q", got, want)
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	searched := &searchIndex{
+		lock:      &sync.Mutex{},
+		Documents: make(map[string]*indexedDocument),
+	}
+	pages := map[string]string{
+		"https://example.com/crawler": "<title>Crawler</title><p>A crawler fetches pages. This crawler follows links.</p>",
+		"https://example.com/long":    "<p>A crawler is mentioned once in a much longer page about gardening, soil, seeds, watering, sunlight and patience.</p>",
+		"https://example.com/garden":  "<p>Gardening needs soil and seeds.</p>",
+		"https://example.com/rare":    "<p>Pages about zeppelins are rare.</p>",
+	}
+	for nextAddress, nextPage := range pages {
+		searched.add(nextAddress, time.Now(), parsedPage(t, nextPage))
+	}
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{
+			name:  "more occurrences rank higher",
+			query: "crawler",
+			want:  []string{"https://example.com/crawler", "https://example.com/long"},
+		},
+		{
+			name:  "case doesn't matter",
+			query: "ZEPPELINS",
+			want:  []string{"https://example.com/rare"},
+		},
+		{
+			name:  "rare term outweighs a common one",
+			query: "zeppelins pages",
+			want:  []string{"https://example.com/rare", "https://example.com/crawler"},
+		},
+		{
+			name:  "no match",
+			query: "submarine",
+			want:  []string{},
+		},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			results := searched.search(nextTest.query)
+			got := make([]string, 0, len(results))
+			for _, nextResult := range results {
+				got = append(got, nextResult.address)
+			}
+			if !reflect.DeepEqual(got, nextTest.want) {
+				t.Errorf("search(%q) = %v, want %v", nextTest.query, got, nextTest.want)
+			}
+		})
+	}
+}
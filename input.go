@@ -0,0 +1,292 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	inputAuto    = "auto"
+	inputLines   = "lines"
+	inputJSONL   = "jsonl"
+	inputCSV     = "csv"
+	inputSitemap = "sitemap"
+)
+
+// Column names and JSON keys that hold the address to crawl.
+var addressColumns = []string{"url", "address", "loc", "href", "link"}
+
+// A JSON line of input. Besides the address, it may say how to ask for it:
+// body is sent as it is, form is sent URL encoded and json is sent as JSON.
+type inputRecord struct {
+	URL     string            `json:"url"`
+	Address string            `json:"address"`
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+	Body    *string           `json:"body"`
+	Form    map[string]string `json:"form"`
+	JSON    json.RawMessage   `json:"json"`
+}
+
+func (this *inputRecord) target() (*crawlTarget, error) {
+	target := &crawlTarget{
+		Address: this.URL,
+		Method:  this.Method,
+		Headers: make(map[string]string),
+	}
+	if target.Address == "" {
+		target.Address = this.Address
+	}
+	if target.Address == "" {
+		return nil, errors.New("input record has no url or address")
+	}
+	for nextName, nextValue := range this.Headers {
+		target.Headers[nextName] = nextValue
+	}
+	contentType := ""
+	switch {
+	case this.Body != nil:
+		target.Body = []byte(*this.Body)
+	case this.Form != nil:
+		form := url.Values{}
+		for nextName, nextValue := range this.Form {
+			form.Set(nextName, nextValue)
+		}
+		target.Body = []byte(form.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	case this.JSON != nil && string(this.JSON) != "null":
+		target.Body = this.JSON
+		contentType = "application/json"
+	}
+	if target.Body != nil && target.Method == "" {
+		target.Method = http.MethodPost
+	}
+	if _, ok := target.Headers["Content-Type"]; !ok && contentType != "" {
+		target.Headers["Content-Type"] = contentType
+	}
+	return target, nil
+}
+
+func looksLikeAddress(value string) bool {
+	value = strings.TrimSpace(value)
+	if strings.ContainsAny(value, " \t") {
+		return false
+	}
+	parsed, err := url.Parse(value)
+	return err == nil && parsed.Scheme != "" && parsed.Host != ""
+}
+
+// Guesses the format from the first line of input, so input can be
+// streamed without waiting for more of it.
+func detectFormat(firstLine string) string {
+	trimmed := strings.TrimSpace(firstLine)
+	switch {
+	case strings.HasPrefix(trimmed, "<"):
+		return inputSitemap
+	case strings.HasPrefix(trimmed, "{"):
+		return inputJSONL
+	case looksLikeAddress(trimmed):
+		return inputLines
+	case strings.ContainsAny(trimmed, ",\t"):
+		return inputCSV
+	}
+	return inputLines
+}
+
+// Reads addresses to crawl from plain lists of addresses, JSON lines with a
+// url or address key, CSV with an address column, or sitemaps. Plain lists
+// end at a line saying quit.
+func readInput(reader io.Reader, format string, each func(*crawlTarget)) error {
+	buffered := bufio.NewReader(reader)
+	firstLine := ""
+	for strings.TrimSpace(firstLine) == "" {
+		line, err := buffered.ReadString('\n')
+		firstLine += line
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if strings.TrimSpace(firstLine) == "" {
+		return nil
+	}
+	if format == inputAuto {
+		format = detectFormat(firstLine)
+	}
+	all := io.MultiReader(strings.NewReader(firstLine), buffered)
+	switch format {
+	case inputLines:
+		return readLines(all, each)
+	case inputJSONL:
+		return readJSONL(all, each)
+	case inputCSV:
+		return readCSV(all, strings.Contains(firstLine, "\t"), each)
+	case inputSitemap:
+		return readSitemapInput(all, each)
+	}
+	return fmt.Errorf("unknown input format %s, expected auto, lines, jsonl, csv or sitemap", format)
+}
+
+// Seeds the crawl with the addresses given as arguments, then with every
+// --input file in order, or with stdin when there are neither.
+func readInputs(addresses []string, files []*os.File, format string, each func(*crawlTarget)) {
+	for _, nextAddress := range addresses {
+		each(&crawlTarget{
+			Address: nextAddress,
+		})
+	}
+	if len(addresses) == 0 && len(files) == 0 {
+		files = []*os.File{os.Stdin}
+	}
+	for _, nextFile := range files {
+		err := readInput(nextFile, format, each)
+		if err != nil {
+			slog.Error("Cannot read input", "path", nextFile.Name(), failed(err))
+		}
+		if nextFile != os.Stdin {
+			nextFile.Close()
+		}
+	}
+}
+
+func readLines(reader io.Reader, each func(*crawlTarget)) error {
+	input := bufio.NewScanner(reader)
+	for input.Scan() {
+		nextLine := strings.TrimSpace(input.Text())
+		if nextLine == "quit" {
+			break
+		}
+		if nextLine == "" {
+			continue
+		}
+		each(&crawlTarget{
+			Address: nextLine,
+		})
+	}
+	return input.Err()
+}
+
+func readJSONL(reader io.Reader, each func(*crawlTarget)) error {
+	decoder := json.NewDecoder(reader)
+	for {
+		record := &inputRecord{}
+		err := decoder.Decode(record)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target, err := record.target()
+		if err != nil {
+			slog.Warn("Skipping input record", failed(err))
+			continue
+		}
+		each(target)
+	}
+}
+
+// A header row is recognised by one of the address column names. Without
+// one, the first column holding an address is used and the first row is
+// crawled too.
+func readCSV(reader io.Reader, tabs bool, each func(*crawlTarget)) error {
+	records := csv.NewReader(reader)
+	records.FieldsPerRecord = -1
+	records.LazyQuotes = true
+	if tabs {
+		records.Comma = '\t'
+	}
+	column := -1
+	first := true
+	for {
+		record, err := records.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if first {
+			first = false
+			for nextColumn, nextField := range record {
+				for _, nextName := range addressColumns {
+					if strings.EqualFold(strings.TrimSpace(nextField), nextName) {
+						column = nextColumn
+						break
+					}
+				}
+				if column >= 0 {
+					break
+				}
+			}
+			if column >= 0 {
+				continue
+			}
+			for nextColumn, nextField := range record {
+				if looksLikeAddress(nextField) {
+					column = nextColumn
+					break
+				}
+			}
+			if column < 0 {
+				return errors.New("cannot find a column of addresses in the CSV input")
+			}
+		}
+		if column >= len(record) || strings.TrimSpace(record[column]) == "" {
+			continue
+		}
+		each(&crawlTarget{
+			Address: strings.TrimSpace(record[column]),
+		})
+	}
+}
+
+func readSitemapInput(reader io.Reader, each func(*crawlTarget)) error {
+	rawSitemap, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	addresses, document, err := parseSitemap(rawSitemap)
+	if err != nil {
+		return err
+	}
+	if document != nil {
+		for _, nextSitemap := range document.Sitemaps {
+			addresses = append(addresses, readSitemap(strings.TrimSpace(nextSitemap))...)
+		}
+	}
+	for _, nextAddress := range addresses {
+		each(&crawlTarget{
+			Address: nextAddress,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/spf13/viper"
+)
+
+// Publishes every asset as a message on a Kafka topic, keyed by its address
+// so the versions of a page land in the same partition. Messages are held
+// until the output is flushed and then sent all at once, waiting for the
+// brokers to take them, so failures count against the output's OnError.
+// Messages that couldn't be sent are kept for the next flush.
+type kafkaOutput struct {
+	target  string
+	writer  *kafka.Writer
+	pending []kafka.Message
+}
+
+// Every message of a flush is handed to the writer at once, so there is
+// nothing to wait for before sending a batch that isn't full.
+const kafkaLinger = time.Millisecond
+
+func kafkaCompression(name string) (kafka.Compression, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return 0, nil
+	case "gzip":
+		return kafka.Gzip, nil
+	case "snappy":
+		return kafka.Snappy, nil
+	case "lz4":
+		return kafka.Lz4, nil
+	case "zstd":
+		return kafka.Zstd, nil
+	}
+	return 0, fmt.Errorf("unknown compression %s, expected none, gzip, snappy, lz4 or zstd", name)
+}
+
+// Takes the broker to bootstrap from and the topic as broker/topic.
+func (this *kafkaOutput) Open() error {
+	broker, topic, ok := strings.Cut(this.target, "/")
+	if !ok || broker == "" || topic == "" {
+		return fmt.Errorf("kafka output %s is not broker/topic", this.target)
+	}
+	compression, err := kafkaCompression(viper.GetString("Kafka.Compression"))
+	if err != nil {
+		return err
+	}
+	this.writer = &kafka.Writer{
+		Addr:         kafka.TCP(broker),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		BatchSize:    viper.GetInt("Kafka.BatchSize"),
+		BatchTimeout: kafkaLinger,
+		Compression:  compression,
+	}
+	return nil
+}
+
+func (this *kafkaOutput) Write(asset *asset, rawAsset []byte) error {
+	this.pending = append(this.pending, kafka.Message{
+		Key:   []byte(asset.Address),
+		Value: bytes.TrimRight(append([]byte(nil), rawAsset...), "\n"),
+	})
+	return nil
+}
+
+func (this *kafkaOutput) Flush() error {
+	if len(this.pending) == 0 {
+		return nil
+	}
+	err := this.writer.WriteMessages(context.Background(), this.pending...)
+	if err == nil {
+		this.pending = this.pending[:0]
+		return nil
+	}
+	// Only some messages may have failed, and only those are kept.
+	var writeErrors kafka.WriteErrors
+	if errors.As(err, &writeErrors) && len(writeErrors) == len(this.pending) {
+		kept := this.pending[:0]
+		for i, nextMessage := range this.pending {
+			if writeErrors[i] != nil {
+				kept = append(kept, nextMessage)
+			}
+		}
+		this.pending = kept
+	}
+	return fmt.Errorf("cannot publish %d assets to %s: %w", len(this.pending), this.writer.Topic, err)
+}
+
+func (this *kafkaOutput) Close() error {
+	return this.writer.Close()
+}
+
+func init() {
+	registerSink("kafka", "Kafka", func(target string) (sink, error) {
+		return &kafkaOutput{target: target}, nil
+	})
+}
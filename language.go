@@ -0,0 +1,133 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"github.com/lunar-parklife/pagecrawl/pkg/crawler"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/html"
+)
+
+// The language a page declares, from its html lang attribute, falling back
+// to the Content-Language meta tag and header.
+func pageLanguage(response *http.Response, doc *html.Node) string {
+	if root := findElement(doc, "html"); root != nil {
+		if lang := strings.TrimSpace(crawler.Attribute(root, "lang")); lang != "" {
+			return strings.ToLower(lang)
+		}
+	}
+	if content := httpEquiv(doc, "content-language"); content != "" {
+		return strings.ToLower(strings.TrimSpace(strings.Split(content, ",")[0]))
+	}
+	if header := response.Header.Get("Content-Language"); header != "" {
+		return strings.ToLower(strings.TrimSpace(strings.Split(header, ",")[0]))
+	}
+	return ""
+}
+
+func httpEquiv(doc *html.Node, name string) string {
+	if doc.Type == html.ElementNode && doc.Data == "meta" && strings.EqualFold(crawler.Attribute(doc, "http-equiv"), name) {
+		return crawler.Attribute(doc, "content")
+	}
+	for next := doc.FirstChild; next != nil; next = next.NextSibling {
+		if content := httpEquiv(next, name); content != "" {
+			return content
+		}
+	}
+	return ""
+}
+
+// Collects the alternate language versions a page links to with hreflang,
+// keyed by address.
+func hreflangAlternates(base *url.URL, doc *html.Node, found map[string]string) {
+	if doc.Type == html.ElementNode && (doc.Data == "link" || doc.Data == "a") {
+		hreflang := strings.ToLower(strings.TrimSpace(crawler.Attribute(doc, "hreflang")))
+		if hreflang != "" && crawler.Attribute(doc, "href") != "" {
+			if resolved, err := crawler.ResolveReference(base, crawler.Attribute(doc, "href")); err == nil {
+				found[withoutFragment(resolved.String())] = hreflang
+			}
+		}
+	}
+	for next := doc.FirstChild; next != nil; next = next.NextSibling {
+		hreflangAlternates(base, next, found)
+	}
+}
+
+// Keeps a crawl to the languages in Crawl.Languages. A language allows its
+// regional variants too, so en allows en-gb.
+type languageFilter struct {
+	lock       *sync.Mutex
+	allowed    []string
+	alternates map[string]string
+}
+
+var languages *languageFilter
+
+func (this *languageFilter) allows(language string) bool {
+	if language == "" || language == "x-default" {
+		return true
+	}
+	for _, nextAllowed := range this.allowed {
+		if language == nextAllowed || strings.HasPrefix(language, nextAllowed+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *languageFilter) learn(base *url.URL, doc *html.Node) {
+	found := make(map[string]string)
+	hreflangAlternates(base, doc, found)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for nextAddress, nextLanguage := range found {
+		this.alternates[nextAddress] = nextLanguage
+	}
+}
+
+// Tells whether an address is known, from the hreflang links of crawled
+// pages, to be in a language the crawl doesn't want.
+func (this *languageFilter) skips(address string) bool {
+	this.lock.Lock()
+	language, ok := this.alternates[withoutFragment(address)]
+	this.lock.Unlock()
+	return ok && !this.allows(language)
+}
+
+func initLanguages() {
+	allowed := make([]string, 0)
+	for _, nextLanguage := range strings.Split(viper.GetString("Crawl.Languages"), ",") {
+		nextLanguage = strings.ToLower(strings.TrimSpace(nextLanguage))
+		if nextLanguage != "" {
+			allowed = append(allowed, nextLanguage)
+		}
+	}
+	if len(allowed) == 0 {
+		return
+	}
+	languages = &languageFilter{
+		lock:       &sync.Mutex{},
+		allowed:    allowed,
+		alternates: make(map[string]string),
+	}
+}
@@ -0,0 +1,39 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"io"
+
+	"github.com/spf13/viper"
+)
+
+// Reads at most Network.MaxBodyBytes and tells whether there was more, so
+// huge or endless responses can't use up all memory. 0 reads everything.
+func readBody(reader io.Reader) ([]byte, bool, error) {
+	limit := viper.GetInt64("Network.MaxBodyBytes")
+	if limit <= 0 {
+		raw, err := io.ReadAll(reader)
+		return raw, false, err
+	}
+	raw, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if int64(len(raw)) > limit {
+		return raw[:limit], true, err
+	}
+	return raw, false, err
+}
@@ -0,0 +1,172 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/lunar-parklife/pagecrawl/pkg/crawler"
+	"github.com/spf13/viper"
+)
+
+const (
+	linkBrokenStatus = "status"
+	linkBrokenDNS    = "dns"
+	linkBrokenError  = "error"
+)
+
+type brokenLink struct {
+	Address string `json:"address"`
+	Reason  string `json:"reason"`
+	Status  int    `json:"status,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Asks for every address the crawled pages reference with a HEAD request,
+// once per address however many pages link to it, and reports the ones that
+// answer with a 4xx or 5xx or can't be reached, by the page linking to them.
+type linkCheckReport struct {
+	lock    *sync.Mutex
+	checked map[string]*brokenLink
+	pages   map[string][]*brokenLink
+}
+
+var linkCheck *linkCheckReport
+
+func newLinkCheckReport() *linkCheckReport {
+	return &linkCheckReport{
+		lock:    &sync.Mutex{},
+		checked: make(map[string]*brokenLink),
+		pages:   make(map[string][]*brokenLink),
+	}
+}
+
+func (this *linkCheckReport) name() string {
+	return "broken-links"
+}
+
+func (this *linkCheckReport) observe(asset *asset) {
+}
+
+func (this *linkCheckReport) summary() any {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.pages
+}
+
+func (this *linkCheckReport) check(page string, references []crawler.Reference) {
+	seen := make(map[string]bool)
+	for _, nextReference := range references {
+		parsed, err := url.Parse(nextReference.Address)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			continue
+		}
+		where := normalizeAddress(nextReference.Address)
+		if seen[where] {
+			continue
+		}
+		seen[where] = true
+		found := this.status(where)
+		if found == nil {
+			continue
+		}
+		this.lock.Lock()
+		this.pages[page] = append(this.pages[page], found)
+		this.lock.Unlock()
+	}
+}
+
+func (this *linkCheckReport) status(where string) *brokenLink {
+	this.lock.Lock()
+	found, ok := this.checked[where]
+	this.lock.Unlock()
+	if ok {
+		return found
+	}
+	found = probeLink(where)
+	if found != nil {
+		slog.Warn("Broken link", "url", where, "status", found.Status, "reason", found.describe())
+	}
+	this.lock.Lock()
+	this.checked[where] = found
+	this.lock.Unlock()
+	return found
+}
+
+func (this *brokenLink) describe() string {
+	if this.Reason == linkBrokenStatus {
+		return fmt.Sprintf("status %d", this.Status)
+	}
+	return this.Error
+}
+
+// Falls back to a GET for servers that don't answer HEAD requests.
+func probeLink(where string) *brokenLink {
+	response, err := probe(http.MethodHead, where)
+	if err == nil && (response.StatusCode == http.StatusMethodNotAllowed || response.StatusCode == http.StatusNotImplemented) {
+		response, err = probe(http.MethodGet, where)
+	}
+	if err != nil {
+		found := &brokenLink{
+			Address: where,
+			Reason:  linkBrokenError,
+			Error:   err.Error(),
+		}
+		var dnsError *net.DNSError
+		if errors.As(err, &dnsError) {
+			found.Reason = linkBrokenDNS
+		}
+		return found
+	}
+	if response.StatusCode < 400 {
+		return nil
+	}
+	return &brokenLink{
+		Address: where,
+		Reason:  linkBrokenStatus,
+		Status:  response.StatusCode,
+	}
+}
+
+func probe(method string, where string) (*http.Response, error) {
+	request, err := newRequest(method, where, nil)
+	if err != nil {
+		return nil, err
+	}
+	host := politenessFor(hostOf(where))
+	host.wait()
+	start := time.Now()
+	response, _, err := send(request)
+	host.record(time.Since(start), err != nil || response.StatusCode >= 500)
+	return response, err
+}
+
+func initLinkCheck() {
+	if !viper.GetBool("Audit.CheckLinks") || linkCheck != nil {
+		return
+	}
+	linkCheck = newLinkCheckReport()
+	reports = append(reports, linkCheck)
+}
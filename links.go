@@ -0,0 +1,169 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"github.com/lunar-parklife/pagecrawl/pkg/crawler"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/publicsuffix"
+)
+
+const (
+	linkInternal  = "internal"
+	linkSubdomain = "subdomain"
+	linkExternal  = "external"
+	linkOther     = "other"
+)
+
+type linkCounts struct {
+	Internal  int `json:"internal"`
+	Subdomain int `json:"subdomain"`
+	External  int `json:"external"`
+	Other     int `json:"other"`
+}
+
+func withoutFragment(where string) string {
+	parsed, err := url.Parse(where)
+	if err != nil {
+		return where
+	}
+	parsed.Fragment = ""
+	return parsed.String()
+}
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ftp":   "21",
+}
+
+// Writes the address the way every other spelling of the same page would
+// be written: lowercase scheme and host, no default port, no fragment, at
+// least a / for the path, and with Crawl.SortQuery the query parameters in
+// order.
+func normalizeAddress(where string) string {
+	parsed, err := url.Parse(strings.TrimSpace(where))
+	if err != nil {
+		return where
+	}
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	host := strings.ToLower(parsed.Hostname())
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port := parsed.Port(); port != "" && port != defaultPorts[parsed.Scheme] {
+		host += ":" + port
+	}
+	parsed.Host = host
+	parsed.Fragment = ""
+	parsed.RawFragment = ""
+	if parsed.Path == "" && parsed.Host != "" {
+		parsed.Path = "/"
+	}
+	if viper.GetBool("Crawl.SortQuery") && parsed.RawQuery != "" {
+		// Encode sorts by key and keeps the order of repeated keys.
+		parsed.RawQuery = parsed.Query().Encode()
+	}
+	return parsed.String()
+}
+
+func registrableDomain(host string) string {
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
+
+func classifyLink(base *url.URL, reference string) (string, *url.URL) {
+	resolved, err := crawler.ResolveReference(base, reference)
+	if err != nil || (resolved.Scheme != "http" && resolved.Scheme != "https") {
+		return linkOther, resolved
+	}
+	baseHost := strings.ToLower(base.Hostname())
+	host := strings.ToLower(resolved.Hostname())
+	switch {
+	case host == baseHost:
+		return linkInternal, resolved
+	case registrableDomain(host) == registrableDomain(baseHost):
+		return linkSubdomain, resolved
+	}
+	return linkExternal, resolved
+}
+
+func countLinks(where string, references []string) *linkCounts {
+	counts := &linkCounts{}
+	base, err := url.Parse(where)
+	if err != nil {
+		return counts
+	}
+	for _, nextReference := range references {
+		kind, _ := classifyLink(base, nextReference)
+		switch kind {
+		case linkInternal:
+			counts.Internal++
+		case linkSubdomain:
+			counts.Subdomain++
+		case linkExternal:
+			counts.External++
+		default:
+			counts.Other++
+		}
+	}
+	return counts
+}
+
+type outboundReport struct {
+	domains map[string]map[string]int
+}
+
+func newOutboundReport() *outboundReport {
+	return &outboundReport{
+		domains: make(map[string]map[string]int),
+	}
+}
+
+func (this *outboundReport) name() string {
+	return "outbound-domains"
+}
+
+func (this *outboundReport) observe(asset *asset) {
+	base, err := url.Parse(asset.Address)
+	if err != nil {
+		return
+	}
+	host := strings.ToLower(base.Hostname())
+	outbound, ok := this.domains[host]
+	if !ok {
+		outbound = make(map[string]int)
+		this.domains[host] = outbound
+	}
+	for _, nextReference := range asset.References {
+		kind, resolved := classifyLink(base, nextReference)
+		if kind == linkExternal {
+			outbound[registrableDomain(strings.ToLower(resolved.Hostname()))]++
+		}
+	}
+}
+
+func (this *outboundReport) summary() any {
+	return this.domains
+}
@@ -0,0 +1,98 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestNormalizeAddress(t *testing.T) {
+	tests := []struct {
+		name       string
+		address    string
+		sortQuery  bool
+		normalized string
+	}{
+		{name: "case", address: "HTTPS://Example.COM/Path", normalized: "https://example.com/Path"},
+		{name: "default port", address: "http://example.com:80/a", normalized: "http://example.com/a"},
+		{name: "other port", address: "https://example.com:8443/a", normalized: "https://example.com:8443/a"},
+		{name: "fragment", address: "https://example.com/a#section", normalized: "https://example.com/a"},
+		{name: "empty path", address: "https://example.com", normalized: "https://example.com/"},
+		{name: "spaces", address: "  https://example.com/a  ", normalized: "https://example.com/a"},
+		{name: "ipv6 host", address: "http://[::1]:80/a", normalized: "http://[::1]/a"},
+		{name: "ftp port", address: "ftp://files.example.com:21/pub/", normalized: "ftp://files.example.com/pub/"},
+		{name: "query kept in order", address: "https://example.com/?b=2&a=1", normalized: "https://example.com/?b=2&a=1"},
+		{name: "query sorted", address: "https://example.com/?b=2&a=1&b=1", sortQuery: true, normalized: "https://example.com/?a=1&b=2&b=1"},
+	}
+	defer viper.Set("Crawl.SortQuery", false)
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			viper.Set("Crawl.SortQuery", nextTest.sortQuery)
+			if normalized := normalizeAddress(nextTest.address); normalized != nextTest.normalized {
+				t.Errorf("normalizeAddress(%s) = %s, want %s", nextTest.address, normalized, nextTest.normalized)
+			}
+		})
+	}
+}
+
+func TestClassifyLink(t *testing.T) {
+	base, _ := url.Parse("https://www.example.co.uk/blog/post")
+	tests := []struct {
+		reference string
+		class     string
+		resolved  string
+	}{
+		{reference: "/about", class: linkInternal, resolved: "https://www.example.co.uk/about"},
+		{reference: "other-post", class: linkInternal, resolved: "https://www.example.co.uk/blog/other-post"},
+		{reference: "https://WWW.Example.co.uk/", class: linkInternal, resolved: "https://WWW.Example.co.uk/"},
+		{reference: "https://shop.example.co.uk/", class: linkSubdomain, resolved: "https://shop.example.co.uk/"},
+		{reference: "//example.co.uk/", class: linkSubdomain, resolved: "https://example.co.uk/"},
+		{reference: "https://other.co.uk/", class: linkExternal, resolved: "https://other.co.uk/"},
+		{reference: "https://example.com/", class: linkExternal, resolved: "https://example.com/"},
+		{reference: "mailto:someone@example.co.uk", class: linkOther, resolved: "mailto:someone@example.co.uk"},
+		{reference: "javascript:void(0)", class: linkOther, resolved: "javascript:void(0)"},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.reference, func(t *testing.T) {
+			class, resolved := classifyLink(base, nextTest.reference)
+			if class != nextTest.class || resolved.String() != nextTest.resolved {
+				t.Errorf("classifyLink(%s) = %s, %s, want %s, %s", nextTest.reference, class, resolved, nextTest.class, nextTest.resolved)
+			}
+		})
+	}
+}
+
+func TestRegistrableDomain(t *testing.T) {
+	tests := []struct {
+		host   string
+		domain string
+	}{
+		{host: "www.example.com", domain: "example.com"},
+		{host: "a.b.example.co.uk", domain: "example.co.uk"},
+		{host: "user.github.io", domain: "user.github.io"},
+		{host: "localhost", domain: "localhost"},
+	}
+	for _, nextTest := range tests {
+		if domain := registrableDomain(nextTest.host); domain != nextTest.domain {
+			t.Errorf("registrableDomain(%s) = %s, want %s", nextTest.host, domain, nextTest.domain)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	logText = "text"
+	logJSON = "json"
+)
+
+const (
+	levelDebug = "debug"
+	levelInfo  = "info"
+	levelWarn  = "warn"
+	levelError = "error"
+)
+
+var logLevels = map[string]slog.Level{
+	levelDebug: slog.LevelDebug,
+	levelInfo:  slog.LevelInfo,
+	levelWarn:  slog.LevelWarn,
+	levelError: slog.LevelError,
+}
+
+// Where records go, the log file once initLog set it up.
+var logWriter io.Writer = os.Stderr
+
+// A log file that is only created once there is something to write, and
+// that is moved aside to <path>.1, <path>.2 and so on once it grows past
+// Log.MaxSize megabytes or gets older than Log.MaxAge hours. Records go to
+// stderr instead when the file can't be opened.
+type rotatingLog struct {
+	lock     *sync.Mutex
+	path     string
+	file     *os.File
+	size     int64
+	opened   time.Time
+	maxSize  int64
+	maxAge   time.Duration
+	keep     int
+	fallback bool
+}
+
+func newRotatingLog(path string) *rotatingLog {
+	return &rotatingLog{
+		lock:    &sync.Mutex{},
+		path:    path,
+		maxSize: viper.GetInt64("Log.MaxSize") << 20,
+		maxAge:  time.Duration(viper.GetFloat64("Log.MaxAge") * float64(time.Hour)),
+		keep:    viper.GetInt("Log.Keep"),
+	}
+}
+
+func (this *rotatingLog) open() error {
+	file, err := os.OpenFile(this.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	this.file = file
+	this.size = info.Size()
+	this.opened = time.Now()
+	return nil
+}
+
+func (this *rotatingLog) due(next int) bool {
+	if this.maxSize > 0 && this.size > 0 && this.size+int64(next) > this.maxSize {
+		return true
+	}
+	return this.maxAge > 0 && time.Since(this.opened) > this.maxAge
+}
+
+func (this *rotatingLog) rotate() {
+	this.file.Close()
+	this.file = nil
+	if this.keep < 1 {
+		os.Remove(this.path)
+		return
+	}
+	os.Remove(fmt.Sprintf("%s.%d", this.path, this.keep))
+	for i := this.keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", this.path, i), fmt.Sprintf("%s.%d", this.path, i+1))
+	}
+	os.Rename(this.path, this.path+".1")
+}
+
+func (this *rotatingLog) Write(p []byte) (int, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.fallback {
+		return os.Stderr.Write(p)
+	}
+	if this.file != nil && this.due(len(p)) {
+		this.rotate()
+	}
+	if this.file == nil {
+		err := this.open()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, fmt.Sprintf("Cannot open log file %s, logging to stderr: %s", this.path, err.Error()))
+			this.fallback = true
+			return os.Stderr.Write(p)
+		}
+	}
+	written, err := this.file.Write(p)
+	this.size += int64(written)
+	return written, err
+}
+
+// Writes durations the way people read them in both formats, rather than
+// as nanoseconds in JSON.
+func replaceLogAttr(groups []string, attr slog.Attr) slog.Attr {
+	if attr.Value.Kind() == slog.KindDuration {
+		return slog.String(attr.Key, attr.Value.Duration().String())
+	}
+	return attr
+}
+
+// Sets up the logger from Log.Level and Log.Format. Every record carries the
+// job and run it belongs to.
+func initLogger() error {
+	level, ok := logLevels[strings.ToLower(viper.GetString("Log.Level"))]
+	if !ok {
+		return fmt.Errorf("unknown log level %s, expected debug, info, warn or error", viper.GetString("Log.Level"))
+	}
+	options := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: replaceLogAttr,
+	}
+	var handler slog.Handler
+	switch strings.ToLower(viper.GetString("Log.Format")) {
+	case logText:
+		handler = slog.NewTextHandler(logWriter, options)
+	case logJSON:
+		handler = slog.NewJSONHandler(logWriter, options)
+	default:
+		return fmt.Errorf("unknown log format %s, expected text or json", viper.GetString("Log.Format"))
+	}
+	slog.SetDefault(slog.New(handler).With("job", jobName, "run", runID))
+	return nil
+}
+
+// Describes an error along with the kind of failure it is, so failures can
+// be counted by class.
+func failed(err error) slog.Attr {
+	return slog.Group("error",
+		slog.String("message", err.Error()),
+		slog.String("class", failureClass(0, err)))
+}
@@ -0,0 +1,220 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/lunar-parklife/pagecrawl/pkg/crawler"
+	"github.com/spf13/viper"
+	"golang.org/x/net/html"
+)
+
+// How to log into a host through its login form.
+type loginConfig struct {
+	URL     string            `json:"url"`
+	Fields  map[string]string `json:"fields"`
+	Success loginCheck        `json:"success"`
+}
+
+// What a successful login looks like. With no checks at all, any response
+// that doesn't ask for a password again counts.
+type loginCheck struct {
+	Status   int    `json:"status"`
+	Contains string `json:"contains"`
+	Cookie   string `json:"cookie"`
+}
+
+func hasPasswordInput(doc *html.Node) bool {
+	if doc.Type == html.ElementNode && doc.Data == "input" && strings.EqualFold(crawler.Attribute(doc, "type"), "password") {
+		return true
+	}
+	for next := doc.FirstChild; next != nil; next = next.NextSibling {
+		if hasPasswordInput(next) {
+			return true
+		}
+	}
+	return false
+}
+
+func findForms(doc *html.Node) []*html.Node {
+	if doc.Type == html.ElementNode && doc.Data == "form" {
+		return []*html.Node{doc}
+	}
+	buf := make([]*html.Node, 0)
+	for next := doc.FirstChild; next != nil; next = next.NextSibling {
+		buf = append(buf, findForms(next)...)
+	}
+	return buf
+}
+
+// Prefers the form asking for a password, falling back to the first one.
+func findLoginForm(doc *html.Node) *html.Node {
+	forms := findForms(doc)
+	for _, nextForm := range forms {
+		if hasPasswordInput(nextForm) {
+			return nextForm
+		}
+	}
+	if len(forms) > 0 {
+		return forms[0]
+	}
+	return nil
+}
+
+func formDefaults(form *html.Node, fields url.Values) {
+	if form.Type == html.ElementNode && form.Data == "input" {
+		name, kind := crawler.Attribute(form, "name"), strings.ToLower(crawler.Attribute(form, "type"))
+		if name != "" && kind != "submit" && kind != "button" && kind != "image" && ((kind != "checkbox" && kind != "radio") || hasAttribute(form, "checked")) {
+			fields.Set(name, crawler.Attribute(form, "value"))
+		}
+	}
+	for next := form.FirstChild; next != nil; next = next.NextSibling {
+		formDefaults(next, fields)
+	}
+}
+
+func hasAttribute(node *html.Node, key string) bool {
+	for _, nextAttribute := range node.Attr {
+		if strings.ToLower(nextAttribute.Key) == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *loginCheck) passed(response *http.Response, rawResponse []byte) error {
+	if this.Status != 0 && response.StatusCode != this.Status {
+		return fmt.Errorf("expected status %d, got %s", this.Status, response.Status)
+	}
+	if this.Contains != "" && !strings.Contains(string(rawResponse), this.Contains) {
+		return fmt.Errorf("response doesn't contain %q", this.Contains)
+	}
+	if this.Cookie != "" {
+		found := false
+		if client.Jar != nil {
+			for _, nextCookie := range client.Jar.Cookies(response.Request.URL) {
+				found = found || nextCookie.Name == this.Cookie
+			}
+		}
+		if !found {
+			return fmt.Errorf("no %s cookie was set", this.Cookie)
+		}
+	}
+	if this.Status == 0 && this.Contains == "" && this.Cookie == "" {
+		if response.StatusCode >= 400 {
+			return fmt.Errorf("unexpected status %s", response.Status)
+		}
+		doc, err := html.Parse(strings.NewReader(string(rawResponse)))
+		if err == nil && hasPasswordInput(doc) {
+			return fmt.Errorf("still asked for a password")
+		}
+	}
+	return nil
+}
+
+// Fills in the login form found at the login URL and submits it the way a
+// browser would, keeping whatever the form already had filled in.
+func login(config *loginConfig) error {
+	response, rawResponse, err := retrieve(os.ExpandEnv(config.URL))
+	if err != nil {
+		return err
+	}
+	doc, err := html.Parse(strings.NewReader(string(rawResponse)))
+	if err != nil {
+		return err
+	}
+	form := findLoginForm(doc)
+	if form == nil {
+		return fmt.Errorf("no login form on %s", response.Request.URL)
+	}
+	action, err := crawler.ResolveReference(response.Request.URL, crawler.Attribute(form, "action"))
+	if err != nil {
+		return err
+	}
+	fields := url.Values{}
+	formDefaults(form, fields)
+	for key, value := range config.Fields {
+		fields.Set(key, os.ExpandEnv(value))
+	}
+	method := strings.ToUpper(crawler.Attribute(form, "method"))
+	var body io.Reader
+	if method == http.MethodPost {
+		body = strings.NewReader(fields.Encode())
+	} else {
+		method = http.MethodGet
+		action.RawQuery = fields.Encode()
+	}
+	request, err := newRequest(method, action.String(), body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	request.Header.Set("Referer", response.Request.URL.String())
+	result, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer result.Body.Close()
+	rawResult, err := io.ReadAll(result.Body)
+	if err != nil {
+		return err
+	}
+	return config.Success.passed(result, rawResult)
+}
+
+// Logs in through the Login section once before the crawl starts, since
+// everything after would only get the login page otherwise.
+func initLogin() {
+	where := viper.GetString("Login.URL")
+	if where == "" {
+		return
+	}
+	fields, err := url.ParseQuery(viper.GetString("Login.Fields"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, fmt.Sprintf("Invalid Login.Fields: %s", err.Error()))
+		os.Exit(1)
+	}
+	config := &loginConfig{
+		URL:    where,
+		Fields: make(map[string]string),
+		Success: loginCheck{
+			Status:   viper.GetInt("Login.SuccessStatus"),
+			Contains: viper.GetString("Login.SuccessContains"),
+			Cookie:   viper.GetString("Login.SuccessCookie"),
+		},
+	}
+	for nextKey := range fields {
+		config.Fields[nextKey] = fields.Get(nextKey)
+	}
+	client.Jar = cookies
+	err = login(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, fmt.Sprintf("Cannot log in at %s: %s", where, err.Error()))
+		os.Exit(1)
+	}
+	slog.Info("Logged in", "url", where)
+}
@@ -18,22 +18,22 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	_ "embed"
-	"encoding/json"
-	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/lunar-parklife/pagecrawl/pkg/crawler"
 	"github.com/spf13/viper"
-	"golang.org/x/net/html"
 )
 
 var (
@@ -43,94 +43,622 @@ var (
 	licenseInfo string
 )
 
-const userAgent = "pagecrawl; 0.1.0"
-
 var (
 	shouldCache = false
-	outputs     = make([]io.Writer, 0)
+	outputs     = make([]*assetOutput, 0)
+	client      = &http.Client{
+		CheckRedirect: checkRedirect,
+	}
+	// Pages are fetched through the same Crawler the crawler package offers
+	// other programs, sending requests with the crawl's client.
+	pageCrawler = &crawler.Crawler{
+		Client:    client,
+		UserAgent: crawler.UserAgent,
+	}
 )
 
 type asset struct {
-	Accessed   time.Time `json:"accessed"`
-	Address    string    `json:"address"`
-	Data       []byte    `json:"data"`
-	References []string  `json:"references"`
+	Job           string              `json:"job"`
+	Run           string              `json:"run"`
+	Accessed      time.Time           `json:"accessed"`
+	Address       string              `json:"address"`
+	Method        string              `json:"method,omitempty"`
+	Depth         int                 `json:"depth"`
+	Referrer      string              `json:"referrer,omitempty"`
+	Parents       []string            `json:"parents,omitempty"`
+	Data          []byte              `json:"data"`
+	References    []string            `json:"references"`
+	ReferenceTags []crawler.Reference `json:"referenceTags,omitempty"`
+	Robots        []string            `json:"robots,omitempty"`
+	Blocked       string              `json:"blocked,omitempty"`
+	Unchanged     bool                `json:"unchanged,omitempty"`
+	Truncated     bool                `json:"truncated,omitempty"`
+
+	FinalAddress  string         `json:"finalAddress,omitempty"`
+	Redirects     []redirectHop  `json:"redirects,omitempty"`
+	Protocol      string         `json:"protocol,omitempty"`
+	Status        int            `json:"status,omitempty"`
+	ContentType   string         `json:"contentType,omitempty"`
+	Charset       string         `json:"charset,omitempty"`
+	ContentLength int64          `json:"contentLength,omitempty"`
+	Headers       http.Header    `json:"headers,omitempty"`
+	Transfer      *transferStats `json:"transfer,omitempty"`
+	Timings       *fetchTimings  `json:"timings,omitempty"`
+
+	Security     *securityAudit `json:"security,omitempty"`
+	MixedContent []string       `json:"mixedContent,omitempty"`
+	Technologies []technology   `json:"technologies,omitempty"`
+	Links        *linkCounts    `json:"links,omitempty"`
+	Hash         string         `json:"hash,omitempty"`
+	Simhash      string         `json:"simhash,omitempty"`
+	Canonical    string         `json:"canonical,omitempty"`
+	Title        string         `json:"title,omitempty"`
+	Language     string         `json:"language,omitempty"`
+	Description  string         `json:"description,omitempty"`
+	TLS          *tlsDetails    `json:"tls,omitempty"`
+	Icons        []siteIcon     `json:"icons,omitempty"`
+	Wayback      *waybackRecord `json:"wayback,omitempty"`
+	Markdown     string         `json:"markdown,omitempty"`
 }
 
+// Sends assets to an address as JSON with URL.Method, an asset at a time,
+// or those written between flushes as an array when URL.FlushEvery is more
+// than 1. Failed sends are kept for the next flush as long as the receiver
+// may take them later, so a receiver restarting doesn't lose assets.
 type httpOutput struct {
-	sendTo string
+	sendTo  string
+	method  string
+	batched bool
+	pending [][]byte
+}
+
+func (this *httpOutput) Open() error {
+	kind := sinkKinds["url"]
+	this.method = strings.ToUpper(viper.GetString("URL.Method"))
+	this.batched = viper.GetInt(kind.setting("FlushEvery")) > 1
+	return nil
 }
 
-func (this *httpOutput) Write(p []byte) (int, error) {
-	client := http.DefaultClient
-	request, err := http.NewRequest(http.MethodGet, this.sendTo, bytes.NewReader(p))
+func (this *httpOutput) Write(asset *asset, rawAsset []byte) error {
+	this.pending = append(this.pending, bytes.TrimRight(rawAsset, "\n"))
+	return nil
+}
+
+// Tells whether the send failed in a way that may work out later.
+func (this *httpOutput) post(body []byte) (bool, error) {
+	// Not a request of the crawl, so none of the headers meant for the
+	// sites crawled go along.
+	request, err := http.NewRequest(this.method, this.sendTo, bytes.NewReader(body))
 	if err != nil {
-		log.Println(fmt.Sprintf("Cannot create output request: %s", err.Error()))
-		return 0, err
+		return false, err
+	}
+	for nextName, nextValue := range viper.GetStringMapString("URLHeaders") {
+		request.Header.Set(nextName, os.ExpandEnv(nextValue))
 	}
-	request.Header.Add("From", viper.GetString("Network.From"))
-	request.Header.Add("User-Agent", userAgent)
-	_, err = client.Do(request)
+	request.Header.Set("Content-Type", "application/json")
+	response, err := serviceClient.Do(request)
 	if err != nil {
-		return 0, err
+		return true, err
 	}
-	return len(p), nil
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		return failedStatus(response.StatusCode), fmt.Errorf("%s answered %s", redactTarget(this.sendTo), response.Status)
+	}
+	return false, nil
 }
 
-func crawl(doc *html.Node) []string {
-	buf := make([]string, 0)
-	for _, attribute := range doc.Attr {
-		if strings.ToLower(attribute.Key) == "href" {
-			buf = append(buf, attribute.Val)
+// Assets that failed in a way that may work out later are kept for the next
+// flush, which the retry policy may make right away.
+func (this *httpOutput) Flush() error {
+	for len(this.pending) > 0 {
+		sent := this.pending[:1]
+		body := sent[0]
+		if this.batched {
+			sent = this.pending
+			body = append(append([]byte{'['}, bytes.Join(sent, []byte{','})...), ']')
+		}
+		again, err := this.post(body)
+		if err != nil && again {
+			return fmt.Errorf("cannot send %d assets: %w", len(this.pending), err)
 		}
+		this.pending = this.pending[len(sent):]
+		if err != nil {
+			return fmt.Errorf("cannot send %d assets: %w", len(sent), err)
+		}
+	}
+	return nil
+}
+
+func (this *httpOutput) Close() error {
+	return nil
+}
+
+func init() {
+	registerSink("url", "URL", func(target string) (sink, error) {
+		if target == "" {
+			return nil, fmt.Errorf("url output has no address")
+		}
+		return &httpOutput{sendTo: target}, nil
+	})
+}
+
+func newRequest(method string, where string, body io.Reader) (*http.Request, error) {
+	request, err := pageCrawler.NewRequest(context.Background(), method, where, body)
+	if err != nil {
+		return nil, err
 	}
-	for next := doc.FirstChild; next != nil; next = next.NextSibling {
-		buf = append(buf, crawl(next)...)
+	authorize(request)
+	for nextName, nextValues := range extraHeaders {
+		request.Header[nextName] = append([]string(nil), nextValues...)
 	}
-	return buf
+	return request, nil
 }
 
-func fetch(where string, group *sync.WaitGroup) {
-	defer group.Done()
-	log.Println(fmt.Sprintf("Fetching from %s", where))
-	now := time.Now().UTC()
-	client := http.DefaultClient
-	request, err := http.NewRequest(http.MethodGet, where, nil)
+func retrieve(where string) (*http.Response, []byte, error) {
+	request, err := newRequest(http.MethodGet, where, nil)
 	if err != nil {
-		log.Println(fmt.Sprintf("Error creating creating request for page %s: %s", where, err.Error()))
+		return nil, nil, err
 	}
-	request.Header.Add("From", viper.GetString("Network.From"))
-	request.Header.Add("User-Agent", userAgent)
-	response, err := client.Do(request)
+	return send(request)
+}
+
+func send(request *http.Request) (*http.Response, []byte, error) {
+	response, rawResponse, _, err := sendMeasured(request)
+	return response, rawResponse, err
+}
+
+// What sendMeasured found out about a request besides its response.
+type measurements struct {
+	transfer  *transferStats
+	timings   *fetchTimings
+	truncated bool
+}
+
+// Asks for compressed responses itself rather than leaving it to the
+// transport, so it can tell how many bytes actually went over the wire.
+func sendMeasured(request *http.Request) (*http.Response, []byte, *measurements, error) {
+	if request.Header.Get("Accept-Encoding") == "" {
+		request.Header.Set("Accept-Encoding", acceptedEncodings)
+	}
+	start := time.Now()
+	trace := &requestTrace{start: start}
+	request = trace.attach(request)
+	response, err := pageCrawler.Do(request)
 	if err != nil {
-		log.Println(fmt.Sprintf("Error fetching %s: %s", where, err.Error()))
-		return
+		return response, nil, nil, err
 	}
 	defer response.Body.Close()
-	rawResponse, err := io.ReadAll(response.Body)
+	rawResponse, truncated, err := readBody(response.Body)
+	measured := &measurements{
+		timings:   trace.measure(time.Now()),
+		truncated: truncated,
+	}
+	if err != nil {
+		return response, rawResponse, measured, err
+	}
+	var decodedTruncated bool
+	rawResponse, measured.transfer, decodedTruncated, err = decodeBody(response, rawResponse)
+	measured.truncated = measured.truncated || decodedTruncated
+	if measured.truncated {
+		slog.Warn("Truncated body", "url", request.URL.String(), "bytes", len(rawResponse))
+	}
+	if har != nil {
+		har.record(start, trace, response, rawResponse)
+	}
+	return response, rawResponse, measured, err
+}
+
+// Whether robots.txt lets the address be fetched, when Network.Robots says
+// to honor it. Addresses it disallows are skipped in the robots.txt report.
+func robotsAllow(where string) (bool, *robotsTxt) {
+	honorRobots := viper.GetBool("Network.Robots")
+	if !honorRobots && robotsCoverage == nil {
+		return true, nil
+	}
+	parsed, err := url.Parse(where)
+	if err != nil || parsed.Host == "" {
+		return true, nil
+	}
+	robots := robotsFor(parsed)
+	if ok, rule := robots.allowed(parsed); !ok && honorRobots {
+		slog.Info("Not fetching, robots.txt disallows it", "url", where, "rule", rule)
+		if robotsCoverage != nil {
+			robotsCoverage.skipped(where, rule)
+		}
+		return false, robots
+	}
+	return true, robots
+}
+
+// Meta refreshes are only followed where a link to the same address would
+// be fetched, so a page can't refresh the crawl out of its bounds.
+func refreshAllowed(target *crawlTarget, location string) bool {
+	parsed, err := url.Parse(location)
+	if err != nil || !followable(parsed) || parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return false
+	}
+	if !inScope(target.Address, parsed) {
+		slog.Info("Not following refresh, out of scope", "url", target.Address, "location", location)
+		return false
+	}
+	if !hostAllowed(location) {
+		slog.Info("Not following refresh, host isn't allowed", "url", target.Address, "location", location)
+		return false
+	}
+	ok, _ := robotsAllow(location)
+	return ok
+}
+
+func fetch(target *crawlTarget, group *sync.WaitGroup) {
+	defer group.Done()
+	where := target.Address
+	if !hostAllowed(where) {
+		slog.Info("Not fetching, host isn't allowed", "url", where)
+		return
+	}
+	gateFor(target.Job).wait()
+	waitForWindow(where)
+	slog.Debug("Fetching", "url", where)
+	if scheme := strings.ToLower(strings.SplitN(where, ":", 2)[0]); scheme == "ftp" || scheme == "ftps" {
+		fetchFTP(target)
+		return
+	}
+	honorRobots := viper.GetBool("Network.Robots")
+	if parsed, err := url.Parse(where); err == nil && parsed.Host != "" && orphans != nil {
+		orphans.loadSitemaps(parsed)
+	}
+	if ok, robots := robotsAllow(where); !ok {
+		if retries != nil && robots.unreachable {
+			retries.failed(target, robots.status, robots.failure)
+		}
+		return
+	}
+	prepareHost(where)
+	if skippedByHead(target) {
+		return
+	}
+	host := politenessFor(hostOf(where))
+	var now time.Time
+	var took time.Duration
+	var incremental, conditional bool
+	var response *http.Response
+	var rawResponse []byte
+	var measured *measurements
+	var request *http.Request
+	var err error
+	for attempt := 1; ; attempt++ {
+		host.wait()
+		now = time.Now().UTC()
+		request, err = target.request()
+		if err != nil {
+			slog.Error("Cannot create request", "url", where, failed(err))
+			host.release()
+			return
+		}
+		incremental = validators != nil && viper.GetBool("Cache.Incremental") && request.Method == http.MethodGet
+		conditional = incremental || validators != nil && viper.GetBool("Cache.Conditional") && request.Method == http.MethodGet
+		if conditional {
+			validators.condition(request)
+		}
+		response, rawResponse, measured, err = sendMeasured(request)
+		took = time.Since(now)
+		host.record(took, err != nil || response.StatusCode >= 500)
+		pause, again := retryDelay(attempt, response, err)
+		if !again {
+			break
+		}
+		slog.Warn("Retrying", "url", where, "attempt", attempt, "wait", pause, "reason", attemptFailure(response, err))
+		time.Sleep(pause)
+	}
+	if stats != nil {
+		status, size := 0, int64(len(rawResponse))
+		if err == nil {
+			status = response.StatusCode
+		}
+		if measured != nil && measured.transfer != nil {
+			size = int64(measured.transfer.Transferred)
+		}
+		stats.fetched(where, status, size, took, err)
+	}
+	if service != nil {
+		status := 0
+		if err == nil {
+			status = response.StatusCode
+		}
+		service.attempted(target, status, err)
+	}
+	if retries != nil {
+		if err != nil || failedStatus(response.StatusCode) {
+			status := 0
+			if err == nil {
+				status = response.StatusCode
+			}
+			retries.failed(target, status, err)
+		} else {
+			retries.succeeded(where)
+		}
+	}
 	if err != nil {
-		log.Println(fmt.Sprintf("Error reading response: %s", err.Error()))
+		slog.Error("Cannot fetch", "url", where, failed(err))
+		if redirects != nil {
+			redirects.failed(where, response, err)
+		}
+		if pageMonitor != nil {
+			pageMonitor.check(where, 0, nil, nil, err)
+		}
+		return
+	}
+	if conditional && response.StatusCode == http.StatusNotModified {
+		validators.update(where, response, "")
+		slog.Info("Unchanged", "url", where)
+		if incremental {
+			return
+		}
+		output(&asset{
+			Job:        target.Job,
+			Run:        target.Run,
+			Accessed:   now,
+			Address:    where,
+			Depth:      target.Depth,
+			Referrer:   target.Referrer,
+			References: make([]string, 0),
+			Unchanged:  true,
+			Status:     response.StatusCode,
+		})
+		return
+	}
+	rawResponse, bodyCharset := transcodeBody(response, rawResponse)
+	mediaType := crawler.MediaType(response.Header.Get("Content-Type"), rawResponse)
+	doc := parseDocument(mediaType, rawResponse)
+	chain := redirectChain(response)
+	visited := map[string]bool{where: true}
+	for len(chain) < maxRedirects() {
+		hop, ok := metaRefresh(response.Request.URL, response.StatusCode, doc)
+		if !ok {
+			break
+		}
+		chain = append(chain, hop)
+		if !viper.GetBool("Network.FollowMetaRefresh") || visited[hop.Location] {
+			break
+		}
+		visited[hop.Location] = true
+		if !refreshAllowed(target, hop.Location) {
+			break
+		}
+		prepareHost(hop.Location)
+		host = politenessFor(hostOf(hop.Location))
+		host.wait()
+		started := time.Now()
+		request, err = newRequest(http.MethodGet, hop.Location, nil)
+		if err == nil {
+			response, rawResponse, measured, err = sendMeasured(request)
+		}
+		host.record(time.Since(started), err != nil || response.StatusCode >= 500)
+		if err != nil {
+			slog.Error("Cannot follow refresh", "url", where, "location", hop.Location, failed(err))
+			if pageMonitor != nil {
+				pageMonitor.check(where, 0, nil, nil, err)
+			}
+			return
+		}
+		rawResponse, bodyCharset = transcodeBody(response, rawResponse)
+		mediaType = crawler.MediaType(response.Header.Get("Content-Type"), rawResponse)
+		doc = parseDocument(mediaType, rawResponse)
+		chain = append(chain, redirectChain(response)...)
+	}
+	if wall := detectBotWall(response, doc, rawResponse); wall != "" {
+		slog.Warn("Blocked", "url", where, "status", response.StatusCode, "wall", wall)
+		host.blocked()
+		output(&asset{
+			Job:        target.Job,
+			Run:        target.Run,
+			Accessed:   now,
+			Address:    where,
+			Depth:      target.Depth,
+			Referrer:   target.Referrer,
+			References: make([]string, 0),
+			Redirects:  chain,
+			Blocked:    wall,
+			Status:     response.StatusCode,
+		})
 		return
 	}
-	doc, err := html.Parse(strings.NewReader(string(rawResponse)))
-	referenceNodes := crawl(doc)
+	host.unblocked()
+	// HTML goes to the parser registered for it like every other media
+	// type, rather than reusing doc, so a parser registered in its place
+	// takes over.
+	referenceTags, _ := crawler.Parse(response.Request.URL, mediaType, rawResponse)
+	directives := robotsDirectives(response, doc)
+	if honorRobots && hasDirective(directives, "nofollow") {
+		referenceTags = make([]crawler.Reference, 0)
+	}
+	referenceNodes := crawler.Addresses(referenceTags)
+	frontier.linked(response.Request.URL.String(), referenceNodes)
+	if discoveries != nil {
+		discoveries.found(response.Request.URL.String(), referenceNodes)
+	}
+	follow(target, response.Request.URL, referenceTags)
+	if linkCheck != nil {
+		linkCheck.check(where, referenceTags)
+	}
 	asset := &asset{
-		Accessed:   now,
-		Address:    where,
-		References: referenceNodes,
+		Job:           target.Job,
+		Run:           target.Run,
+		Accessed:      now,
+		Address:       where,
+		Depth:         target.Depth,
+		Referrer:      target.Referrer,
+		References:    referenceNodes,
+		ReferenceTags: referenceTags,
+		Robots:        directives,
+		Redirects:     chain,
+		Protocol:      response.Proto,
+	}
+	describeResponse(asset, response, rawResponse)
+	asset.Charset = bodyCharset
+	if request.Method != http.MethodGet {
+		asset.Method = request.Method
+	}
+	asset.Language = pageLanguage(response, doc)
+	if languages != nil {
+		languages.learn(response.Request.URL, doc)
+		if !languages.allows(asset.Language) {
+			slog.Info("Not outputting, language isn't wanted", "url", where, "language", asset.Language)
+			return
+		}
+	}
+	if final := response.Request.URL.String(); final != where {
+		asset.FinalAddress = final
+	}
+	if viper.GetBool("Network.RedirectAssets") && len(chain) > 0 {
+		outputRedirects(target, now, chain)
+		asset.Address = response.Request.URL.String()
+		asset.Referrer = chain[len(chain)-1].Address
+		asset.FinalAddress = ""
+	}
+	// Audits judge the page where redirects ended up, so its relative
+	// references resolve against that.
+	page := response.Request.URL.String()
+	if discoveries != nil {
+		asset.Parents = discoveries.parentsOf(where)
 	}
 	if shouldCache {
 		asset.Data = rawResponse
 	}
-	rawAssetJson, err := json.Marshal(asset)
+	if viper.GetBool("Audit.Security") {
+		asset.Security = auditSecurity(response)
+	}
+	if viper.GetBool("Audit.MixedContent") {
+		asset.MixedContent = findMixedContent(page, doc)
+	}
+	if viper.GetBool("Audit.Technology") {
+		asset.Technologies = fingerprintTechnology(response, doc, rawResponse)
+	}
+	if viper.GetBool("Audit.Timings") {
+		asset.Timings = measured.timings
+	}
+	asset.Transfer = measured.transfer
+	asset.Truncated = measured.truncated
+	if viper.GetBool("Audit.TLS") {
+		asset.TLS = inspectTLS(response)
+	}
+	if viper.GetBool("Audit.Icons") {
+		asset.Icons = findIcons(page, doc)
+	}
+	if viper.GetBool("Wayback.Check") || viper.GetBool("Wayback.Save") {
+		asset.Wayback = archiveInWayback(where)
+	}
+	if viper.GetBool("Audit.Links") {
+		asset.Links = countLinks(page, referenceNodes)
+	}
+	asset.Hash = contentHash(rawResponse)
+	if viper.GetBool("Audit.Duplicates") {
+		asset.Simhash = strconv.FormatUint(simhash(pageText(doc)), 16)
+		asset.Canonical = canonicalLink(page, doc)
+	}
+	if viper.GetBool("Audit.Titles") {
+		asset.Title = collapseSpace(pageTitle(doc))
+		asset.Description = collapseSpace(metaContent(doc, "description"))
+	}
+	if pageMonitor != nil {
+		pageMonitor.check(where, response.StatusCode, rawResponse, referenceNodes, nil)
+	}
+	if validators != nil && !validators.update(where, response, contentHash(rawResponse)) {
+		if incremental {
+			slog.Info("Unchanged", "url", where)
+			return
+		}
+		asset.Unchanged = true
+	}
+	if previous != nil && previous.unchanged(where, asset.Hash) {
+		slog.Info("Not outputting, same as in the previous crawl", "url", where)
+		return
+	}
+	observe(asset)
+	if honorRobots && hasDirective(directives, "noindex") {
+		slog.Info("Not outputting, page asks not to be indexed", "url", where)
+		return
+	}
+	if index != nil {
+		index.add(where, now, doc)
+	}
+	if viper.GetBool("Markdown.Asset") || viper.GetString("Markdown.Path") != "" {
+		markdown := toMarkdown(page, doc)
+		if viper.GetBool("Markdown.Asset") {
+			asset.Markdown = markdown
+		}
+		if viper.GetString("Markdown.Path") != "" {
+			writeMarkdown(where, pageTitle(doc), markdown)
+		}
+	}
+	if !output(asset) {
+		return
+	}
+	slog.Info("Fetched", "url", where, "status", response.StatusCode, "duration", time.Since(now))
+}
+
+func output(asset *asset) bool {
+	if asset.Job == "" {
+		asset.Job = jobName
+	}
+	if asset.Run == "" {
+		asset.Run = runID
+	}
+	if ordering != nil {
+		ordering.hold(asset)
+		return true
+	}
+	return writeAsset(asset)
+}
+
+// Records the status and the headers Output.Headers asks for, which are
+// none while it is empty and all of them when it is *.
+func describeResponse(asset *asset, response *http.Response, rawResponse []byte) {
+	asset.Status = response.StatusCode
+	asset.ContentType = response.Header.Get("Content-Type")
+	asset.ContentLength = response.ContentLength
+	if asset.ContentLength < 0 {
+		asset.ContentLength = int64(len(rawResponse))
+	}
+	wanted := parseFields(viper.GetString("Output.Headers"))
+	if len(wanted) == 1 && wanted[0] == "*" {
+		asset.Headers = response.Header.Clone()
+		return
+	}
+	for _, nextHeader := range wanted {
+		values := response.Header.Values(nextHeader)
+		if len(values) == 0 {
+			continue
+		}
+		if asset.Headers == nil {
+			asset.Headers = make(http.Header)
+		}
+		asset.Headers[http.CanonicalHeaderKey(nextHeader)] = values
+	}
+}
+
+func writeAsset(asset *asset) bool {
+	if graph != nil {
+		graph.add(asset)
+	}
+	if service != nil {
+		service.written(asset)
+	}
+	written := true
 	for _, nextOutput := range outputs {
-		_, err = nextOutput.Write(rawAssetJson)
+		rawAssetJson, err := nextOutput.encode(asset)
 		if err != nil {
-			log.Println(fmt.Sprintf("Error outputting asset %+v: %s", asset, err.Error()))
-			return
+			slog.Error("Cannot encode asset", "url", asset.Address, failed(err))
+			return false
+		}
+		err = nextOutput.write(asset, rawAssetJson)
+		if err != nil {
+			slog.Error("Cannot output asset", "url", asset.Address, "output", nextOutput.name, failed(err))
+			written = false
 		}
 	}
-	log.Println(fmt.Sprintf("Sucessfully fetched %s", where))
+	return written
 }
 
 func initConfig() {
@@ -139,9 +667,167 @@ func initConfig() {
 	viper.SetConfigType("ini")
 	viper.SetDefault("Log.Path", ".")
 	viper.SetDefault("Log.Name", "pagecrawl")
+	viper.SetDefault("Log.Level", levelInfo)
+	viper.SetDefault("Log.Stderr", false)
+	viper.SetDefault("Log.MaxSize", 0)
+	viper.SetDefault("Log.MaxAge", 0)
+	viper.SetDefault("Log.Keep", 5)
+	viper.SetDefault("Log.Format", logText)
+	viper.SetDefault("Job.Name", "pagecrawl")
+	viper.SetDefault("Job.Run", "")
+	viper.SetDefault("Job.ShutdownTimeout", 30)
+	viper.SetDefault("Control.Listen", "")
+	viper.SetDefault("Control.Serve", "")
+	viper.SetDefault("Control.KeepAssets", 1000)
+	viper.SetDefault("Control.Origins", "")
+	viper.SetDefault("Control.BlockPrivate", true)
+	viper.SetDefault("Network.UserAgent", agentPagecrawl)
+	viper.SetDefault("Network.HeadFirst", false)
+	viper.SetDefault("Network.ContentTypes", "")
 	viper.SetDefault("Network.From", "")
-	viper.SetDefault("Output.Kind", "stdout")
+	viper.SetDefault("Network.Robots", true)
+	viper.SetDefault("Network.HostsFile", "")
+	viper.SetDefault("Network.AllowList", "")
+	viper.SetDefault("Network.DenyList", "")
+	viper.SetDefault("Network.FollowMetaRefresh", false)
+	viper.SetDefault("Network.MaxRedirects", 10)
+	viper.SetDefault("Network.CrossHostRedirects", true)
+	viper.SetDefault("Network.RedirectAssets", false)
+	viper.SetDefault("Network.BlockedBackoff", 60)
+	viper.SetDefault("Network.AdaptiveThrottle", false)
+	viper.SetDefault("Network.MaxDelay", 30)
+	viper.SetDefault("Network.DelayPerHost", 0)
+	viper.SetDefault("Network.MaxPerHost", 0)
+	viper.SetDefault("Network.HTTPVersion", "2")
+	viper.SetDefault("Network.HTTP3", false)
+	viper.SetDefault("Network.TimeoutConnect", 10)
+	viper.SetDefault("Network.TimeoutTLS", 10)
+	viper.SetDefault("Network.TimeoutHeaders", 30)
+	viper.SetDefault("Network.TimeoutTotal", 120)
+	viper.SetDefault("Network.BlockPrivate", false)
+	viper.SetDefault("TLS.CAFile", "")
+	viper.SetDefault("TLS.ClientCert", "")
+	viper.SetDefault("TLS.ClientKey", "")
+	viper.SetDefault("TLS.MinVersion", "1.2")
+	viper.SetDefault("TLS.Insecure", false)
+	viper.SetDefault("Network.Resolver", "")
+	viper.SetDefault("Network.DNSCacheTTL", 300)
+	viper.SetDefault("Network.Proxy", "")
+	viper.SetDefault("Network.MaxBodyBytes", 64<<20)
+	viper.SetDefault("Tor.Proxy", "")
+	viper.SetDefault("Tor.All", false)
+	viper.SetDefault("Input.Format", inputAuto)
+	viper.SetDefault("Input.Sitemaps", "")
+	viper.SetDefault("Crawl.Depth", 0)
+	viper.SetDefault("Crawl.SortQuery", false)
+	viper.SetDefault("Crawl.FollowEnclosures", false)
+	viper.SetDefault("Crawl.Scope", scopeAny)
+	viper.SetDefault("Crawl.AllowDomains", "")
+	viper.SetDefault("Crawl.Languages", "")
+	viper.SetDefault("Frontier.Prioritize", false)
+	viper.SetDefault("Frontier.Workers", 4)
+	viper.SetDefault("Frontier.Budget", 0)
+	viper.SetDefault("Frontier.Important", "")
+	viper.SetDefault("Frontier.State", "")
+	viper.SetDefault("Frontier.StateInterval", 60)
+	viper.SetDefault("Frontier.Resume", false)
+	viper.SetDefault("Output.Kind", "")
 	viper.SetDefault("Output.Path", "")
+	viper.SetDefault("Output.OnError", onErrorLog)
+	viper.SetDefault("Output.Retries", 3)
+	viper.SetDefault("Output.RetryDelay", 1)
+	viper.SetDefault("Output.FlushEvery", 1)
+	viper.SetDefault("Output.Format", formatNDJSON)
+	viper.SetDefault("File.Gzip", false)
+	viper.SetDefault("File.MaxSize", 0)
+	viper.SetDefault("File.MaxAge", 0)
+	viper.SetDefault("Output.Data", dataBase64)
+	viper.SetDefault("Output.Fields", "")
+	viper.SetDefault("Output.Order", orderCompletion)
+	viper.SetDefault("Output.Parents", false)
+	viper.SetDefault("Output.Headers", "")
+	viper.SetDefault("Output.Previous", "")
+	viper.SetDefault("Output.Graph", "")
+	viper.SetDefault("HAR.Path", "")
+	viper.SetDefault("HAR.Body", false)
+	viper.SetDefault("Audit.Security", false)
+	viper.SetDefault("Audit.MixedContent", false)
+	viper.SetDefault("Audit.Technology", false)
+	viper.SetDefault("Audit.Links", false)
+	viper.SetDefault("Audit.Duplicates", false)
+	viper.SetDefault("Audit.NearDuplicateDistance", 3)
+	viper.SetDefault("Audit.Redirects", false)
+	viper.SetDefault("Audit.MaxRedirectHops", 2)
+	viper.SetDefault("Audit.TLS", false)
+	viper.SetDefault("Audit.CertificateExpiryDays", 30)
+	viper.SetDefault("Audit.Icons", false)
+	viper.SetDefault("Audit.IconData", false)
+	viper.SetDefault("Audit.Robots", false)
+	viper.SetDefault("Audit.Titles", false)
+	viper.SetDefault("Audit.Compression", false)
+	viper.SetDefault("Audit.Orphans", false)
+	viper.SetDefault("Audit.Timings", false)
+	viper.SetDefault("Audit.CheckLinks", false)
+	viper.SetDefault("Auth.Basic", "")
+	viper.SetDefault("Auth.Bearer", "")
+	viper.SetDefault("Auth.Hosts", "")
+	viper.SetDefault("Login.URL", "")
+	viper.SetDefault("Login.Fields", "")
+	viper.SetDefault("Login.SuccessStatus", 0)
+	viper.SetDefault("Login.SuccessContains", "")
+	viper.SetDefault("Login.SuccessCookie", "")
+	viper.SetDefault("Cookies.Enabled", true)
+	viper.SetDefault("Cookies.Path", "")
+	viper.SetDefault("Cookies.Import", "")
+	viper.SetDefault("Cookies.Key", "")
+	viper.SetDefault("Cache.Path", "")
+	viper.SetDefault("Cache.Incremental", false)
+	viper.SetDefault("Cache.Conditional", true)
+	viper.SetDefault("Retry.Path", "")
+	viper.SetDefault("Retry.Attempts", 2)
+	viper.SetDefault("Retry.Delay", 1)
+	viper.SetDefault("Retry.Jitter", 0.5)
+	viper.SetDefault("Retry.MaxDelay", 60)
+	viper.SetDefault("Robots.Refresh", 86400)
+	viper.SetDefault("Robots.Unreachable", 30)
+	viper.SetDefault("Robots.Cache", "")
+	viper.SetDefault("Report.Path", "")
+	viper.SetDefault("Kafka.BatchSize", 100)
+	viper.SetDefault("Kafka.Compression", "none")
+	viper.SetDefault("S3.Endpoint", "s3.amazonaws.com")
+	viper.SetDefault("S3.Region", "")
+	viper.SetDefault("S3.AccessKey", "")
+	viper.SetDefault("S3.SecretKey", "")
+	viper.SetDefault("S3.SessionToken", "")
+	viper.SetDefault("S3.Insecure", false)
+	viper.SetDefault("S3.Key", "{date}/{host}/{run}-{seq}.jsonl")
+	viper.SetDefault("S3.FlushEvery", 1)
+	viper.SetDefault("URL.Method", http.MethodPost)
+	viper.SetDefault("AMQP.Exchange", "")
+	viper.SetDefault("AMQP.RoutingKey", "pagecrawl")
+	viper.SetDefault("AMQP.Confirm", true)
+	viper.SetDefault("AMQP.ConfirmTimeout", 5)
+	viper.SetDefault("AMQP.Reconnects", 5)
+	viper.SetDefault("AMQP.ReconnectDelay", 1)
+	viper.SetDefault("Report.Summary", true)
+	viper.SetDefault("Report.SummaryPath", "")
+	viper.SetDefault("Index.Path", "")
+	viper.SetDefault("Index.Results", 10)
+	viper.SetDefault("Archive.Listen", "localhost:8080")
+	viper.SetDefault("Markdown.Asset", false)
+	viper.SetDefault("Markdown.Path", "")
+	viper.SetDefault("Wayback.Check", false)
+	viper.SetDefault("Wayback.Save", false)
+	viper.SetDefault("Wayback.AccessKey", "")
+	viper.SetDefault("Wayback.SecretKey", "")
+	viper.SetDefault("Notify.State", "")
+	viper.SetDefault("Notify.Webhook", "")
+	viper.SetDefault("Notify.Slack", "")
+	viper.SetDefault("Notify.SMTPServer", "")
+	viper.SetDefault("Notify.SMTPFrom", "")
+	viper.SetDefault("Notify.SMTPTo", "")
+	viper.SetDefault("Notify.SMTPUser", "")
+	viper.SetDefault("Notify.SMTPPassword", "")
 	err := viper.ReadInConfig()
 	if err != nil {
 		viper.WriteConfig()
@@ -157,69 +843,173 @@ func initLog() {
 		logPath, logName,
 		logStart.Year(), logStart.Month(), logStart.Day(),
 		logStart.Hour(), logStart.Minute())
-	logFile, err := os.Create(logTarget)
+	if viper.GetBool("Log.Stderr") {
+		logWriter = os.Stderr
+	} else {
+		logWriter = newRotatingLog(logTarget)
+	}
+	err := initLogger()
 	if err != nil {
-		panic(err.Error())
+		panic(fmt.Sprintf("Invalid Log settings: %s", err.Error()))
+	}
+}
+
+func initReports() {
+	if viper.GetBool("Audit.Security") {
+		reports = append(reports, newSecurityReport())
+	}
+	if viper.GetBool("Audit.MixedContent") {
+		reports = append(reports, newMixedContentReport())
+	}
+	if viper.GetBool("Audit.Technology") {
+		reports = append(reports, newTechnologyReport())
+	}
+	if viper.GetBool("Audit.Links") {
+		reports = append(reports, newOutboundReport())
+	}
+	if viper.GetBool("Audit.Duplicates") {
+		reports = append(reports, newDuplicateReport())
 	}
-	log.SetOutput(logFile)
+	if viper.GetBool("Audit.Redirects") {
+		redirects = newRedirectReport()
+		reports = append(reports, redirects)
+	}
+	if viper.GetBool("Audit.TLS") {
+		reports = append(reports, newCertificateReport())
+	}
+	if viper.GetBool("Audit.Orphans") {
+		orphans = newOrphanReport()
+		reports = append(reports, orphans)
+	}
+	if viper.GetBool("Audit.Compression") {
+		reports = append(reports, newCompressionReport())
+	}
+	if viper.GetBool("Audit.Titles") {
+		reports = append(reports, newTitleReport())
+	}
+	if viper.GetBool("Audit.Robots") {
+		robotsCoverage = newRobotsReport()
+		reports = append(reports, robotsCoverage)
+	}
+	initLinkCheck()
+	initSummary()
 }
 
 func main() {
 	initConfig()
+	initJob()
 	initLog()
-	for _, nextFlag := range flag.Args() {
-		flag := strings.ToLower(nextFlag)
-		switch flag {
-		case "-c":
-			shouldCache = true
-			continue
-		case "-h":
-			log.Println(helpInfo)
-			continue
-		case "-l":
-			log.Println(licenseInfo)
-			continue
-		case "-v":
-			log.Println("PageCrawl pre-release")
-			continue
+	retrying := false
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "search":
+			runSearch(strings.Join(os.Args[2:], " "))
+			return
+		case "serve-archive":
+			runServeArchive(os.Args[2:])
+			return
+		case "report":
+			runReport(os.Args[2:])
+			return
+		case "retry":
+			retrying = true
 		}
-		exploded := strings.Split(flag, "=")
-		switch exploded[0] {
-		case "--out-file":
-			explodedPaths := strings.Split(exploded[1], ",")
-			for _, nextPath := range explodedPaths {
-				nextFile, err := os.OpenFile(nextPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, os.ModeAppend)
-				if err != nil {
-					log.Printf("Error opening output file %s: %s", nextPath, err.Error())
-					continue
-				}
-				outputs = append(outputs, nextFile)
-			}
-		case "--out-url":
-			explodedPaths := strings.Split(exploded[1], ",")
-			for _, nextPath := range explodedPaths {
-				outputs = append(outputs, &httpOutput{
-					sendTo: nextPath,
-				})
-			}
+	}
+	initHeaders()
+	initUserAgent()
+	initClient()
+	initHosts()
+	initHostLists()
+	initCookies()
+	initHAR()
+	initReports()
+	initIndex()
+	initMonitor()
+	initValidatorCache()
+	initRetryStore()
+	initRobotsCache()
+	initControl()
+	initFrontier()
+	initDiscoveries()
+	initLanguages()
+	initPreviousCrawl()
+	initGraph()
+	err := validScope(strings.ToLower(viper.GetString("Crawl.Scope")))
+	if err != nil {
+		panic(fmt.Sprintf("Invalid Crawl.Scope: %s", err.Error()))
+	}
+	// Output options apply to the outputs named after them.
+	options := &flagOptions{
+		data:   strings.ToLower(viper.GetString("Output.Data")),
+		fields: parseFields(viper.GetString("Output.Fields")),
+	}
+	err = validOutputFormat(strings.ToLower(viper.GetString("Output.Format")))
+	if err != nil {
+		panic(fmt.Sprintf("Invalid Output.Format: %s", err.Error()))
+	}
+	err = validDataEncoding(options.data)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid Output.Data: %s", err.Error()))
+	}
+	err = initOrdering(strings.ToLower(viper.GetString("Output.Order")))
+	if err != nil {
+		panic(fmt.Sprintf("Invalid Output.Order: %s", err.Error()))
+	}
+	if kind := viper.GetString("Output.Kind"); kind != "" {
+		err = addOutputs(kind, viper.GetString("Output.Path"), options)
+		if err != nil {
+			panic(fmt.Sprintf("Invalid Output.Kind: %s", err.Error()))
 		}
 	}
-	input := bufio.NewScanner(os.Stdin)
+	arguments := os.Args[1:]
+	if retrying {
+		arguments = os.Args[2:]
+	}
+	addresses := parseFlags(arguments, options)
+	if retrying && retries == nil {
+		fmt.Fprintln(os.Stderr, "No retry store configured, set Retry.Path first.")
+		os.Exit(1)
+	}
+	initLogin()
+	initService()
 	group := &sync.WaitGroup{}
-	for input.Scan() {
-		if input.Err() != nil {
-			if input.Err() == io.EOF {
-				break
+	workers := viper.GetInt("Frontier.Workers")
+	if workers < 1 {
+		workers = 1
+	}
+	frontier.run(workers, group)
+	handleSignals(group)
+	keepState()
+	seed := seedTarget
+	if viper.GetBool("Frontier.Resume") {
+		loaded, err := loadState()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, fmt.Sprintf("Cannot resume: %s", err.Error()))
+			os.Exit(1)
+		}
+		resumeState(loaded)
+	} else if sitemaps := parseFields(viper.GetString("Input.Sitemaps")); len(sitemaps) > 0 && !retrying {
+		for _, nextSitemap := range sitemaps {
+			addresses := readSitemap(nextSitemap)
+			slog.Info("Seeding from sitemap", "url", nextSitemap, "count", len(addresses))
+			for _, nextAddress := range addresses {
+				seed(&crawlTarget{
+					Address: nextAddress,
+				})
 			}
-			log.Println(fmt.Sprintf("Error scanning input: %s", input.Err().Error()))
-			continue
 		}
-		nextLine := input.Text()
-		if nextLine == "quit" {
-			break
+	} else if retrying {
+		for _, nextTarget := range retries.targets() {
+			seed(nextTarget)
 		}
-		group.Add(1)
-		go fetch(nextLine, group)
+	} else if service == nil {
+		readInputs(addresses, options.inputs, strings.ToLower(viper.GetString("Input.Format")), seed)
+	}
+	if service != nil {
+		// The service crawls until it is stopped.
+		select {}
 	}
+	frontier.close()
 	group.Wait()
+	finish()
 }
@@ -0,0 +1,263 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/lunar-parklife/pagecrawl/pkg/crawler"
+	"github.com/spf13/viper"
+	"golang.org/x/net/html"
+)
+
+// Page furniture that isn't part of the content being exported.
+var furnitureElements = map[string]bool{
+	"aside":  true,
+	"footer": true,
+	"form":   true,
+	"header": true,
+	"nav":    true,
+}
+
+var (
+	excessNewlines = regexp.MustCompile(`\n{3,}`)
+	unsafePath     = regexp.MustCompile(`[^A-Za-z0-9._/-]+`)
+)
+
+type markdownWriter struct {
+	base    *url.URL
+	builder *strings.Builder
+	lists   []int
+	inPre   bool
+}
+
+func findElement(doc *html.Node, name string) *html.Node {
+	if doc.Type == html.ElementNode && doc.Data == name {
+		return doc
+	}
+	for next := doc.FirstChild; next != nil; next = next.NextSibling {
+		if found := findElement(next, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// Prefers the article, then the main content, then the whole body.
+func contentRoot(doc *html.Node) *html.Node {
+	for _, nextName := range []string{"article", "main", "body"} {
+		if found := findElement(doc, nextName); found != nil {
+			return found
+		}
+	}
+	return doc
+}
+
+func (this *markdownWriter) block(prefix string, node *html.Node, suffix string) {
+	this.builder.WriteString("\n\n" + prefix)
+	this.children(node)
+	this.builder.WriteString(suffix + "\n\n")
+}
+
+func (this *markdownWriter) inline(marker string, node *html.Node) {
+	this.builder.WriteString(marker)
+	this.children(node)
+	this.builder.WriteString(marker)
+}
+
+func (this *markdownWriter) children(node *html.Node) {
+	for next := node.FirstChild; next != nil; next = next.NextSibling {
+		this.node(next)
+	}
+}
+
+func (this *markdownWriter) link(reference string) string {
+	resolved, err := crawler.ResolveReference(this.base, reference)
+	if err != nil {
+		return reference
+	}
+	return resolved.String()
+}
+
+func (this *markdownWriter) node(node *html.Node) {
+	if node.Type == html.TextNode {
+		if this.inPre {
+			this.builder.WriteString(node.Data)
+			return
+		}
+		text := strings.Join(strings.Fields(node.Data), " ")
+		if text == "" {
+			if strings.TrimSpace(node.Data) != node.Data && node.Data != "" {
+				this.builder.WriteString(" ")
+			}
+			return
+		}
+		if strings.TrimLeft(node.Data, " \t\r\n") != node.Data {
+			text = " " + text
+		}
+		if strings.TrimRight(node.Data, " \t\r\n") != node.Data {
+			text += " "
+		}
+		this.builder.WriteString(text)
+		return
+	}
+	if node.Type != html.ElementNode {
+		this.children(node)
+		return
+	}
+	if hiddenElements[node.Data] || furnitureElements[node.Data] {
+		return
+	}
+	switch node.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		this.block(strings.Repeat("#", int(node.Data[1]-'0'))+" ", node, "")
+	case "p", "div", "section", "figure", "table":
+		this.block("", node, "")
+	case "tr":
+		this.children(node)
+		this.builder.WriteString("\n")
+	case "td", "th":
+		this.children(node)
+		this.builder.WriteString(" ")
+	case "br":
+		this.builder.WriteString("  \n")
+	case "hr":
+		this.builder.WriteString("\n\n---\n\n")
+	case "strong", "b":
+		this.inline("**", node)
+	case "em", "i":
+		this.inline("*", node)
+	case "code":
+		if this.inPre {
+			this.children(node)
+			return
+		}
+		this.inline("`", node)
+	case "pre":
+		this.builder.WriteString("\n\n```\n")
+		this.inPre = true
+		this.children(node)
+		this.inPre = false
+		this.builder.WriteString("\n```\n\n")
+	case "blockquote":
+		inner := &markdownWriter{base: this.base, builder: &strings.Builder{}}
+		inner.children(node)
+		quoted := strings.Split(strings.TrimSpace(excessNewlines.ReplaceAllString(inner.builder.String(), "\n\n")), "\n")
+		this.builder.WriteString("\n\n> " + strings.Join(quoted, "\n> ") + "\n\n")
+	case "ul", "ol":
+		start := 0
+		if node.Data == "ol" {
+			start = 1
+		}
+		if len(this.lists) == 0 {
+			this.builder.WriteString("\n")
+		}
+		this.lists = append(this.lists, start)
+		this.children(node)
+		this.lists = this.lists[:len(this.lists)-1]
+		if len(this.lists) == 0 {
+			this.builder.WriteString("\n")
+		}
+	case "li":
+		depth := len(this.lists)
+		marker := "- "
+		if depth > 0 && this.lists[depth-1] > 0 {
+			marker = fmt.Sprintf("%d. ", this.lists[depth-1])
+			this.lists[depth-1]++
+		}
+		if depth > 0 {
+			depth--
+		}
+		this.builder.WriteString("\n" + strings.Repeat("  ", depth) + marker)
+		this.children(node)
+	case "a":
+		href := crawler.Attribute(node, "href")
+		if href == "" || strings.HasPrefix(href, "#") {
+			this.children(node)
+			return
+		}
+		this.builder.WriteString("[")
+		this.children(node)
+		this.builder.WriteString("](" + this.link(href) + ")")
+	case "img":
+		if source := crawler.Attribute(node, "src"); source != "" {
+			this.builder.WriteString("![" + crawler.Attribute(node, "alt") + "](" + this.link(source) + ")")
+		}
+	default:
+		this.children(node)
+	}
+}
+
+func toMarkdown(where string, doc *html.Node) string {
+	base, err := url.Parse(where)
+	if err != nil {
+		base = &url.URL{}
+	}
+	writer := &markdownWriter{
+		base:    base,
+		builder: &strings.Builder{},
+		lists:   make([]int, 0),
+	}
+	writer.node(contentRoot(doc))
+	lines := strings.Split(writer.builder.String(), "\n")
+	for i, nextLine := range lines {
+		if strings.TrimSpace(nextLine) == "" {
+			lines[i] = ""
+		}
+	}
+	return strings.TrimSpace(excessNewlines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")) + "\n"
+}
+
+func markdownPath(where string) string {
+	parsed, err := url.Parse(where)
+	if err != nil {
+		return ""
+	}
+	path := strings.TrimSuffix(parsed.Path, "/")
+	if path == "" || strings.HasSuffix(parsed.Path, "/") {
+		path += "/index"
+	}
+	path = strings.TrimSuffix(path, filepath.Ext(path))
+	if parsed.RawQuery != "" {
+		path += "-" + parsed.RawQuery
+	}
+	return filepath.Join(viper.GetString("Markdown.Path"), unsafePath.ReplaceAllString(parsed.Host+path, "_")+".md")
+}
+
+func writeMarkdown(where string, title string, markdown string) {
+	target := markdownPath(where)
+	if target == "" {
+		return
+	}
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		slog.Error("Cannot create Markdown directory", "url", where, failed(err))
+		return
+	}
+	frontMatter := fmt.Sprintf("---\ntitle: %q\nsource: %q\n---\n\n", title, where)
+	err = os.WriteFile(target, []byte(frontMatter+markdown), 0644)
+	if err != nil {
+		slog.Error("Cannot write Markdown", "url", where, failed(err))
+	}
+}
@@ -0,0 +1,113 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"github.com/lunar-parklife/pagecrawl/pkg/crawler"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Elements whose attribute loads a subresource into the page, as opposed to
+// linking to another document.
+var subresourceAttributes = map[string]string{
+	"audio":  "src",
+	"embed":  "src",
+	"iframe": "src",
+	"img":    "src",
+	"object": "data",
+	"script": "src",
+	"source": "src",
+	"track":  "src",
+	"video":  "src",
+}
+
+func subresources(doc *html.Node) []string {
+	buf := make([]string, 0)
+	if doc.Type == html.ElementNode {
+		key, ok := subresourceAttributes[doc.Data]
+		if doc.Data == "link" {
+			rel := strings.ToLower(crawler.Attribute(doc, "rel"))
+			key, ok = "href", strings.Contains(rel, "stylesheet") || strings.Contains(rel, "icon") || strings.Contains(rel, "preload")
+		}
+		if ok {
+			if value := crawler.Attribute(doc, key); value != "" {
+				buf = append(buf, value)
+			}
+		}
+	}
+	for next := doc.FirstChild; next != nil; next = next.NextSibling {
+		buf = append(buf, subresources(next)...)
+	}
+	return buf
+}
+
+func findMixedContent(where string, doc *html.Node) []string {
+	buf := make([]string, 0)
+	if !strings.HasPrefix(strings.ToLower(where), "https://") {
+		return buf
+	}
+	for _, nextResource := range subresources(doc) {
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(nextResource)), "http://") {
+			buf = append(buf, nextResource)
+		}
+	}
+	return buf
+}
+
+type hostMixedContent struct {
+	Pages    int                 `json:"pages"`
+	Affected map[string][]string `json:"affected"`
+}
+
+type mixedContentReport struct {
+	hosts map[string]*hostMixedContent
+}
+
+func newMixedContentReport() *mixedContentReport {
+	return &mixedContentReport{
+		hosts: make(map[string]*hostMixedContent),
+	}
+}
+
+func (this *mixedContentReport) name() string {
+	return "mixed-content"
+}
+
+func (this *mixedContentReport) observe(asset *asset) {
+	if !strings.HasPrefix(strings.ToLower(asset.Address), "https://") {
+		return
+	}
+	host := hostOf(asset.Address)
+	summary, ok := this.hosts[host]
+	if !ok {
+		summary = &hostMixedContent{
+			Affected: make(map[string][]string),
+		}
+		this.hosts[host] = summary
+	}
+	summary.Pages++
+	if len(asset.MixedContent) > 0 {
+		summary.Affected[asset.Address] = asset.MixedContent
+	}
+}
+
+func (this *mixedContentReport) summary() any {
+	return this.hosts
+}
@@ -0,0 +1,136 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/proxy"
+)
+
+const torPassword = "pagecrawl"
+
+var directDialer = &net.Dialer{}
+
+func throughTor(host string) bool {
+	if viper.GetString("Tor.Proxy") == "" {
+		return false
+	}
+	return viper.GetBool("Tor.All") || strings.HasSuffix(strings.ToLower(host), ".onion")
+}
+
+// Tor puts connections with different SOCKS credentials on different
+// circuits, so using the host as user name isolates hosts from each other.
+// Hosts reached through Tor are resolved by the exit, so they aren't checked
+// for private addresses. Neither is the proxy, whose requests proxyFor has
+// checked already.
+func dialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	if !throughTor(host) {
+		if blockPrivate() && !isProxy(address) {
+			return dialPublic(ctx, network, address)
+		}
+		return dialResolved(ctx, network, address)
+	}
+	dialer, err := proxy.SOCKS5("tcp", viper.GetString("Tor.Proxy"), &proxy.Auth{
+		User:     host,
+		Password: torPassword,
+	}, directDialer)
+	if err != nil {
+		return nil, err
+	}
+	return dialer.(proxy.ContextDialer).DialContext(ctx, network, address)
+}
+
+// Requests that aren't part of the crawl, like outputs and notifications.
+var serviceClient = &http.Client{}
+
+func timeout(key string) time.Duration {
+	return time.Duration(viper.GetFloat64(key) * float64(time.Second))
+}
+
+func timedTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSHandshakeTimeout = timeout("Network.TimeoutTLS")
+	transport.ResponseHeaderTimeout = timeout("Network.TimeoutHeaders")
+	return transport
+}
+
+func initClient() {
+	directDialer.Timeout = timeout("Network.TimeoutConnect")
+	initResolver()
+	initTransports()
+}
+
+// Sets up the clients' transports again without touching the resolver, so
+// flags changing how requests are sent keep the DNS cache.
+func initTransports() {
+	client.Timeout = timeout("Network.TimeoutTotal")
+	serviceTransport := timedTransport()
+	serviceTransport.DialContext = directDialer.DialContext
+	serviceClient.Transport = serviceTransport
+	serviceClient.Timeout = client.Timeout
+	initTLS()
+	transport := timedTransport()
+	transport.TLSClientConfig = tlsConfig.Clone()
+	transport.DialContext = dialContext
+	transport.Proxy = proxyFor
+	client.Transport = transport
+	version := httpVersion()
+	err := validHTTPVersion(version)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid Network.HTTPVersion: %s", err.Error()))
+	}
+	switch version {
+	case "1.1":
+		// A non-nil, empty TLSNextProto keeps HTTP/2 from being negotiated.
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+		transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	case "2":
+	case "3":
+		client.Transport = newFallbackTransport(transport)
+	}
+}
+
+func validHTTPVersion(version string) error {
+	switch version {
+	case "1.1", "2", "3":
+		return nil
+	}
+	return fmt.Errorf("unknown HTTP version %s, expected 1.1, 2 or 3", version)
+}
+
+// HTTP/2 is used wherever servers offer it, and Network.HTTP3 stands for
+// version 3 from before there was a choice.
+func httpVersion() string {
+	if viper.GetBool("Network.HTTP3") {
+		return "3"
+	}
+	return strings.TrimPrefix(strings.ToLower(viper.GetString("Network.HTTPVersion")), "http/")
+}
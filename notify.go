@@ -0,0 +1,250 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	changeChanged   = "changed"
+	changeFailing   = "failing"
+	changeRecovered = "recovered"
+)
+
+type pageState struct {
+	Job        string    `json:"job"`
+	Run        string    `json:"run"`
+	Checked    time.Time `json:"checked"`
+	Hash       string    `json:"hash,omitempty"`
+	Size       int       `json:"size,omitempty"`
+	Status     int       `json:"status,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	References []string  `json:"references,omitempty"`
+}
+
+type changeNotice struct {
+	Job      string    `json:"job"`
+	Run      string    `json:"run"`
+	Address  string    `json:"address"`
+	Kind     string    `json:"kind"`
+	Summary  string    `json:"summary"`
+	Detected time.Time `json:"detected"`
+}
+
+type monitor struct {
+	lock  *sync.Mutex
+	path  string
+	pages map[string]*pageState
+}
+
+var pageMonitor *monitor
+
+func loadMonitor(path string) (*monitor, error) {
+	loaded := &monitor{
+		lock:  &sync.Mutex{},
+		path:  path,
+		pages: make(map[string]*pageState),
+	}
+	rawState, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return loaded, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(rawState, &loaded.pages)
+	if err != nil {
+		return nil, err
+	}
+	return loaded, nil
+}
+
+func (this *monitor) save() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	rawState, err := json.MarshalIndent(this.pages, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(this.path, rawState, 0644)
+}
+
+// Compares this fetch against the previous one and notifies about the
+// difference, if there is any worth mentioning.
+func (this *monitor) check(where string, status int, body []byte, references []string, fetchErr error) {
+	current := &pageState{
+		Job:        jobName,
+		Run:        runID,
+		Checked:    time.Now().UTC(),
+		Status:     status,
+		References: references,
+	}
+	if fetchErr != nil {
+		current.Error = fetchErr.Error()
+	} else if status >= 400 {
+		current.Error = http.StatusText(status)
+	}
+	if current.Error == "" {
+		digest := sha256.Sum256(body)
+		current.Hash = hex.EncodeToString(digest[:])
+		current.Size = len(body)
+	}
+	this.lock.Lock()
+	previous, seen := this.pages[where]
+	if current.Error != "" && seen && previous.Error == "" {
+		// Keep the last good copy around so recovery can be compared against it.
+		current.Hash, current.Size, current.References = previous.Hash, previous.Size, previous.References
+	}
+	this.pages[where] = current
+	this.lock.Unlock()
+	if !seen {
+		return
+	}
+	notice := &changeNotice{
+		Job:      jobName,
+		Run:      runID,
+		Address:  where,
+		Detected: current.Checked,
+	}
+	switch {
+	case current.Error != "" && previous.Error == "":
+		notice.Kind = changeFailing
+		notice.Summary = fmt.Sprintf("%s started failing: %s", where, current.Error)
+	case current.Error != "":
+		return
+	case previous.Error != "":
+		notice.Kind = changeRecovered
+		notice.Summary = fmt.Sprintf("%s recovered after failing with: %s", where, previous.Error)
+	case current.Hash != previous.Hash:
+		notice.Kind = changeChanged
+		notice.Summary = summariseChange(where, previous, current)
+	default:
+		return
+	}
+	notify(notice)
+}
+
+func summariseChange(where string, previous *pageState, current *pageState) string {
+	before := make(map[string]bool)
+	for _, nextReference := range previous.References {
+		before[nextReference] = true
+	}
+	after := make(map[string]bool)
+	added := 0
+	for _, nextReference := range current.References {
+		after[nextReference] = true
+		if !before[nextReference] {
+			added++
+		}
+	}
+	removed := 0
+	for nextReference := range before {
+		if !after[nextReference] {
+			removed++
+		}
+	}
+	return fmt.Sprintf("%s changed: %d bytes to %d bytes, %d links added, %d links removed",
+		where, previous.Size, current.Size, added, removed)
+}
+
+func notify(notice *changeNotice) {
+	slog.Info(notice.Summary, "url", notice.Address, "kind", notice.Kind)
+	if webhook := viper.GetString("Notify.Webhook"); webhook != "" {
+		rawNotice, err := json.Marshal(notice)
+		if err == nil {
+			err = postNotice(webhook, rawNotice)
+		}
+		if err != nil {
+			slog.Error("Cannot notify webhook", "url", webhook, failed(err))
+		}
+	}
+	if slack := viper.GetString("Notify.Slack"); slack != "" {
+		rawMessage, err := json.Marshal(map[string]string{"text": notice.Summary})
+		if err == nil {
+			err = postNotice(slack, rawMessage)
+		}
+		if err != nil {
+			slog.Error("Cannot notify Slack", failed(err))
+		}
+	}
+	if server := viper.GetString("Notify.SMTPServer"); server != "" {
+		err := mailNotice(server, notice)
+		if err != nil {
+			slog.Error("Cannot notify by mail", "server", server, failed(err))
+		}
+	}
+}
+
+func postNotice(where string, body []byte) error {
+	request, err := http.NewRequest(http.MethodPost, where, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Add("Content-Type", "application/json")
+	request.Header.Add("User-Agent", pageCrawler.UserAgent)
+	response, err := serviceClient.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return nil
+}
+
+func mailNotice(server string, notice *changeNotice) error {
+	from := viper.GetString("Notify.SMTPFrom")
+	to := parseFields(viper.GetString("Notify.SMTPTo"))
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: pagecrawl: %s %s\r\n\r\n%s\r\n",
+		from, strings.Join(to, ", "), notice.Address, notice.Kind, notice.Summary)
+	var auth smtp.Auth
+	if user := viper.GetString("Notify.SMTPUser"); user != "" {
+		host := strings.Split(server, ":")[0]
+		auth = smtp.PlainAuth("", user, viper.GetString("Notify.SMTPPassword"), host)
+	}
+	return smtp.SendMail(server, auth, from, to, []byte(message))
+}
+
+func initMonitor() {
+	statePath := viper.GetString("Notify.State")
+	if statePath == "" {
+		return
+	}
+	loaded, err := loadMonitor(statePath)
+	if err != nil {
+		panic(fmt.Sprintf("Cannot load monitor state %s: %s", statePath, err.Error()))
+	}
+	pageMonitor = loaded
+}
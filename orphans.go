@@ -0,0 +1,165 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"github.com/lunar-parklife/pagecrawl/pkg/crawler"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type orphanReport struct {
+	lock     *sync.Mutex
+	sitemaps map[string]*sync.Once
+	mapped   map[string]map[string]bool
+	seeds    map[string]map[string]bool
+	linked   map[string]map[string]bool
+}
+
+var orphans *orphanReport
+
+func newOrphanReport() *orphanReport {
+	return &orphanReport{
+		lock:     &sync.Mutex{},
+		sitemaps: make(map[string]*sync.Once),
+		mapped:   make(map[string]map[string]bool),
+		seeds:    make(map[string]map[string]bool),
+		linked:   make(map[string]map[string]bool),
+	}
+}
+
+func (this *orphanReport) name() string {
+	return "orphans"
+}
+
+func (this *orphanReport) add(set map[string]map[string]bool, where string) {
+	host := hostOf(where)
+	if set[host] == nil {
+		set[host] = make(map[string]bool)
+	}
+	set[host][withoutFragment(where)] = true
+}
+
+func (this *orphanReport) seeded(where string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.add(this.seeds, where)
+}
+
+// Reads the sitemaps of the address' origin the first time the origin is
+// seen. Sitemaps are found through robots.txt, falling back to
+// /sitemap.xml.
+func (this *orphanReport) loadSitemaps(address *url.URL) {
+	origin := address.Scheme + "://" + strings.ToLower(address.Host)
+	this.lock.Lock()
+	once, ok := this.sitemaps[origin]
+	if !ok {
+		once = &sync.Once{}
+		this.sitemaps[origin] = once
+	}
+	this.lock.Unlock()
+	once.Do(func() {
+		sitemaps := robotsFor(address).sitemaps
+		if len(sitemaps) == 0 {
+			sitemaps = []string{origin + "/sitemap.xml"}
+		}
+		for _, nextSitemap := range sitemaps {
+			addresses := readSitemap(nextSitemap)
+			this.lock.Lock()
+			for _, nextAddress := range addresses {
+				this.add(this.mapped, nextAddress)
+			}
+			this.lock.Unlock()
+		}
+	})
+}
+
+// Only references to the page's own host count as internal links.
+func (this *orphanReport) observe(asset *asset) {
+	page, err := url.Parse(asset.Address)
+	if err != nil {
+		return
+	}
+	base := page
+	if asset.FinalAddress != "" {
+		if final, err := url.Parse(asset.FinalAddress); err == nil {
+			base = final
+		}
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for _, nextReference := range asset.References {
+		resolved, err := crawler.ResolveReference(base, nextReference)
+		if err != nil || !strings.EqualFold(resolved.Host, base.Host) {
+			continue
+		}
+		this.add(this.linked, resolved.String())
+	}
+}
+
+func missingFrom(from map[string]bool, others ...map[string]bool) []string {
+	buf := make([]string, 0)
+	for nextAddress := range from {
+		found := false
+		for _, nextOther := range others {
+			if nextOther[nextAddress] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			buf = append(buf, nextAddress)
+		}
+	}
+	sort.Strings(buf)
+	return buf
+}
+
+func (this *orphanReport) summary() any {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	type hostOrphans struct {
+		// In a sitemap or the input, but not linked to by any crawled page.
+		Orphaned []string `json:"orphaned"`
+		// Linked to by a crawled page, but in none of the host's sitemaps.
+		Unmapped []string `json:"unmapped"`
+	}
+	hosts := make(map[string]bool)
+	for _, nextSet := range []map[string]map[string]bool{this.mapped, this.seeds, this.linked} {
+		for nextHost := range nextSet {
+			hosts[nextHost] = true
+		}
+	}
+	buf := make(map[string]hostOrphans, len(hosts))
+	for nextHost := range hosts {
+		listed := make(map[string]bool)
+		for nextAddress := range this.mapped[nextHost] {
+			listed[nextAddress] = true
+		}
+		for nextAddress := range this.seeds[nextHost] {
+			listed[nextAddress] = true
+		}
+		buf[nextHost] = hostOrphans{
+			Orphaned: missingFrom(listed, this.linked[nextHost]),
+			Unmapped: missingFrom(this.linked[nextHost], this.mapped[nextHost]),
+		}
+	}
+	return buf
+}
@@ -0,0 +1,282 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	orderCompletion = "completion"
+	orderInput      = "input"
+	orderAddress    = "address"
+)
+
+const (
+	formatNDJSON = "ndjson"
+	formatJSON   = "json"
+)
+
+const (
+	dataBase64 = "base64"
+	dataText   = "text"
+	dataHex    = "hex"
+	dataOmit   = "omit"
+)
+
+// An output and how it wants assets encoded. Its lock keeps its sink to one
+// write or flush at a time.
+type assetOutput struct {
+	lock   *sync.Mutex
+	name   string
+	sink   sink
+	data   string
+	fields []string
+
+	onError    string
+	retries    int
+	retryDelay time.Duration
+	flushEvery int
+	unflushed  int
+	disabled   bool
+	closed     bool
+}
+
+func validOutputFormat(format string) error {
+	switch format {
+	case formatNDJSON:
+		return nil
+	case formatJSON:
+		// Assets used to be run together with nothing in between, which no
+		// JSON parser reads back.
+		slog.Warn("The json output format is deprecated, writing ndjson instead")
+		return nil
+	}
+	return fmt.Errorf("unknown output format %s, expected ndjson", format)
+}
+
+// Stands in for an asset whose data isn't base64 encoded. The outer Data
+// hides the asset's own.
+type encodedAsset struct {
+	*asset
+	Data         any    `json:"data,omitempty"`
+	DataEncoding string `json:"dataEncoding,omitempty"`
+}
+
+func validDataEncoding(encoding string) error {
+	switch encoding {
+	case dataBase64, dataText, dataHex, dataOmit:
+		return nil
+	}
+	return fmt.Errorf("unknown data encoding %s, expected base64, text, hex or omit", encoding)
+}
+
+// Data that isn't base64 encoded says how it is in dataEncoding, so it can
+// be read back. Text data that isn't valid UTF-8 is base64 encoded after
+// all, and says so too.
+func encodeAsset(asset *asset, encoding string) ([]byte, error) {
+	if encoding == dataBase64 || encoding == "" {
+		return json.Marshal(asset)
+	}
+	encoded := &encodedAsset{
+		asset: asset,
+	}
+	if asset.Data != nil {
+		switch encoding {
+		case dataText:
+			if utf8.Valid(asset.Data) {
+				encoded.Data = string(asset.Data)
+				encoded.DataEncoding = dataText
+			} else {
+				encoded.Data = asset.Data
+				encoded.DataEncoding = dataBase64
+			}
+		case dataHex:
+			encoded.Data = hex.EncodeToString(asset.Data)
+			encoded.DataEncoding = dataHex
+		}
+	}
+	return json.Marshal(encoded)
+}
+
+// Reads an asset back however its data was encoded. Data without a
+// dataEncoding is base64, as it is by default.
+func decodeAsset(rawAsset []byte) (*asset, error) {
+	decoded := &struct {
+		*asset
+		Data         *string `json:"data"`
+		DataEncoding string  `json:"dataEncoding"`
+	}{
+		asset: &asset{},
+	}
+	err := json.Unmarshal(rawAsset, decoded)
+	if err != nil {
+		return nil, err
+	}
+	if decoded.Data == nil {
+		return decoded.asset, nil
+	}
+	switch decoded.DataEncoding {
+	case dataBase64, "":
+		decoded.asset.Data, err = base64.StdEncoding.DecodeString(*decoded.Data)
+	case dataText:
+		decoded.asset.Data = []byte(*decoded.Data)
+	case dataHex:
+		decoded.asset.Data, err = hex.DecodeString(*decoded.Data)
+	default:
+		err = fmt.Errorf("unknown data encoding %s", decoded.DataEncoding)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode data of %s: %w", decoded.Address, err)
+	}
+	return decoded.asset, nil
+}
+
+func parseFields(list string) []string {
+	buf := make([]string, 0)
+	for _, nextField := range strings.Split(list, ",") {
+		nextField = strings.TrimSpace(nextField)
+		if nextField != "" {
+			buf = append(buf, nextField)
+		}
+	}
+	return buf
+}
+
+// Keeps only the named fields, in the order they were named. Names match
+// the JSON keys regardless of case.
+func selectFields(rawAsset []byte, fields []string) ([]byte, error) {
+	if len(fields) == 0 {
+		return rawAsset, nil
+	}
+	all := make(map[string]json.RawMessage)
+	err := json.Unmarshal(rawAsset, &all)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]string, len(all))
+	for nextKey := range all {
+		byName[strings.ToLower(nextKey)] = nextKey
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	written := 0
+	for _, nextField := range fields {
+		key, ok := byName[strings.ToLower(nextField)]
+		if !ok {
+			continue
+		}
+		if written > 0 {
+			buf.WriteByte(',')
+		}
+		rawKey, _ := json.Marshal(key)
+		buf.Write(rawKey)
+		buf.WriteByte(':')
+		buf.Write(all[key])
+		written++
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Puts every asset on a line of its own.
+func (this *assetOutput) encode(asset *asset) ([]byte, error) {
+	rawAsset, err := encodeAsset(asset, this.data)
+	if err != nil {
+		return nil, err
+	}
+	rawAsset, err = selectFields(rawAsset, this.fields)
+	if err != nil {
+		return nil, err
+	}
+	return append(rawAsset, '\n'), nil
+}
+
+// Holds assets back until the crawl is over when they should come out in a
+// stable order rather than whenever their fetch happened to finish.
+type orderedOutput struct {
+	lock  *sync.Mutex
+	order string
+	seen  map[string]int
+	held  []*asset
+}
+
+var ordering *orderedOutput
+
+func initOrdering(order string) error {
+	switch order {
+	case orderCompletion:
+		ordering = nil
+		return nil
+	case orderInput, orderAddress:
+		ordering = &orderedOutput{
+			lock:  &sync.Mutex{},
+			order: order,
+			seen:  make(map[string]int),
+			held:  make([]*asset, 0),
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown order %s, expected completion, input or address", order)
+}
+
+// Remembers when an address was first read or discovered.
+func (this *orderedOutput) queued(where string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, ok := this.seen[where]; !ok {
+		this.seen[where] = len(this.seen)
+	}
+}
+
+func (this *orderedOutput) hold(asset *asset) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.held = append(this.held, asset)
+}
+
+func (this *orderedOutput) flush() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	sort.SliceStable(this.held, func(i int, j int) bool {
+		left, right := this.held[i], this.held[j]
+		if this.order == orderInput && this.seen[left.Address] != this.seen[right.Address] {
+			return this.seen[left.Address] < this.seen[right.Address]
+		}
+		if left.Address != right.Address {
+			return left.Address < right.Address
+		}
+		return left.Accessed.Before(right.Accessed)
+	})
+	for _, nextAsset := range this.held {
+		if writeAsset(nextAsset) {
+			slog.Debug("Output", "url", nextAsset.Address)
+		}
+	}
+	this.held = this.held[:0]
+}
@@ -0,0 +1,145 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseFields(t *testing.T) {
+	tests := []struct {
+		list   string
+		fields []string
+	}{
+		{list: "", fields: []string{}},
+		{list: "address", fields: []string{"address"}},
+		{list: " address , status,,title ", fields: []string{"address", "status", "title"}},
+	}
+	for _, nextTest := range tests {
+		if fields := parseFields(nextTest.list); !reflect.DeepEqual(fields, nextTest.fields) {
+			t.Errorf("parseFields(%q) = %q, want %q", nextTest.list, fields, nextTest.fields)
+		}
+	}
+}
+
+func TestSelectFields(t *testing.T) {
+	raw := []byte(`{"address":"https://example.com/","status":200,"title":"Home","headers":{"Server":["nginx"]}}`)
+	tests := []struct {
+		name     string
+		fields   []string
+		selected string
+	}{
+		{name: "all without fields", selected: string(raw)},
+		{name: "in the order named", fields: []string{"title", "address"}, selected: `{"title":"Home","address":"https://example.com/"}`},
+		{name: "any case", fields: []string{"STATUS"}, selected: `{"status":200}`},
+		{name: "nested values kept whole", fields: []string{"headers"}, selected: `{"headers":{"Server":["nginx"]}}`},
+		{name: "unknown fields left out", fields: []string{"missing", "status"}, selected: `{"status":200}`},
+		{name: "nothing known", fields: []string{"missing"}, selected: `{}`},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			selected, err := selectFields(raw, nextTest.fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(selected) != nextTest.selected {
+				t.Errorf("selectFields(%v) = %s, want %s", nextTest.fields, selected, nextTest.selected)
+			}
+		})
+	}
+}
+
+func TestEncodeAsset(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		encoding string
+		value    any
+		wrapped  string
+	}{
+		{name: "base64 by default", data: []byte("hi"), value: "aGk="},
+		{name: "base64", data: []byte("hi"), encoding: dataBase64, value: "aGk="},
+		{name: "text", data: []byte("héllo"), encoding: dataText, value: "héllo", wrapped: dataText},
+		{name: "text that isn't utf-8", data: []byte{0xff, 0xfe}, encoding: dataText, value: "//4=", wrapped: dataBase64},
+		{name: "hex", data: []byte{0x0a, 0xff}, encoding: dataHex, value: "0aff", wrapped: dataHex},
+		{name: "omitted", data: []byte("hi"), encoding: dataOmit},
+		{name: "no data", encoding: dataText},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			rawAsset, err := encodeAsset(&asset{Address: "https://example.com/", Data: nextTest.data}, nextTest.encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded := make(map[string]any)
+			err = json.Unmarshal(rawAsset, &decoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded["address"] != "https://example.com/" {
+				t.Errorf("address = %v, want the asset's", decoded["address"])
+			}
+			if !reflect.DeepEqual(decoded["data"], nextTest.value) {
+				t.Errorf("data = %v, want %v", decoded["data"], nextTest.value)
+			}
+			if wrapped, _ := decoded["dataEncoding"].(string); wrapped != nextTest.wrapped {
+				t.Errorf("dataEncoding = %q, want %q", wrapped, nextTest.wrapped)
+			}
+		})
+	}
+}
+
+func TestDecodeAsset(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		encoding string
+	}{
+		{name: "base64", data: []byte{0x00, 0xff, 'h', 'i'}, encoding: dataBase64},
+		{name: "text", data: []byte("<p>héllo</p>"), encoding: dataText},
+		{name: "text that isn't utf-8", data: []byte{0xff, 0xfe}, encoding: dataText},
+		{name: "hex", data: []byte("cafe"), encoding: dataHex},
+		{name: "omitted", data: []byte("hi"), encoding: dataOmit},
+		{name: "no data", encoding: dataText},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			rawAsset, err := encodeAsset(&asset{Address: "https://example.com/", ContentType: "text/html", Data: nextTest.data}, nextTest.encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := decodeAsset(rawAsset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := nextTest.data
+			if nextTest.encoding == dataOmit {
+				want = nil
+			}
+			if decoded.Address != "https://example.com/" || decoded.ContentType != "text/html" {
+				t.Errorf("decodeAsset() = %s %s, want the asset's address and content type", decoded.Address, decoded.ContentType)
+			}
+			if !bytes.Equal(decoded.Data, want) {
+				t.Errorf("decodeAsset() data = %q, want %q", decoded.Data, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package crawler fetches pages and extracts their references, so other
+// programs can crawl the way pagecrawl does without shelling out to it.
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Version is the version of pagecrawl.
+const Version = "0.1.0"
+
+// UserAgent is what a Crawler calls itself unless told otherwise.
+const UserAgent = "pagecrawl; " + Version
+
+// A page as fetched by a Crawler.
+type Asset struct {
+	Accessed      time.Time   `json:"accessed"`
+	Address       string      `json:"address"`
+	FinalAddress  string      `json:"finalAddress,omitempty"`
+	Status        int         `json:"status"`
+	ContentType   string      `json:"contentType,omitempty"`
+	Data          []byte      `json:"data"`
+	References    []string    `json:"references"`
+	ReferenceTags []Reference `json:"referenceTags,omitempty"`
+}
+
+// The pagecrawl binary sends its requests through a Crawler as well, with
+// its own client, user agent and From.
+type Crawler struct {
+	// Client sends every request, http.DefaultClient if nil.
+	Client *http.Client
+	// UserAgent and From are sent with every request when set.
+	UserAgent string
+	From      string
+	// Sinks receive every asset fetched, once Open has opened them.
+	Sinks []Sink[*Asset]
+}
+
+func New(sinks ...Sink[*Asset]) *Crawler {
+	return &Crawler{
+		UserAgent: UserAgent,
+		Sinks:     sinks,
+	}
+}
+
+func (this *Crawler) client() *http.Client {
+	if this.Client == nil {
+		return http.DefaultClient
+	}
+	return this.Client
+}
+
+// NewRequest creates a request carrying the crawler's User-Agent and From.
+func (this *Crawler) NewRequest(ctx context.Context, method string, address string, body io.Reader) (*http.Request, error) {
+	request, err := http.NewRequestWithContext(ctx, method, address, body)
+	if err != nil {
+		return nil, err
+	}
+	if this.UserAgent != "" {
+		request.Header.Set("User-Agent", this.UserAgent)
+	}
+	if this.From != "" {
+		request.Header.Set("From", this.From)
+	}
+	return request, nil
+}
+
+// Do sends the request with the crawler's client.
+func (this *Crawler) Do(request *http.Request) (*http.Response, error) {
+	return this.client().Do(request)
+}
+
+// Open opens every sink, before the first Fetch.
+func (this *Crawler) Open() error {
+	for _, nextSink := range this.Sinks {
+		err := nextSink.Open()
+		if err != nil {
+			return fmt.Errorf("cannot open sink: %w", err)
+		}
+	}
+	return nil
+}
+
+// Fetch retrieves the page, collects its references with the parser for its
+// media type and hands the asset, encoded as a JSON line, to every sink,
+// flushing it after. The asset is returned even if a sink fails.
+func (this *Crawler) Fetch(ctx context.Context, address string) (*Asset, error) {
+	request, err := this.NewRequest(ctx, http.MethodGet, address, nil)
+	if err != nil {
+		return nil, err
+	}
+	accessed := time.Now().UTC()
+	response, err := this.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	rawResponse, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	asset := &Asset{
+		Accessed:    accessed,
+		Address:     address,
+		Status:      response.StatusCode,
+		ContentType: response.Header.Get("Content-Type"),
+		Data:        rawResponse,
+		References:  make([]string, 0),
+	}
+	if final := response.Request.URL.String(); final != address {
+		asset.FinalAddress = final
+	}
+	if references, ok := Parse(response.Request.URL, MediaType(asset.ContentType, rawResponse), rawResponse); ok {
+		asset.ReferenceTags = references
+		asset.References = Addresses(references)
+	}
+	rawAsset, err := json.Marshal(asset)
+	if err != nil {
+		return asset, err
+	}
+	rawAsset = append(rawAsset, '\n')
+	for _, nextSink := range this.Sinks {
+		err = nextSink.Write(asset, rawAsset)
+		if err == nil {
+			err = nextSink.Flush()
+		}
+		if err != nil {
+			return asset, fmt.Errorf("sink failed: %w", err)
+		}
+	}
+	return asset, nil
+}
+
+// Close flushes and closes every sink, after the last Fetch.
+func (this *Crawler) Close() error {
+	for _, nextSink := range this.Sinks {
+		err := nextSink.Flush()
+		if err == nil {
+			err = nextSink.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("cannot close sink: %w", err)
+		}
+	}
+	return nil
+}
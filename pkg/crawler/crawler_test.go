@@ -0,0 +1,100 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/page":
+			writer.Header().Set("Content-Type", "text/html")
+			writer.Write([]byte(`<a href="/other">x</a>`))
+		case "/style.css":
+			writer.Header().Set("Content-Type", "text/css")
+			writer.Write([]byte(`body { background: url("/bg.png") }`))
+		case "/moved":
+			http.Redirect(writer, request, "/page", http.StatusFound)
+		case "/agent":
+			writer.Header().Set("Content-Type", "text/plain")
+			writer.Write([]byte(request.Header.Get("User-Agent") + " " + request.Header.Get("From")))
+		default:
+			http.NotFound(writer, request)
+		}
+	}))
+	defer server.Close()
+	tests := []struct {
+		name       string
+		path       string
+		status     int
+		final      string
+		data       string
+		references []string
+	}{
+		{name: "html", path: "/page", status: 200, references: []string{server.URL + "/other"}},
+		{name: "css", path: "/style.css", status: 200, references: []string{server.URL + "/bg.png"}},
+		{name: "redirect", path: "/moved", status: 200, final: server.URL + "/page", references: []string{server.URL + "/other"}},
+		{name: "identity", path: "/agent", status: 200, data: UserAgent + " crawler@example.com", references: []string{}},
+		{name: "missing", path: "/missing", status: 404, references: []string{}},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			written := make([]*Asset, 0)
+			lines := &bytes.Buffer{}
+			crawler := New(NewJSONSink(lines), SinkFunc[*Asset](func(asset *Asset, encoded []byte) error {
+				written = append(written, asset)
+				return nil
+			}))
+			crawler.From = "crawler@example.com"
+			if err := crawler.Open(); err != nil {
+				t.Fatal(err)
+			}
+			asset, err := crawler.Fetch(context.Background(), server.URL+nextTest.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := crawler.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if asset.Status != nextTest.status || asset.FinalAddress != nextTest.final {
+				t.Errorf("Fetch() = %d %s, want %d %s", asset.Status, asset.FinalAddress, nextTest.status, nextTest.final)
+			}
+			if nextTest.data != "" && string(asset.Data) != nextTest.data {
+				t.Errorf("Fetch() data = %q, want %q", asset.Data, nextTest.data)
+			}
+			if !reflect.DeepEqual(asset.References, nextTest.references) {
+				t.Errorf("Fetch() references = %v, want %v", asset.References, nextTest.references)
+			}
+			if len(written) != 1 || written[0] != asset {
+				t.Errorf("sink got %d assets, want the fetched one", len(written))
+			}
+			decoded := &Asset{}
+			if err := json.Unmarshal(lines.Bytes(), decoded); err != nil || decoded.Address != asset.Address {
+				t.Errorf("JSON sink wrote %q", lines.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package crawler
+
+import (
+	"bytes"
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+)
+
+// A Parser finds the references in a body of the media type it is
+// registered for, resolved against the address the body came from.
+type Parser func(base *url.URL, body []byte) []Reference
+
+var (
+	parsers     = make(map[string]Parser)
+	parsersLock = &sync.RWMutex{}
+)
+
+// RegisterParser makes the parser handle bodies of the media type, which is
+// either a full type like text/css or type/* for all of a type's subtypes
+// that have no parser of their own. It replaces any parser registered for
+// the type before.
+func RegisterParser(mediaType string, parser Parser) {
+	parsersLock.Lock()
+	defer parsersLock.Unlock()
+	parsers[strings.ToLower(mediaType)] = parser
+}
+
+// MediaType is the media type of the body, from its Content-Type or, when
+// that is missing, from sniffing the body.
+func MediaType(contentType string, body []byte) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+	}
+	return strings.ToLower(mediaType)
+}
+
+// IsHTML tells whether the media type is parsed as HTML.
+func IsHTML(mediaType string) bool {
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// ParserFor finds the parser for the media type, falling back to the one
+// for type/* and then to the XML parser for types ending in +xml. Bodies of
+// media types without a parser, like images and archives, have no
+// references.
+func ParserFor(mediaType string) Parser {
+	parsersLock.RLock()
+	defer parsersLock.RUnlock()
+	if parser, ok := parsers[mediaType]; ok {
+		return parser
+	}
+	if kind, _, ok := strings.Cut(mediaType, "/"); ok {
+		if parser, ok := parsers[kind+"/*"]; ok {
+			return parser
+		}
+	}
+	if strings.HasSuffix(mediaType, "+xml") {
+		return parsers["application/xml"]
+	}
+	return nil
+}
+
+// Parse finds the references in the body with the parser for its media
+// type, and tells whether there was one.
+func Parse(base *url.URL, mediaType string, body []byte) ([]Reference, bool) {
+	parser := ParserFor(mediaType)
+	if parser == nil {
+		return make([]Reference, 0), false
+	}
+	return parser(base, body), true
+}
+
+func ParseHTML(base *url.URL, body []byte) []Reference {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return make([]Reference, 0)
+	}
+	return Resolve(Base(base, doc), References(doc))
+}
+
+var (
+	cssURL    = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^)'"]*))\s*\)`)
+	cssImport = regexp.MustCompile(`@import\s+(?:"([^"]*)"|'([^']*)')`)
+)
+
+func firstGroup(match []string) string {
+	for _, nextGroup := range match[1:] {
+		if nextGroup != "" {
+			return strings.TrimSpace(nextGroup)
+		}
+	}
+	return ""
+}
+
+// ParseCSS finds the url(...) values and @import rules of a stylesheet.
+func ParseCSS(base *url.URL, body []byte) []Reference {
+	buf := make([]Reference, 0)
+	for _, nextMatch := range cssImport.FindAllStringSubmatch(string(body), -1) {
+		buf = append(buf, Reference{
+			Address:   firstGroup(nextMatch),
+			Element:   "css",
+			Attribute: "import",
+		})
+	}
+	for _, nextMatch := range cssURL.FindAllStringSubmatch(string(body), -1) {
+		address := firstGroup(nextMatch)
+		if address == "" || strings.HasPrefix(address, "data:") {
+			continue
+		}
+		buf = append(buf, Reference{
+			Address:   address,
+			Element:   "css",
+			Attribute: "url",
+		})
+	}
+	return Resolve(base, buf)
+}
+
+func xmlAttribute(element xml.StartElement, key string) string {
+	for _, nextAttribute := range element.Attr {
+		if nextAttribute.Name.Local == key {
+			return nextAttribute.Value
+		}
+	}
+	return ""
+}
+
+// ParseXML finds the <loc> addresses of sitemaps and sitemap indexes, and the
+// links and enclosures of RSS and Atom feeds, which are often served as
+// plain XML.
+func ParseXML(base *url.URL, body []byte) []Reference {
+	buf := make([]Reference, 0)
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = false
+	text := ""
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch typed := token.(type) {
+		case xml.StartElement:
+			text = ""
+			switch typed.Name.Local {
+			case "link":
+				// Atom links are attributes, RSS links are text.
+				rel := strings.ToLower(xmlAttribute(typed, "rel"))
+				if href := xmlAttribute(typed, "href"); href != "" && rel != "self" && rel != "hub" {
+					element := "link"
+					if rel == "enclosure" {
+						element = "enclosure"
+					}
+					buf = append(buf, Reference{
+						Address:   href,
+						Element:   element,
+						Attribute: "href",
+					})
+				}
+			case "enclosure", "content":
+				if address := xmlAttribute(typed, "url"); address != "" {
+					buf = append(buf, Reference{
+						Address:   address,
+						Element:   "enclosure",
+						Attribute: "url",
+					})
+				}
+			}
+		case xml.CharData:
+			text += string(typed)
+		case xml.EndElement:
+			address := strings.TrimSpace(text)
+			text = ""
+			if address == "" {
+				continue
+			}
+			switch typed.Name.Local {
+			case "loc":
+				buf = append(buf, Reference{
+					Address: address,
+					Element: "loc",
+				})
+			case "link":
+				buf = append(buf, Reference{
+					Address:   address,
+					Element:   "link",
+					Attribute: "href",
+				})
+			}
+		}
+	}
+	return Resolve(base, buf)
+}
+
+func init() {
+	RegisterParser("text/html", ParseHTML)
+	RegisterParser("application/xhtml+xml", ParseHTML)
+	RegisterParser("text/css", ParseCSS)
+	RegisterParser("application/xml", ParseXML)
+	RegisterParser("text/xml", ParseXML)
+	RegisterParser("application/rss+xml", ParseXML)
+	RegisterParser("application/atom+xml", ParseXML)
+}
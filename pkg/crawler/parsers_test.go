@@ -0,0 +1,186 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package crawler
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestMediaType(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		mediaType   string
+	}{
+		{name: "content type", contentType: "text/HTML; charset=utf-8", body: "{}", mediaType: "text/html"},
+		{name: "sniffed html", body: "<!DOCTYPE html><html></html>", mediaType: "text/html"},
+		{name: "sniffed text", body: "plain words", mediaType: "text/plain"},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			if mediaType := MediaType(nextTest.contentType, []byte(nextTest.body)); mediaType != nextTest.mediaType {
+				t.Errorf("MediaType() = %s, want %s", mediaType, nextTest.mediaType)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	base, _ := url.Parse("https://example.com/dir/page")
+	tests := []struct {
+		name       string
+		mediaType  string
+		body       string
+		references []Reference
+		parsed     bool
+	}{
+		{
+			name:      "html",
+			mediaType: "text/html",
+			body:      `<a href="other">x</a><img src="/logo.png" srcset="a.png 1x, /b.png 2x">`,
+			references: []Reference{
+				{Address: "https://example.com/dir/other", Element: "a", Attribute: "href"},
+				{Address: "https://example.com/logo.png", Element: "img", Attribute: "src"},
+				{Address: "https://example.com/dir/a.png", Element: "img", Attribute: "srcset"},
+				{Address: "https://example.com/b.png", Element: "img", Attribute: "srcset"},
+			},
+			parsed: true,
+		},
+		{
+			name:      "html base",
+			mediaType: "application/xhtml+xml",
+			body:      `<head><base href="https://cdn.example.com/assets/"></head><a href="style.css">x</a>`,
+			references: []Reference{
+				{Address: "https://cdn.example.com/assets/style.css", Element: "a", Attribute: "href"},
+			},
+			parsed: true,
+		},
+		{
+			name:      "css",
+			mediaType: "text/css",
+			body:      `@import "reset.css"; body { background: url( 'bg.png' ) } i { background: url(data:image/png;base64,AAAA) }`,
+			references: []Reference{
+				{Address: "https://example.com/dir/reset.css", Element: "css", Attribute: "import"},
+				{Address: "https://example.com/dir/bg.png", Element: "css", Attribute: "url"},
+			},
+			parsed: true,
+		},
+		{
+			name:      "sitemap",
+			mediaType: "application/xml",
+			body:      `<urlset><url><loc> https://example.com/a </loc></url></urlset>`,
+			references: []Reference{
+				{Address: "https://example.com/a", Element: "loc"},
+			},
+			parsed: true,
+		},
+		{
+			name:      "atom through +xml",
+			mediaType: "application/vnd.custom+xml",
+			body:      `<feed><link rel="self" href="/feed"/><entry><link href="/post"/><link rel="enclosure" href="/talk.mp3"/></entry></feed>`,
+			references: []Reference{
+				{Address: "https://example.com/post", Element: "link", Attribute: "href"},
+				{Address: "https://example.com/talk.mp3", Element: "enclosure", Attribute: "href"},
+			},
+			parsed: true,
+		},
+		{
+			name:      "rss",
+			mediaType: "application/rss+xml",
+			body:      `<rss><channel><item><link>https://example.com/item</link><enclosure url="/ep.mp3"/></item></channel></rss>`,
+			references: []Reference{
+				{Address: "https://example.com/item", Element: "link", Attribute: "href"},
+				{Address: "https://example.com/ep.mp3", Element: "enclosure", Attribute: "url"},
+			},
+			parsed: true,
+		},
+		{
+			name:       "no parser",
+			mediaType:  "image/png",
+			body:       "\x89PNG",
+			references: []Reference{},
+			parsed:     false,
+		},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			references, parsed := Parse(base, nextTest.mediaType, []byte(nextTest.body))
+			if parsed != nextTest.parsed || !reflect.DeepEqual(references, nextTest.references) {
+				t.Errorf("Parse() = %v, %v, want %v, %v", references, parsed, nextTest.references, nextTest.parsed)
+			}
+		})
+	}
+}
+
+func TestParserForFallbacks(t *testing.T) {
+	custom := func(base *url.URL, body []byte) []Reference {
+		return []Reference{{Address: "custom"}}
+	}
+	RegisterParser("font/*", custom)
+	defer func() {
+		parsersLock.Lock()
+		delete(parsers, "font/*")
+		parsersLock.Unlock()
+	}()
+	tests := []struct {
+		mediaType string
+		custom    bool
+		found     bool
+	}{
+		{mediaType: "font/woff2", custom: true, found: true},
+		{mediaType: "text/html", found: true},
+		{mediaType: "image/svg+xml", found: true},
+		{mediaType: "video/mp4", found: false},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.mediaType, func(t *testing.T) {
+			parser := ParserFor(nextTest.mediaType)
+			if (parser != nil) != nextTest.found {
+				t.Fatalf("ParserFor(%s) found = %v, want %v", nextTest.mediaType, parser != nil, nextTest.found)
+			}
+			if parser == nil {
+				return
+			}
+			references := parser(&url.URL{}, nil)
+			if isCustom := len(references) == 1 && references[0].Address == "custom"; isCustom != nextTest.custom {
+				t.Errorf("ParserFor(%s) custom = %v, want %v", nextTest.mediaType, isCustom, nextTest.custom)
+			}
+		})
+	}
+}
+
+func TestNavigational(t *testing.T) {
+	tests := []struct {
+		reference    Reference
+		navigational bool
+	}{
+		{reference: Reference{Element: "a", Attribute: "href"}, navigational: true},
+		{reference: Reference{Element: "loc"}, navigational: true},
+		{reference: Reference{Element: "iframe", Attribute: "src"}, navigational: true},
+		{reference: Reference{Element: "img", Attribute: "src"}, navigational: false},
+		{reference: Reference{Element: "img", Attribute: "srcset"}, navigational: false},
+	}
+	for _, nextTest := range tests {
+		if navigational := nextTest.reference.Navigational(); navigational != nextTest.navigational {
+			t.Errorf("%+v.Navigational() = %v, want %v", nextTest.reference, navigational, nextTest.navigational)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package crawler
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var linkAttributes = map[string]bool{
+	"href":     true,
+	"src":      true,
+	"srcset":   true,
+	"action":   true,
+	"data-src": true,
+	"poster":   true,
+}
+
+// A reference along with the element and attribute it was found in.
+type Reference struct {
+	Address   string `json:"address"`
+	Element   string `json:"element"`
+	Attribute string `json:"attribute"`
+}
+
+// Navigational tells whether the reference leads to another page rather
+// than something the page embeds.
+func (this Reference) Navigational() bool {
+	switch {
+	case this.Attribute == "href", this.Element == "loc":
+		return true
+	case this.Attribute == "src":
+		return this.Element == "iframe" || this.Element == "frame"
+	}
+	return false
+}
+
+// Attribute is the value of the element's attribute, matched without regard
+// to case, or "" if it has none.
+func Attribute(node *html.Node, key string) string {
+	for _, nextAttribute := range node.Attr {
+		if strings.ToLower(nextAttribute.Key) == key {
+			return nextAttribute.Val
+		}
+	}
+	return ""
+}
+
+// ResolveReference makes the reference, as written on a page, absolute.
+func ResolveReference(base *url.URL, reference string) (*url.URL, error) {
+	parsed, err := url.Parse(strings.TrimSpace(reference))
+	if err != nil {
+		return nil, err
+	}
+	return base.ResolveReference(parsed), nil
+}
+
+func srcsetAddresses(srcset string) []string {
+	buf := make([]string, 0)
+	for _, nextCandidate := range strings.Split(srcset, ",") {
+		fields := strings.Fields(nextCandidate)
+		if len(fields) > 0 {
+			buf = append(buf, fields[0])
+		}
+	}
+	return buf
+}
+
+// References collects every link-bearing attribute in the document, as
+// written on the page.
+func References(doc *html.Node) []Reference {
+	buf := make([]Reference, 0)
+	for _, nextAttribute := range doc.Attr {
+		key := strings.ToLower(nextAttribute.Key)
+		if !linkAttributes[key] || doc.Data == "base" {
+			continue
+		}
+		addresses := []string{nextAttribute.Val}
+		if key == "srcset" {
+			addresses = srcsetAddresses(nextAttribute.Val)
+		}
+		for _, nextAddress := range addresses {
+			buf = append(buf, Reference{
+				Address:   nextAddress,
+				Element:   doc.Data,
+				Attribute: key,
+			})
+		}
+	}
+	for next := doc.FirstChild; next != nil; next = next.NextSibling {
+		buf = append(buf, References(next)...)
+	}
+	return buf
+}
+
+// Base is the address relative references on the page resolve against,
+// which is the first <base href> if the page has one.
+func Base(where *url.URL, doc *html.Node) *url.URL {
+	if doc.Type == html.ElementNode && doc.Data == "base" {
+		if href := Attribute(doc, "href"); href != "" {
+			resolved, err := ResolveReference(where, href)
+			if err == nil {
+				return resolved
+			}
+		}
+	}
+	for next := doc.FirstChild; next != nil; next = next.NextSibling {
+		if found := Base(where, next); found != where {
+			return found
+		}
+	}
+	return where
+}
+
+// Resolve makes every reference absolute, dropping those that can't be
+// parsed.
+func Resolve(base *url.URL, references []Reference) []Reference {
+	buf := make([]Reference, 0, len(references))
+	for _, nextReference := range references {
+		resolved, err := ResolveReference(base, nextReference.Address)
+		if err != nil {
+			continue
+		}
+		nextReference.Address = resolved.String()
+		buf = append(buf, nextReference)
+	}
+	return buf
+}
+
+func Addresses(references []Reference) []string {
+	buf := make([]string, 0, len(references))
+	for _, nextReference := range references {
+		buf = append(buf, nextReference.Address)
+	}
+	return buf
+}
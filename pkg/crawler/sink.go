@@ -0,0 +1,94 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package crawler
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// A Sink is somewhere assets of type A are output to. Sinks are opened
+// once before the first asset, are given every asset along with how it was
+// encoded for them, are flushed now and then and closed after the last
+// asset. The pagecrawl binary's outputs are Sinks of its own asset type.
+type Sink[A any] interface {
+	Open() error
+	Write(asset A, encoded []byte) error
+	Flush() error
+	Close() error
+}
+
+// SinkFunc lets a plain function act as a sink that needs no opening,
+// flushing or closing.
+type SinkFunc[A any] func(asset A, encoded []byte) error
+
+func (this SinkFunc[A]) Open() error {
+	return nil
+}
+
+func (this SinkFunc[A]) Write(asset A, encoded []byte) error {
+	return this(asset, encoded)
+}
+
+func (this SinkFunc[A]) Flush() error {
+	return nil
+}
+
+func (this SinkFunc[A]) Close() error {
+	return nil
+}
+
+// Writes each asset as a line of JSON.
+type jsonSink struct {
+	lock   *sync.Mutex
+	writer io.Writer
+}
+
+// NewJSONSink writes assets to the writer as JSON lines, the same way the
+// pagecrawl binary outputs them. Assets are encoded as they are, whatever
+// they were encoded as for the sink.
+func NewJSONSink(writer io.Writer) Sink[*Asset] {
+	return &jsonSink{
+		lock:   &sync.Mutex{},
+		writer: writer,
+	}
+}
+
+func (this *jsonSink) Open() error {
+	return nil
+}
+
+func (this *jsonSink) Write(asset *Asset, encoded []byte) error {
+	rawAsset, err := json.Marshal(asset)
+	if err != nil {
+		return err
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	_, err = this.writer.Write(append(rawAsset, '\n'))
+	return err
+}
+
+func (this *jsonSink) Flush() error {
+	return nil
+}
+
+func (this *jsonSink) Close() error {
+	return nil
+}
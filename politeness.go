@@ -0,0 +1,175 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	throttleStep     = 250 * time.Millisecond
+	throttleSlowdown = 2.0
+	throttleSpeedup  = 0.75
+	latencyWeight    = 0.2
+)
+
+// How a crawl behaves towards a single host.
+type hostPoliteness struct {
+	lock         *sync.Mutex
+	host         string
+	blockedUntil time.Time
+	backoff      time.Duration
+	delay        time.Duration
+	nextRequest  time.Time
+	latency      time.Duration
+	fastest      time.Duration
+	turn         chan struct{}
+}
+
+var (
+	politeness     = make(map[string]*hostPoliteness)
+	politenessLock = &sync.Mutex{}
+)
+
+func politenessFor(host string) *hostPoliteness {
+	host = strings.ToLower(host)
+	politenessLock.Lock()
+	defer politenessLock.Unlock()
+	state, ok := politeness[host]
+	if !ok {
+		state = &hostPoliteness{
+			lock:  &sync.Mutex{},
+			host:  host,
+			delay: minimumDelay(),
+		}
+		limit := viper.GetInt("Network.MaxPerHost")
+		if limit <= 0 && (state.delay > 0 || viper.GetBool("Network.AdaptiveThrottle")) {
+			limit = 1
+		}
+		if limit > 0 {
+			state.turn = make(chan struct{}, limit)
+		}
+		politeness[host] = state
+	}
+	return state
+}
+
+func minimumDelay() time.Duration {
+	return time.Duration(viper.GetFloat64("Network.DelayPerHost") * float64(time.Second))
+}
+
+// Blocks until the host may be requested again, and reserves the next slot
+// so concurrent fetches of the host stay apart by its delay. Fetches also
+// queue up to take turns, Network.MaxPerHost at a time, or one at a time
+// while requests to the host are delayed and there is no cap.
+func (this *hostPoliteness) wait() {
+	if this.turn != nil {
+		this.turn <- struct{}{}
+	}
+	this.lock.Lock()
+	slot := time.Now()
+	if this.nextRequest.After(slot) {
+		slot = this.nextRequest
+	}
+	if this.blockedUntil.After(slot) {
+		slot = this.blockedUntil
+	}
+	this.nextRequest = slot.Add(this.delay)
+	this.lock.Unlock()
+	if pause := time.Until(slot); pause > 0 {
+		time.Sleep(pause)
+	}
+}
+
+// Slows down when the host answers with server errors or noticeably slower
+// than it can, and speeds back up once it recovers.
+func (this *hostPoliteness) record(latency time.Duration, failed bool) {
+	defer this.release()
+	if !viper.GetBool("Network.AdaptiveThrottle") {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.latency == 0 {
+		this.latency = latency
+	} else {
+		this.latency = time.Duration(float64(this.latency)*(1-latencyWeight) + float64(latency)*latencyWeight)
+	}
+	if this.fastest == 0 || latency < this.fastest {
+		this.fastest = latency
+	}
+	previous := this.delay
+	if failed || this.latency > 2*this.fastest+throttleStep {
+		this.delay = time.Duration(float64(this.delay) * throttleSlowdown)
+		if this.delay < throttleStep {
+			this.delay = throttleStep
+		}
+		if maximum := time.Duration(viper.GetInt("Network.MaxDelay")) * time.Second; this.delay > maximum {
+			this.delay = maximum
+		}
+	} else {
+		this.delay = time.Duration(float64(this.delay) * throttleSpeedup)
+		if this.delay < throttleStep {
+			this.delay = 0
+		}
+	}
+	if minimum := minimumDelay(); this.delay < minimum {
+		this.delay = minimum
+	}
+	if this.delay != previous {
+		slog.Info("Throttling", "host", this.host, "delay", this.delay)
+	}
+}
+
+// Hands the host's turn to the next fetch waiting for it, no sooner than the
+// delay after this request finished.
+func (this *hostPoliteness) release() {
+	if this.turn == nil {
+		return
+	}
+	this.lock.Lock()
+	if earliest := time.Now().Add(minimumDelay()); this.nextRequest.Before(earliest) {
+		this.nextRequest = earliest
+	}
+	this.lock.Unlock()
+	<-this.turn
+}
+
+// Backs off the host, twice as long as last time if it is still blocking us.
+func (this *hostPoliteness) blocked() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.backoff == 0 {
+		this.backoff = time.Duration(viper.GetInt("Network.BlockedBackoff")) * time.Second
+	} else {
+		this.backoff *= 2
+	}
+	this.blockedUntil = time.Now().Add(this.backoff)
+	slog.Warn("Backing off", "host", this.host, "wait", this.backoff)
+}
+
+func (this *hostPoliteness) unblocked() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.backoff = 0
+}
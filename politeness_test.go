@@ -0,0 +1,58 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestPolitenessTurns(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    int
+		delay    float64
+		adaptive bool
+		turns    int
+	}{
+		{name: "no cap", turns: 0},
+		{name: "cap", limit: 4, turns: 4},
+		{name: "delay alone", delay: 0.5, turns: 1},
+		{name: "cap with a delay", limit: 3, delay: 0.5, turns: 3},
+		{name: "adaptive alone", adaptive: true, turns: 1},
+		{name: "adaptive with a cap", limit: 2, adaptive: true, turns: 2},
+	}
+	defer func() {
+		viper.Set("Network.MaxPerHost", 0)
+		viper.Set("Network.DelayPerHost", 0)
+		viper.Set("Network.AdaptiveThrottle", false)
+	}()
+	for i, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			viper.Set("Network.MaxPerHost", nextTest.limit)
+			viper.Set("Network.DelayPerHost", nextTest.delay)
+			viper.Set("Network.AdaptiveThrottle", nextTest.adaptive)
+			host := politenessFor(fmt.Sprintf("turns-%d.test", i))
+			if turns := cap(host.turn); turns != nextTest.turns {
+				t.Errorf("turns = %d, want %d", turns, nextTest.turns)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+// The content hashes of the assets an earlier crawl output, for leaving out
+// pages that haven't changed since.
+type previousCrawl struct {
+	hashes map[string]string
+}
+
+var previous *previousCrawl
+
+// Reads assets as they were output, whether one per line or run together.
+// Only their address and hash matter.
+func loadPreviousCrawl(path string) (*previousCrawl, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	loaded := &previousCrawl{
+		hashes: make(map[string]string),
+	}
+	decoder := json.NewDecoder(file)
+	for {
+		var nextAsset struct {
+			Address string `json:"address"`
+			Hash    string `json:"hash"`
+		}
+		err := decoder.Decode(&nextAsset)
+		if errors.Is(err, io.EOF) {
+			return loaded, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if nextAsset.Address != "" && nextAsset.Hash != "" {
+			loaded.hashes[normalizeAddress(nextAsset.Address)] = nextAsset.Hash
+		}
+	}
+}
+
+func (this *previousCrawl) unchanged(where string, hash string) bool {
+	return hash != "" && this.hashes[normalizeAddress(where)] == hash
+}
+
+func initPreviousCrawl() {
+	path := viper.GetString("Output.Previous")
+	if path == "" {
+		return
+	}
+	loaded, err := loadPreviousCrawl(path)
+	if err != nil {
+		panic(fmt.Sprintf("Cannot read previous crawl %s: %s", path, err.Error()))
+	}
+	previous = loaded
+}
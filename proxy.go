@@ -0,0 +1,93 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+var proxyPorts = map[string]string{
+	"http":   "80",
+	"https":  "443",
+	"socks5": "1080",
+}
+
+// The host:port of every proxy requests were sent through, which are
+// connected to even when private addresses are blocked.
+var proxyHosts = &sync.Map{}
+
+// Network.Proxy goes for every request, otherwise HTTP_PROXY, HTTPS_PROXY
+// and NO_PROXY decide. Hosts crawled through Tor never use a proxy.
+func proxyFor(request *http.Request) (*url.URL, error) {
+	if throughTor(request.URL.Hostname()) {
+		return nil, nil
+	}
+	var proxy *url.URL
+	if configured := viper.GetString("Network.Proxy"); configured != "" {
+		parsed, err := url.Parse(configured)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %s: %w", configured, err)
+		}
+		proxy = parsed
+	} else {
+		fromEnvironment, err := http.ProxyFromEnvironment(request)
+		if err != nil || fromEnvironment == nil {
+			return nil, err
+		}
+		proxy = fromEnvironment
+	}
+	scheme := strings.ToLower(proxy.Scheme)
+	port, ok := proxyPorts[scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported proxy scheme %s, expected http, https or socks5", proxy.Scheme)
+	}
+	if proxy.Port() != "" {
+		port = proxy.Port()
+	}
+	// The proxy connects to the host itself, out of dialContext's reach, so
+	// the host is checked before the request is handed to it.
+	if blockPrivate() {
+		err := publicHost(request.Context(), request.URL.Hostname())
+		if err != nil {
+			return nil, err
+		}
+	}
+	proxyHosts.Store(net.JoinHostPort(strings.ToLower(proxy.Hostname()), port), true)
+	return proxy, nil
+}
+
+func proxied(request *http.Request) bool {
+	proxy, err := proxyFor(request)
+	return err == nil && proxy != nil
+}
+
+func isProxy(address string) bool {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	_, ok := proxyHosts.Load(net.JoinHostPort(strings.ToLower(host), port))
+	return ok
+}
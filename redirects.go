@@ -0,0 +1,271 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lunar-parklife/pagecrawl/pkg/crawler"
+	"github.com/spf13/viper"
+	"golang.org/x/net/html"
+)
+
+const (
+	redirectRefresh  = "meta-refresh"
+	refreshURLPrefix = "url="
+)
+
+var (
+	errRedirectLoop     = errors.New("redirect loop")
+	errTooManyRedirects = errors.New("too many redirects")
+)
+
+type redirectHop struct {
+	Address  string `json:"address"`
+	Status   int    `json:"status"`
+	Location string `json:"location"`
+	Kind     string `json:"kind,omitempty"`
+}
+
+func maxRedirects() int {
+	return viper.GetInt("Network.MaxRedirects")
+}
+
+// Redirects to another host end the fetch at the redirect itself when they
+// aren't allowed, so its asset shows where it would have gone.
+func checkRedirect(request *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects() {
+		return errTooManyRedirects
+	}
+	if !viper.GetBool("Network.CrossHostRedirects") && !strings.EqualFold(request.URL.Hostname(), via[0].URL.Hostname()) {
+		slog.Info("Not following redirect, it leaves the host", "url", via[len(via)-1].URL.String(), "location", request.URL.String())
+		return http.ErrUseLastResponse
+	}
+	for _, nextRequest := range via {
+		if nextRequest.URL.String() == request.URL.String() {
+			return errRedirectLoop
+		}
+	}
+	return nil
+}
+
+// Walks from the last response back to the first request, since every
+// redirected request remembers the response that caused it.
+func redirectChain(response *http.Response) []redirectHop {
+	chain := make([]redirectHop, 0)
+	if response == nil {
+		return chain
+	}
+	next := response
+	if next.StatusCode < 300 || next.StatusCode >= 400 {
+		next = next.Request.Response
+	}
+	for ; next != nil; next = next.Request.Response {
+		chain = append([]redirectHop{{
+			Address:  next.Request.URL.String(),
+			Status:   next.StatusCode,
+			Location: next.Header.Get("Location"),
+		}}, chain...)
+	}
+	return chain
+}
+
+// Outputs an asset of its own for every hop the fetch was redirected
+// through, each referred to by the hop before it.
+func outputRedirects(target *crawlTarget, accessed time.Time, chain []redirectHop) {
+	referrer := target.Referrer
+	for _, nextHop := range chain {
+		location := nextHop.Location
+		if base, err := url.Parse(nextHop.Address); err == nil {
+			if resolved, err := crawler.ResolveReference(base, location); err == nil {
+				location = resolved.String()
+			}
+		}
+		output(&asset{
+			Job:          target.Job,
+			Run:          target.Run,
+			Accessed:     accessed,
+			Address:      nextHop.Address,
+			Depth:        target.Depth,
+			Referrer:     referrer,
+			References:   []string{location},
+			Redirects:    []redirectHop{nextHop},
+			FinalAddress: location,
+		})
+		referrer = nextHop.Address
+	}
+}
+
+// Looks for a <meta http-equiv="refresh"> that sends the page elsewhere, as
+// opposed to one that merely reloads it.
+func metaRefresh(base *url.URL, status int, doc *html.Node) (redirectHop, bool) {
+	if doc.Type == html.ElementNode && doc.Data == "meta" && strings.EqualFold(crawler.Attribute(doc, "http-equiv"), "refresh") {
+		_, target, _ := strings.Cut(crawler.Attribute(doc, "content"), ";")
+		target = strings.TrimSpace(target)
+		if len(target) >= len(refreshURLPrefix) && strings.EqualFold(target[:len(refreshURLPrefix)], refreshURLPrefix) {
+			target = target[len(refreshURLPrefix):]
+		}
+		target = strings.Trim(strings.TrimSpace(target), `'"`)
+		if target == "" {
+			return redirectHop{}, false
+		}
+		resolved, err := crawler.ResolveReference(base, target)
+		if err != nil {
+			return redirectHop{}, false
+		}
+		return redirectHop{
+			Address:  base.String(),
+			Status:   status,
+			Location: resolved.String(),
+			Kind:     redirectRefresh,
+		}, true
+	}
+	for next := doc.FirstChild; next != nil; next = next.NextSibling {
+		if hop, ok := metaRefresh(base, status, next); ok {
+			return hop, true
+		}
+	}
+	return redirectHop{}, false
+}
+
+type redirectLoop struct {
+	Address string        `json:"address"`
+	Chain   []redirectHop `json:"chain"`
+}
+
+type redirectingLink struct {
+	Page        string `json:"page"`
+	Link        string `json:"link"`
+	Destination string `json:"destination"`
+}
+
+type redirectSummary struct {
+	LongChains       map[string][]redirectHop `json:"longChains"`
+	Loops            []redirectLoop           `json:"loops"`
+	SchemeBounces    map[string][]redirectHop `json:"schemeBounces"`
+	RedirectingLinks []redirectingLink        `json:"redirectingLinks"`
+}
+
+type redirectReport struct {
+	chains map[string][]redirectHop
+	finals map[string]string
+	links  map[string][]string
+	loops  []redirectLoop
+}
+
+var redirects *redirectReport
+
+func newRedirectReport() *redirectReport {
+	return &redirectReport{
+		chains: make(map[string][]redirectHop),
+		finals: make(map[string]string),
+		links:  make(map[string][]string),
+		loops:  make([]redirectLoop, 0),
+	}
+}
+
+func (this *redirectReport) name() string {
+	return "redirects"
+}
+
+func (this *redirectReport) observe(asset *asset) {
+	if len(asset.Redirects) > 0 {
+		this.chains[asset.Address] = asset.Redirects
+		final := asset.FinalAddress
+		for _, nextHop := range asset.Redirects {
+			this.finals[nextHop.Address] = final
+		}
+	}
+	base, err := url.Parse(asset.Address)
+	if err != nil {
+		return
+	}
+	internal := make([]string, 0)
+	for _, nextReference := range asset.References {
+		kind, resolved := classifyLink(base, nextReference)
+		if kind == linkInternal {
+			resolved.Fragment = ""
+			internal = append(internal, resolved.String())
+		}
+	}
+	this.links[asset.Address] = internal
+}
+
+// Fetches that gave up on a redirect never produce an asset, so they are
+// reported separately.
+func (this *redirectReport) failed(where string, response *http.Response, err error) {
+	if !errors.Is(err, errRedirectLoop) {
+		return
+	}
+	reportsLock.Lock()
+	defer reportsLock.Unlock()
+	this.loops = append(this.loops, redirectLoop{
+		Address: where,
+		Chain:   redirectChain(response),
+	})
+}
+
+func (this *redirectReport) summary() any {
+	maxHops := viper.GetInt("Audit.MaxRedirectHops")
+	summary := &redirectSummary{
+		LongChains:       make(map[string][]redirectHop),
+		Loops:            this.loops,
+		SchemeBounces:    make(map[string][]redirectHop),
+		RedirectingLinks: make([]redirectingLink, 0),
+	}
+	for address, chain := range this.chains {
+		if len(chain) > maxHops {
+			summary.LongChains[address] = chain
+		}
+		secured := false
+		for _, nextHop := range chain {
+			scheme := strings.ToLower(strings.SplitN(nextHop.Location, ":", 2)[0])
+			if strings.HasPrefix(nextHop.Address, "https:") {
+				secured = true
+			}
+			if secured && scheme == "http" {
+				summary.SchemeBounces[address] = chain
+				break
+			}
+		}
+	}
+	for page, links := range this.links {
+		for _, nextLink := range links {
+			if destination, ok := this.finals[nextLink]; ok {
+				summary.RedirectingLinks = append(summary.RedirectingLinks, redirectingLink{
+					Page:        page,
+					Link:        nextLink,
+					Destination: destination,
+				})
+			}
+		}
+	}
+	sort.Slice(summary.RedirectingLinks, func(i, j int) bool {
+		if summary.RedirectingLinks[i].Page == summary.RedirectingLinks[j].Page {
+			return summary.RedirectingLinks[i].Link < summary.RedirectingLinks[j].Link
+		}
+		return summary.RedirectingLinks[i].Page < summary.RedirectingLinks[j].Page
+	})
+	return summary
+}
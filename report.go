@@ -0,0 +1,79 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// A report watches every asset the crawl produces and summarises them once
+// the crawl is over.
+type report interface {
+	name() string
+	observe(asset *asset)
+	summary() any
+}
+
+var (
+	reports     = make([]report, 0)
+	reportsLock = &sync.Mutex{}
+)
+
+func observe(asset *asset) {
+	reportsLock.Lock()
+	defer reportsLock.Unlock()
+	for _, nextReport := range reports {
+		nextReport.observe(asset)
+	}
+}
+
+func writeReports() {
+	reportPath := viper.GetString("Report.Path")
+	for _, nextReport := range reports {
+		rawSummary, err := json.MarshalIndent(nextReport.summary(), "", "  ")
+		if err != nil {
+			slog.Error("Cannot encode report", "report", nextReport.name(), failed(err))
+			continue
+		}
+		slog.Info("Report", "report", nextReport.name(), "summary", json.RawMessage(rawSummary))
+		if reportPath == "" {
+			continue
+		}
+		reportTarget := fmt.Sprintf("%s/%s.json", reportPath, nextReport.name())
+		err = os.WriteFile(reportTarget, rawSummary, 0644)
+		if err != nil {
+			slog.Error("Cannot write report", "path", reportTarget, failed(err))
+		}
+	}
+}
+
+func hostOf(address string) string {
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsed.Hostname())
+}
@@ -0,0 +1,292 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Only the latest attempts of an address are kept.
+const maxRetryAttempts = 20
+
+// The longest retries double up to without a Retry.MaxDelay.
+const retryCeiling = 24 * time.Hour
+
+const (
+	failureDNS        = "dns"
+	failureTimeout    = "timeout"
+	failureRefused    = "connection-refused"
+	failureReset      = "connection-reset"
+	failureTLS        = "tls"
+	failurePrivate    = "private-address"
+	failureRedirect   = "redirect"
+	failureRateLimit  = "rate-limited"
+	failureServer     = "server-error"
+	failureUnexpected = "other"
+)
+
+type retryAttempt struct {
+	At    time.Time `json:"at"`
+	Job   string    `json:"job"`
+	Run   string    `json:"run"`
+	Class string    `json:"class"`
+	Error string    `json:"error"`
+}
+
+// Requests other than plain GETs are kept as well, so they can be made the
+// same way again.
+type retryEntry struct {
+	Class    string            `json:"class"`
+	Method   string            `json:"method,omitempty"`
+	Body     []byte            `json:"body,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Attempts []retryAttempt    `json:"attempts"`
+}
+
+// Remembers the addresses whose last fetch failed, so `pagecrawl retry`
+// can fetch just those again. Addresses leave the store once they succeed.
+type retryStore struct {
+	lock    *sync.Mutex
+	path    string
+	entries map[string]*retryEntry
+}
+
+var retries *retryStore
+
+func failureClass(status int, err error) string {
+	if err == nil {
+		if status == http.StatusTooManyRequests {
+			return failureRateLimit
+		}
+		return failureServer
+	}
+	var dnsError *net.DNSError
+	var netError net.Error
+	var authorityError x509.UnknownAuthorityError
+	var hostnameError x509.HostnameError
+	var invalidError x509.CertificateInvalidError
+	switch {
+	case errors.Is(err, errPrivateAddress):
+		return failurePrivate
+	case errors.Is(err, errRedirectLoop), errors.Is(err, errTooManyRedirects):
+		return failureRedirect
+	case errors.As(err, &dnsError):
+		return failureDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return failureRefused
+	case errors.Is(err, syscall.ECONNRESET):
+		return failureReset
+	case errors.As(err, &authorityError), errors.As(err, &hostnameError), errors.As(err, &invalidError):
+		return failureTLS
+	case errors.As(err, &netError) && netError.Timeout():
+		return failureTimeout
+	}
+	return failureUnexpected
+}
+
+// Tells whether a response counts as a failure worth retrying.
+func failedStatus(status int) bool {
+	return status >= 500 || status == http.StatusTooManyRequests
+}
+
+// Failures worth trying again in the same crawl, as opposed to ones that
+// will keep failing.
+func transientFailure(class string) bool {
+	switch class {
+	case failureTimeout, failureRefused, failureReset, failureRateLimit, failureServer:
+		return true
+	}
+	return false
+}
+
+func attemptFailure(response *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return response.Status
+}
+
+// Retry-After is either seconds or a date.
+func retryAfter(response *http.Response) (time.Duration, bool) {
+	value := strings.TrimSpace(response.Header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at), true
+	}
+	return 0, false
+}
+
+// Tells whether a failed attempt should be made again, and how long to wait
+// before that. Waits double with every attempt, spread out by the jitter,
+// unless the host says when to come back.
+func retryDelay(attempt int, response *http.Response, err error) (time.Duration, bool) {
+	status := 0
+	if err == nil {
+		if !failedStatus(response.StatusCode) {
+			return 0, false
+		}
+		status = response.StatusCode
+	}
+	if attempt > viper.GetInt("Retry.Attempts") || !transientFailure(failureClass(status, err)) {
+		return 0, false
+	}
+	maximum := timeout("Retry.MaxDelay")
+	// Doubling stops once the wait is long enough, so it can't overflow
+	// however many attempts there are.
+	ceiling := maximum
+	if ceiling <= 0 {
+		ceiling = retryCeiling
+	}
+	pause := timeout("Retry.Delay")
+	for doubled := 1; doubled < attempt && pause < ceiling; doubled++ {
+		pause *= 2
+	}
+	if jitter := viper.GetFloat64("Retry.Jitter"); jitter > 0 {
+		pause = time.Duration(float64(pause) * (1 + jitter*(2*rand.Float64()-1)))
+	}
+	if response != nil {
+		if after, ok := retryAfter(response); ok {
+			pause = after
+		}
+	}
+	if pause < 0 {
+		pause = 0
+	}
+	if maximum > 0 && pause > maximum {
+		pause = maximum
+	}
+	return pause, true
+}
+
+func loadRetryStore(path string) (*retryStore, error) {
+	loaded := &retryStore{
+		lock:    &sync.Mutex{},
+		path:    path,
+		entries: make(map[string]*retryEntry),
+	}
+	rawStore, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return loaded, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(rawStore, &loaded.entries)
+	if err != nil {
+		return nil, err
+	}
+	return loaded, nil
+}
+
+func (this *retryStore) save() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	rawStore, err := json.MarshalIndent(this.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(this.path, rawStore, 0644)
+}
+
+func (this *retryStore) failed(target *crawlTarget, status int, err error) {
+	where := target.Address
+	attempt := retryAttempt{
+		At:    time.Now().UTC(),
+		Job:   jobName,
+		Run:   runID,
+		Class: failureClass(status, err),
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	} else {
+		attempt.Error = fmt.Sprintf("status %d", status)
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	entry, ok := this.entries[where]
+	if !ok {
+		entry = &retryEntry{
+			Attempts: make([]retryAttempt, 0, 1),
+		}
+		this.entries[where] = entry
+	}
+	entry.Class = attempt.Class
+	entry.Method, entry.Body, entry.Headers = target.Method, target.Body, target.Headers
+	entry.Attempts = append(entry.Attempts, attempt)
+	if len(entry.Attempts) > maxRetryAttempts {
+		entry.Attempts = entry.Attempts[len(entry.Attempts)-maxRetryAttempts:]
+	}
+}
+
+func (this *retryStore) succeeded(where string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.entries, where)
+}
+
+// The failed requests as they were made, in the order of their addresses.
+func (this *retryStore) targets() []*crawlTarget {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	buf := make([]*crawlTarget, 0, len(this.entries))
+	for nextAddress, nextEntry := range this.entries {
+		buf = append(buf, &crawlTarget{
+			Address: nextAddress,
+			Method:  nextEntry.Method,
+			Body:    nextEntry.Body,
+			Headers: nextEntry.Headers,
+		})
+	}
+	sort.Slice(buf, func(i, j int) bool {
+		return buf[i].Address < buf[j].Address
+	})
+	return buf
+}
+
+func initRetryStore() {
+	retryPath := viper.GetString("Retry.Path")
+	if retryPath == "" {
+		return
+	}
+	loaded, err := loadRetryStore(retryPath)
+	if err != nil {
+		panic(fmt.Sprintf("Cannot load retry store %s: %s", retryPath, err.Error()))
+	}
+	retries = loaded
+}
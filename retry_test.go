@@ -0,0 +1,145 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func statusResponse(status int, retryAfter string) *http.Response {
+	response := &http.Response{StatusCode: status, Header: make(http.Header)}
+	if retryAfter != "" {
+		response.Header.Set("Retry-After", retryAfter)
+	}
+	return response
+}
+
+func TestRetryDelay(t *testing.T) {
+	refused := fmt.Errorf("dial: %w", syscall.ECONNREFUSED)
+	tests := []struct {
+		name     string
+		attempt  int
+		maximum  float64
+		response *http.Response
+		err      error
+		pause    time.Duration
+		again    bool
+	}{
+		{name: "success", attempt: 1, response: statusResponse(200, ""), again: false},
+		{name: "not found isn't retried", attempt: 1, response: statusResponse(404, ""), again: false},
+		{name: "first retry", attempt: 1, maximum: 60, response: statusResponse(503, ""), pause: time.Second, again: true},
+		{name: "doubles", attempt: 3, maximum: 60, err: refused, pause: 4 * time.Second, again: true},
+		{name: "capped", attempt: 8, maximum: 60, response: statusResponse(500, ""), pause: time.Minute, again: true},
+		{name: "many attempts don't overflow", attempt: 90, maximum: 0, err: refused, pause: 131072 * time.Second, again: true},
+		{name: "many attempts stay capped", attempt: 90, maximum: 60, err: refused, pause: time.Minute, again: true},
+		{name: "retry after seconds", attempt: 1, maximum: 60, response: statusResponse(429, "7"), pause: 7 * time.Second, again: true},
+		{name: "retry after capped", attempt: 1, maximum: 60, response: statusResponse(429, "3600"), pause: time.Minute, again: true},
+		{name: "dns errors aren't retried", attempt: 1, err: &net.DNSError{Err: "no such host", Name: "nowhere.test"}, again: false},
+	}
+	viper.Set("Retry.Attempts", 100)
+	viper.Set("Retry.Delay", 1)
+	viper.Set("Retry.Jitter", 0)
+	defer func() {
+		viper.Set("Retry.Attempts", 2)
+		viper.Set("Retry.Delay", 1)
+		viper.Set("Retry.Jitter", 0.5)
+		viper.Set("Retry.MaxDelay", 60)
+	}()
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			viper.Set("Retry.MaxDelay", nextTest.maximum)
+			pause, again := retryDelay(nextTest.attempt, nextTest.response, nextTest.err)
+			if pause != nextTest.pause || again != nextTest.again {
+				t.Errorf("retryDelay(%d) = %s, %v, want %s, %v", nextTest.attempt, pause, again, nextTest.pause, nextTest.again)
+			}
+		})
+	}
+}
+
+func TestRetryDelayAttempts(t *testing.T) {
+	viper.Set("Retry.Attempts", 2)
+	viper.Set("Retry.Jitter", 0)
+	defer viper.Set("Retry.Jitter", 0.5)
+	for attempt, want := range []bool{true, true, false} {
+		if _, again := retryDelay(attempt+1, statusResponse(502, ""), nil); again != want {
+			t.Errorf("retryDelay(%d) tries again = %v, want %v", attempt+1, again, want)
+		}
+	}
+}
+
+func TestFailureClass(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		err    error
+		class  string
+	}{
+		{name: "rate limited", status: 429, class: failureRateLimit},
+		{name: "server error", status: 503, class: failureServer},
+		{name: "private", err: fmt.Errorf("refusing: %w", errPrivateAddress), class: failurePrivate},
+		{name: "redirect loop", err: errRedirectLoop, class: failureRedirect},
+		{name: "dns", err: &net.DNSError{Err: "no such host"}, class: failureDNS},
+		{name: "refused", err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, class: failureRefused},
+		{name: "reset", err: fmt.Errorf("read: %w", syscall.ECONNRESET), class: failureReset},
+		{name: "timeout", err: context.DeadlineExceeded, class: failureTimeout},
+		{name: "other", err: errors.New("something else"), class: failureUnexpected},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			if class := failureClass(nextTest.status, nextTest.err); class != nextTest.class {
+				t.Errorf("failureClass() = %s, want %s", class, nextTest.class)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		wait   time.Duration
+		within time.Duration
+		ok     bool
+	}{
+		{name: "none", value: "", ok: false},
+		{name: "seconds", value: "120", wait: 2 * time.Minute, ok: true},
+		{name: "date", value: time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), wait: time.Hour, within: 2 * time.Second, ok: true},
+		{name: "garbage", value: "soon", ok: false},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			wait, ok := retryAfter(statusResponse(503, nextTest.value))
+			difference := wait - nextTest.wait
+			if difference < 0 {
+				difference = -difference
+			}
+			if ok != nextTest.ok || difference > nextTest.within {
+				t.Errorf("retryAfter(%q) = %s, %v, want %s, %v", nextTest.value, wait, ok, nextTest.wait, nextTest.ok)
+			}
+		})
+	}
+}
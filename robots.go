@@ -0,0 +1,90 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+const robotsAgent = "pagecrawl"
+
+// Directives that take a value after a colon, which must not be mistaken for
+// a user agent prefix.
+var valuedRobotsDirectives = map[string]bool{
+	"max-image-preview": true,
+	"max-snippet":       true,
+	"max-video-preview": true,
+	"unavailable_after": true,
+}
+
+func parseRobotsDirectives(value string, directives map[string]bool) {
+	if agent, rest, ok := strings.Cut(value, ":"); ok {
+		agent = strings.ToLower(strings.TrimSpace(agent))
+		if !valuedRobotsDirectives[agent] {
+			if agent != robotsAgent {
+				return
+			}
+			value = rest
+		}
+	}
+	for _, nextDirective := range strings.Split(value, ",") {
+		nextDirective = strings.ToLower(strings.TrimSpace(nextDirective))
+		if nextDirective == "" {
+			continue
+		}
+		if nextDirective == "none" {
+			directives["noindex"] = true
+			directives["nofollow"] = true
+			continue
+		}
+		directives[nextDirective] = true
+	}
+}
+
+// Collects the robots directives that apply to us from both the X-Robots-Tag
+// headers and the robots meta tags.
+func robotsDirectives(response *http.Response, doc *html.Node) []string {
+	directives := make(map[string]bool)
+	for _, nextValue := range response.Header.Values("X-Robots-Tag") {
+		parseRobotsDirectives(nextValue, directives)
+	}
+	for _, nextName := range []string{"robots", robotsAgent} {
+		if content := metaContent(doc, nextName); content != "" {
+			parseRobotsDirectives(content, directives)
+		}
+	}
+	buf := make([]string, 0, len(directives))
+	for nextDirective := range directives {
+		buf = append(buf, nextDirective)
+	}
+	sort.Strings(buf)
+	return buf
+}
+
+func hasDirective(directives []string, directive string) bool {
+	for _, nextDirective := range directives {
+		if nextDirective == directive {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,480 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lunar-parklife/pagecrawl/pkg/crawler"
+	"github.com/spf13/viper"
+)
+
+type robotsRule struct {
+	allow      bool
+	pattern    string
+	expression *regexp.Regexp
+}
+
+func (this robotsRule) String() string {
+	if this.allow {
+		return "Allow: " + this.pattern
+	}
+	return "Disallow: " + this.pattern
+}
+
+func (this robotsRule) matches(path string) bool {
+	return this.expression.MatchString(path)
+}
+
+// Patterns are matched the way RFC 9309 describes, where * matches anything
+// and a trailing $ anchors the end of the path.
+func newRobotsRule(allow bool, pattern string) robotsRule {
+	expression := regexp.QuoteMeta(strings.TrimSuffix(pattern, "$"))
+	expression = "^" + strings.ReplaceAll(expression, `\*`, ".*")
+	if strings.HasSuffix(pattern, "$") {
+		expression += "$"
+	}
+	return robotsRule{
+		allow:      allow,
+		pattern:    pattern,
+		expression: regexp.MustCompile(expression),
+	}
+}
+
+type robotsTxt struct {
+	rules    []robotsRule
+	sitemaps []string
+	// Set when robots.txt couldn't be fetched, which means nothing is allowed.
+	unreachable bool
+	status      int
+	failure     error
+}
+
+func parseRobotsTxt(body string) *robotsTxt {
+	parsed := &robotsTxt{}
+	groups := make(map[string][]robotsRule)
+	agents := make([]string, 0)
+	inRules := false
+	for _, nextLine := range strings.Split(body, "\n") {
+		nextLine, _, _ = strings.Cut(nextLine, "#")
+		key, value, ok := strings.Cut(nextLine, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		switch key {
+		case "user-agent":
+			if inRules {
+				agents = make([]string, 0)
+				inRules = false
+			}
+			agents = append(agents, strings.ToLower(value))
+		case "allow", "disallow":
+			inRules = true
+			if value == "" {
+				continue
+			}
+			for _, nextAgent := range agents {
+				groups[nextAgent] = append(groups[nextAgent], newRobotsRule(key == "allow", value))
+			}
+		case "sitemap":
+			parsed.sitemaps = append(parsed.sitemaps, value)
+		}
+	}
+	if rules, ok := groups[robotsAgent]; ok {
+		parsed.rules = rules
+	} else {
+		parsed.rules = groups["*"]
+	}
+	return parsed
+}
+
+// Reports whether the address may be crawled, along with the rule that
+// decided it. The longest matching rule wins and Allow wins ties.
+func (this *robotsTxt) allowed(address *url.URL) (bool, string) {
+	if this.unreachable {
+		return false, "robots.txt unreachable"
+	}
+	path := address.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if address.RawQuery != "" {
+		path += "?" + address.RawQuery
+	}
+	var decided *robotsRule
+	for nextRule := range this.rules {
+		rule := &this.rules[nextRule]
+		if !rule.matches(path) {
+			continue
+		}
+		if decided == nil || len(rule.pattern) > len(decided.pattern) || len(rule.pattern) == len(decided.pattern) && rule.allow {
+			decided = rule
+		}
+	}
+	if decided == nil {
+		return true, ""
+	}
+	return decided.allow, decided.String()
+}
+
+// The fields are guarded by lock, which is never held over the network,
+// while fetching lets only one request for the origin's robots.txt out at a
+// time.
+type originRobots struct {
+	lock     *sync.Mutex
+	fetching *sync.Mutex
+	robots   *robotsTxt
+	fetched  time.Time
+	// Kept so the rules can be cached on disk, empty unless they were
+	// actually fetched.
+	status  int
+	body    string
+	checked bool
+}
+
+// What the robots.txt cache in Robots.Cache holds per origin.
+type cachedRobots struct {
+	Fetched time.Time `json:"fetched"`
+	Status  int       `json:"status"`
+	Body    string    `json:"body,omitempty"`
+}
+
+var (
+	robotsFiles     = make(map[string]*originRobots)
+	robotsFilesLock = &sync.Mutex{}
+)
+
+func robotsFromResponse(status int, body string) *robotsTxt {
+	if status >= 500 {
+		return &robotsTxt{unreachable: true, status: status}
+	}
+	if status >= 400 {
+		return &robotsTxt{}
+	}
+	return parseRobotsTxt(body)
+}
+
+func newOriginRobots() *originRobots {
+	return &originRobots{
+		lock:     &sync.Mutex{},
+		fetching: &sync.Mutex{},
+	}
+}
+
+func (this *originRobots) due() bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.robots == nil {
+		return true
+	}
+	refresh := time.Duration(viper.GetInt("Robots.Refresh")) * time.Second
+	if this.robots.unreachable {
+		refresh = time.Duration(viper.GetFloat64("Robots.Unreachable") * float64(time.Second))
+	}
+	return refresh > 0 && time.Since(this.fetched) > refresh
+}
+
+func (this *originRobots) store(robots *robotsTxt, fetched time.Time, status int, body string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.robots, this.fetched = robots, fetched
+	this.status, this.body = status, body
+}
+
+func (this *originRobots) fetch(origin string) {
+	fetched := time.Now().UTC()
+	response, rawRobots, err := retrieve(origin + "/robots.txt")
+	if errors.Is(err, errPrivateAddress) {
+		// Nothing on the origin will be fetched anyway.
+		this.store(&robotsTxt{}, fetched, 0, "")
+		return
+	}
+	if err != nil {
+		slog.Warn("Cannot fetch robots.txt", "origin", origin, failed(err))
+		this.store(&robotsTxt{unreachable: true, failure: err}, fetched, 0, "")
+		return
+	}
+	robots := robotsFromResponse(response.StatusCode, string(rawRobots))
+	if response.StatusCode >= 500 {
+		this.store(robots, fetched, 0, "")
+		return
+	}
+	this.store(robots, fetched, response.StatusCode, string(rawRobots))
+}
+
+func (this *originRobots) current() *robotsTxt {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.robots
+}
+
+// Tells whether the origin's sitemaps are still to be checked for the
+// robots.txt report, which they are only once.
+func (this *originRobots) check() bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.checked {
+		return false
+	}
+	this.checked = true
+	return true
+}
+
+func originOf(address *url.URL) string {
+	return address.Scheme + "://" + strings.ToLower(address.Host)
+}
+
+// Fetches the robots.txt of the address' origin the first time the origin
+// is seen and hands out the same rules for every other address on it,
+// fetching them again once they are older than Robots.Refresh. An origin
+// whose robots.txt couldn't be fetched is tried again after Robots.Unreachable.
+func robotsFor(address *url.URL) *robotsTxt {
+	origin := originOf(address)
+	robotsFilesLock.Lock()
+	entry, ok := robotsFiles[origin]
+	if !ok {
+		entry = newOriginRobots()
+		robotsFiles[origin] = entry
+	}
+	robotsFilesLock.Unlock()
+	entry.fetching.Lock()
+	if entry.due() {
+		entry.fetch(origin)
+	}
+	entry.fetching.Unlock()
+	robots := entry.current()
+	// Sitemaps are read without holding any lock, so a slow one holds up
+	// nothing but the fetch that happened to read it.
+	if robotsCoverage != nil && entry.check() {
+		robotsCoverage.checkSitemaps(origin, robots)
+	}
+	return robots
+}
+
+// The rules last fetched for the address' origin, without fetching them if
+// there are none yet.
+func knownRobots(address *url.URL) *robotsTxt {
+	robotsFilesLock.Lock()
+	entry, ok := robotsFiles[originOf(address)]
+	robotsFilesLock.Unlock()
+	if !ok {
+		return nil
+	}
+	return entry.current()
+}
+
+// Cached rules older than Robots.Refresh are fetched again when their
+// origin is next crawled.
+func initRobotsCache() {
+	cachePath := viper.GetString("Robots.Cache")
+	if cachePath == "" {
+		return
+	}
+	rawCache, err := os.ReadFile(cachePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		panic(fmt.Sprintf("Cannot read robots.txt cache %s: %s", cachePath, err.Error()))
+	}
+	cached := make(map[string]*cachedRobots)
+	err = json.Unmarshal(rawCache, &cached)
+	if err != nil {
+		panic(fmt.Sprintf("Cannot parse robots.txt cache %s: %s", cachePath, err.Error()))
+	}
+	robotsFilesLock.Lock()
+	defer robotsFilesLock.Unlock()
+	for nextOrigin, nextCached := range cached {
+		entry := newOriginRobots()
+		entry.store(robotsFromResponse(nextCached.Status, nextCached.Body), nextCached.Fetched, nextCached.Status, nextCached.Body)
+		robotsFiles[nextOrigin] = entry
+	}
+}
+
+func saveRobotsCache() error {
+	cachePath := viper.GetString("Robots.Cache")
+	if cachePath == "" {
+		return nil
+	}
+	robotsFilesLock.Lock()
+	cached := make(map[string]*cachedRobots, len(robotsFiles))
+	entries := make(map[string]*originRobots, len(robotsFiles))
+	for nextOrigin, nextEntry := range robotsFiles {
+		entries[nextOrigin] = nextEntry
+	}
+	robotsFilesLock.Unlock()
+	for nextOrigin, nextEntry := range entries {
+		nextEntry.lock.Lock()
+		if nextEntry.status != 0 {
+			cached[nextOrigin] = &cachedRobots{
+				Fetched: nextEntry.fetched,
+				Status:  nextEntry.status,
+				Body:    nextEntry.body,
+			}
+		}
+		nextEntry.lock.Unlock()
+	}
+	rawCache, err := json.MarshalIndent(cached, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(cachePath, rawCache, 0644)
+}
+
+type hostRobotsCoverage struct {
+	skipped    map[string]map[string]bool
+	discovered map[string]map[string]bool
+	sitemap    map[string]map[string]bool
+}
+
+type robotsReport struct {
+	lock  *sync.Mutex
+	hosts map[string]*hostRobotsCoverage
+}
+
+var robotsCoverage *robotsReport
+
+func newRobotsReport() *robotsReport {
+	return &robotsReport{
+		lock:  &sync.Mutex{},
+		hosts: make(map[string]*hostRobotsCoverage),
+	}
+}
+
+func (this *robotsReport) name() string {
+	return "robots"
+}
+
+func (this *robotsReport) record(kind string, address string, rule string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	host := hostOf(address)
+	coverage, ok := this.hosts[host]
+	if !ok {
+		coverage = &hostRobotsCoverage{
+			skipped:    make(map[string]map[string]bool),
+			discovered: make(map[string]map[string]bool),
+			sitemap:    make(map[string]map[string]bool),
+		}
+		this.hosts[host] = coverage
+	}
+	byRule := map[string]map[string]map[string]bool{
+		"skipped":    coverage.skipped,
+		"discovered": coverage.discovered,
+		"sitemap":    coverage.sitemap,
+	}[kind]
+	if byRule[rule] == nil {
+		byRule[rule] = make(map[string]bool)
+	}
+	byRule[rule][address] = true
+}
+
+func (this *robotsReport) skipped(where string, rule string) {
+	this.record("skipped", where, rule)
+}
+
+// Only sitemap addresses on the origin that lists the sitemap are checked,
+// since the robots.txt of other origins may still be being fetched.
+func (this *robotsReport) checkSitemaps(origin string, robots *robotsTxt) {
+	for _, nextSitemap := range robots.sitemaps {
+		for _, nextAddress := range readSitemap(nextSitemap) {
+			parsed, err := url.Parse(nextAddress)
+			if err != nil || !strings.EqualFold(parsed.Scheme+"://"+parsed.Host, origin) {
+				continue
+			}
+			if ok, rule := robots.allowed(parsed); !ok {
+				this.record("sitemap", nextAddress, rule)
+			}
+		}
+	}
+}
+
+// Only references on the page's own host are checked, against the rules
+// fetched for the page, so the report doesn't fetch the robots.txt of every
+// site the crawl links out to, or anything at all while reports are locked.
+func (this *robotsReport) observe(asset *asset) {
+	page, err := url.Parse(asset.Address)
+	if err != nil {
+		return
+	}
+	base := page
+	if asset.FinalAddress != "" {
+		if final, err := url.Parse(asset.FinalAddress); err == nil {
+			base = final
+		}
+	}
+	robots := knownRobots(page)
+	if robots == nil {
+		return
+	}
+	for _, nextReference := range asset.References {
+		resolved, err := crawler.ResolveReference(base, nextReference)
+		if err != nil || !strings.EqualFold(resolved.Host, page.Host) || resolved.Scheme != page.Scheme {
+			continue
+		}
+		resolved.Fragment = ""
+		if ok, rule := robots.allowed(resolved); !ok {
+			this.record("discovered", resolved.String(), rule)
+		}
+	}
+}
+
+func sortedByRule(byRule map[string]map[string]bool) map[string][]string {
+	buf := make(map[string][]string, len(byRule))
+	for nextRule, nextAddresses := range byRule {
+		addresses := make([]string, 0, len(nextAddresses))
+		for nextAddress := range nextAddresses {
+			addresses = append(addresses, nextAddress)
+		}
+		sort.Strings(addresses)
+		buf[nextRule] = addresses
+	}
+	return buf
+}
+
+func (this *robotsReport) summary() any {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	type hostSummary struct {
+		Skipped    map[string][]string `json:"skipped"`
+		Discovered map[string][]string `json:"discovered"`
+		Sitemap    map[string][]string `json:"sitemap"`
+	}
+	buf := make(map[string]hostSummary, len(this.hosts))
+	for nextHost, nextCoverage := range this.hosts {
+		buf[nextHost] = hostSummary{
+			Skipped:    sortedByRule(nextCoverage.skipped),
+			Discovered: sortedByRule(nextCoverage.discovered),
+			Sitemap:    sortedByRule(nextCoverage.sitemap),
+		}
+	}
+	return buf
+}
@@ -0,0 +1,161 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestRobotsAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		address string
+		allowed bool
+		rule    string
+	}{
+		{
+			name:    "nothing matches",
+			body:    "User-agent: *\nDisallow: /private\n",
+			address: "https://example.com/public",
+			allowed: true,
+		},
+		{
+			name:    "disallowed prefix",
+			body:    "User-agent: *\nDisallow: /private\n",
+			address: "https://example.com/private/page",
+			allowed: false,
+			rule:    "Disallow: /private",
+		},
+		{
+			name:    "longest rule wins",
+			body:    "User-agent: *\nDisallow: /shop\nAllow: /shop/catalog\n",
+			address: "https://example.com/shop/catalog/1",
+			allowed: true,
+			rule:    "Allow: /shop/catalog",
+		},
+		{
+			name:    "longer disallow beats shorter allow",
+			body:    "User-agent: *\nAllow: /shop\nDisallow: /shop/cart\n",
+			address: "https://example.com/shop/cart",
+			allowed: false,
+			rule:    "Disallow: /shop/cart",
+		},
+		{
+			name:    "allow wins ties",
+			body:    "User-agent: *\nDisallow: /page\nAllow: /page\n",
+			address: "https://example.com/page",
+			allowed: true,
+			rule:    "Allow: /page",
+		},
+		{
+			name:    "wildcard",
+			body:    "User-agent: *\nDisallow: /*.pdf\n",
+			address: "https://example.com/files/report.pdf",
+			allowed: false,
+			rule:    "Disallow: /*.pdf",
+		},
+		{
+			name:    "end anchor",
+			body:    "User-agent: *\nDisallow: /*.pdf$\n",
+			address: "https://example.com/files/report.pdf?download=1",
+			allowed: true,
+		},
+		{
+			name:    "query is part of the path",
+			body:    "User-agent: *\nDisallow: /search?q=\n",
+			address: "https://example.com/search?q=crawler",
+			allowed: false,
+			rule:    "Disallow: /search?q=",
+		},
+		{
+			name:    "own group beats the wildcard group",
+			body:    "User-agent: *\nDisallow: /\n\nUser-agent: PageCrawl\nDisallow: /admin\n",
+			address: "https://example.com/page",
+			allowed: true,
+		},
+		{
+			name:    "agents sharing a group",
+			body:    "User-agent: other\nUser-agent: pagecrawl\nDisallow: /shared\n",
+			address: "https://example.com/shared",
+			allowed: false,
+			rule:    "Disallow: /shared",
+		},
+		{
+			name:    "empty disallow allows everything",
+			body:    "User-agent: *\nDisallow:\n",
+			address: "https://example.com/anything",
+			allowed: true,
+		},
+		{
+			name:    "comments are ignored",
+			body:    "# keep out\nUser-agent: * # everyone\nDisallow: /tmp # scratch space\n",
+			address: "https://example.com/tmp/file",
+			allowed: false,
+			rule:    "Disallow: /tmp",
+		},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			address, err := url.Parse(nextTest.address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			allowed, rule := parseRobotsTxt(nextTest.body).allowed(address)
+			if allowed != nextTest.allowed || rule != nextTest.rule {
+				t.Errorf("allowed(%s) = %v, %q, want %v, %q", nextTest.address, allowed, rule, nextTest.allowed, nextTest.rule)
+			}
+		})
+	}
+}
+
+func TestRobotsFromResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		allowed bool
+	}{
+		{name: "rules apply", status: 200, body: "User-agent: *\nDisallow: /\n", allowed: false},
+		{name: "missing allows everything", status: 404, body: "User-agent: *\nDisallow: /\n", allowed: true},
+		{name: "server error allows nothing", status: 503, allowed: false},
+	}
+	address := &url.URL{Scheme: "https", Host: "example.com", Path: "/page"}
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			allowed, _ := robotsFromResponse(nextTest.status, nextTest.body).allowed(address)
+			if allowed != nextTest.allowed {
+				t.Errorf("allowed = %v, want %v", allowed, nextTest.allowed)
+			}
+		})
+	}
+}
+
+func TestParseRobotsTxtSitemaps(t *testing.T) {
+	parsed := parseRobotsTxt("Sitemap: https://example.com/a.xml\nUser-agent: *\nDisallow: /x\nSitemap: https://example.com/b.xml\n")
+	want := []string{"https://example.com/a.xml", "https://example.com/b.xml"}
+	if len(parsed.sitemaps) != len(want) {
+		t.Fatalf("sitemaps = %v, want %v", parsed.sitemaps, want)
+	}
+	for i, nextSitemap := range want {
+		if parsed.sitemaps[i] != nextSitemap {
+			t.Errorf("sitemaps[%d] = %s, want %s", i, parsed.sitemaps[i], nextSitemap)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/spf13/viper"
+)
+
+// Writes assets as objects into an S3 bucket, or one compatible with it
+// like MinIO's, the assets written between flushes to an object as JSON
+// lines. Objects are named by S3.Key under the prefix given along with
+// the bucket.
+type s3Output struct {
+	client  *minio.Client
+	bucket  string
+	prefix  string
+	key     string
+	pending *bytes.Buffer
+	count   int
+	first   string
+	objects int
+	opened  time.Time
+}
+
+func s3Credentials() *credentials.Credentials {
+	accessKey := os.ExpandEnv(viper.GetString("S3.AccessKey"))
+	if accessKey != "" {
+		return credentials.NewStaticV4(accessKey, os.ExpandEnv(viper.GetString("S3.SecretKey")), os.ExpandEnv(viper.GetString("S3.SessionToken")))
+	}
+	// The same places AWS's own tools look.
+	return credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		&credentials.FileAWSCredentials{},
+		&credentials.IAM{},
+	})
+}
+
+// Takes the bucket and the prefix of the objects as bucket/prefix.
+func newS3Output(target string) (sink, error) {
+	bucket, prefix, _ := strings.Cut(target, "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("s3 output %s has no bucket", target)
+	}
+	client, err := minio.New(viper.GetString("S3.Endpoint"), &minio.Options{
+		Creds:  s3Credentials(),
+		Secure: !viper.GetBool("S3.Insecure"),
+		Region: viper.GetString("S3.Region"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3Output{
+		client:  client,
+		bucket:  bucket,
+		prefix:  strings.Trim(prefix, "/"),
+		key:     viper.GetString("S3.Key"),
+		pending: &bytes.Buffer{},
+	}, nil
+}
+
+// Fills in the key template for the next object, whose first asset is for
+// the address.
+func (this *s3Output) objectKey(address string) string {
+	host := hostOf(address)
+	if host == "" {
+		host = "unknown"
+	}
+	key := strings.NewReplacer(
+		"{job}", jobName,
+		"{run}", runID,
+		"{host}", host,
+		"{date}", this.opened.Format("2006-01-02"),
+		"{hour}", this.opened.Format("15"),
+		"{time}", this.opened.Format("20060102T150405"),
+		"{hash}", contentHash([]byte(address))[:16],
+		"{seq}", fmt.Sprintf("%06d", this.objects),
+	).Replace(this.key)
+	if this.prefix == "" {
+		return key
+	}
+	return this.prefix + "/" + strings.TrimLeft(key, "/")
+}
+
+func (this *s3Output) Open() error {
+	return nil
+}
+
+// Uploads the assets written since the last flush as an object. A batch
+// that can't be uploaded is kept, along with its key, and goes with the
+// next flush.
+func (this *s3Output) Flush() error {
+	if this.count == 0 {
+		return nil
+	}
+	key := this.objectKey(this.first)
+	body := this.pending.Bytes()
+	_, err := this.client.PutObject(context.Background(), this.bucket, key, bytes.NewReader(body), int64(len(body)), minio.PutObjectOptions{
+		ContentType: "application/x-ndjson",
+	})
+	if err != nil {
+		return fmt.Errorf("cannot upload %s to bucket %s: %s", key, this.bucket, err.Error())
+	}
+	this.objects++
+	this.pending.Reset()
+	this.count = 0
+	return nil
+}
+
+func (this *s3Output) Write(asset *asset, rawAsset []byte) error {
+	if this.count == 0 {
+		this.first = asset.Address
+		this.opened = time.Now().UTC()
+	}
+	this.pending.Write(rawAsset)
+	if len(rawAsset) > 0 && rawAsset[len(rawAsset)-1] != '\n' {
+		this.pending.WriteByte('\n')
+	}
+	this.count++
+	return nil
+}
+
+func (this *s3Output) Close() error {
+	return nil
+}
+
+func init() {
+	registerSink("s3", "S3", newS3Output)
+}
@@ -0,0 +1,125 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	gapNoCSP             = "missing Content-Security-Policy"
+	gapNoHSTS            = "missing Strict-Transport-Security"
+	gapNoTLS             = "served without TLS"
+	gapNoSniff           = "missing X-Content-Type-Options: nosniff"
+	gapNoFrameOptions    = "missing X-Frame-Options"
+	gapInsecureCookies   = "cookies without Secure or HttpOnly"
+	frameAncestorsPolicy = "frame-ancestors"
+)
+
+type securityAudit struct {
+	ContentSecurityPolicy   string   `json:"contentSecurityPolicy,omitempty"`
+	StrictTransportSecurity string   `json:"strictTransportSecurity,omitempty"`
+	ContentTypeOptions      string   `json:"contentTypeOptions,omitempty"`
+	FrameOptions            string   `json:"frameOptions,omitempty"`
+	InsecureCookies         []string `json:"insecureCookies,omitempty"`
+	Gaps                    []string `json:"gaps"`
+}
+
+func auditSecurity(response *http.Response) *securityAudit {
+	audit := &securityAudit{
+		ContentSecurityPolicy:   response.Header.Get("Content-Security-Policy"),
+		StrictTransportSecurity: response.Header.Get("Strict-Transport-Security"),
+		ContentTypeOptions:      response.Header.Get("X-Content-Type-Options"),
+		FrameOptions:            response.Header.Get("X-Frame-Options"),
+		InsecureCookies:         make([]string, 0),
+		Gaps:                    make([]string, 0),
+	}
+	if audit.ContentSecurityPolicy == "" {
+		audit.Gaps = append(audit.Gaps, gapNoCSP)
+	}
+	if response.Request.URL.Scheme != "https" {
+		audit.Gaps = append(audit.Gaps, gapNoTLS)
+	} else if audit.StrictTransportSecurity == "" {
+		audit.Gaps = append(audit.Gaps, gapNoHSTS)
+	}
+	if !strings.EqualFold(strings.TrimSpace(audit.ContentTypeOptions), "nosniff") {
+		audit.Gaps = append(audit.Gaps, gapNoSniff)
+	}
+	// CSP frame-ancestors supersedes X-Frame-Options, so only complain when neither is set.
+	if audit.FrameOptions == "" && !strings.Contains(strings.ToLower(audit.ContentSecurityPolicy), frameAncestorsPolicy) {
+		audit.Gaps = append(audit.Gaps, gapNoFrameOptions)
+	}
+	for _, nextCookie := range response.Cookies() {
+		missing := make([]string, 0)
+		if !nextCookie.Secure {
+			missing = append(missing, "Secure")
+		}
+		if !nextCookie.HttpOnly {
+			missing = append(missing, "HttpOnly")
+		}
+		if len(missing) > 0 {
+			audit.InsecureCookies = append(audit.InsecureCookies, nextCookie.Name+" without "+strings.Join(missing, ", "))
+		}
+	}
+	if len(audit.InsecureCookies) > 0 {
+		audit.Gaps = append(audit.Gaps, gapInsecureCookies)
+	}
+	return audit
+}
+
+type hostSecurity struct {
+	Pages int            `json:"pages"`
+	Gaps  map[string]int `json:"gaps"`
+}
+
+type securityReport struct {
+	hosts map[string]*hostSecurity
+}
+
+func newSecurityReport() *securityReport {
+	return &securityReport{
+		hosts: make(map[string]*hostSecurity),
+	}
+}
+
+func (this *securityReport) name() string {
+	return "security"
+}
+
+func (this *securityReport) observe(asset *asset) {
+	if asset.Security == nil {
+		return
+	}
+	host := hostOf(asset.Address)
+	summary, ok := this.hosts[host]
+	if !ok {
+		summary = &hostSecurity{
+			Gaps: make(map[string]int),
+		}
+		this.hosts[host] = summary
+	}
+	summary.Pages++
+	for _, nextGap := range asset.Security.Gaps {
+		summary.Gaps[nextGap]++
+	}
+}
+
+func (this *securityReport) summary() any {
+	return this.hosts
+}
@@ -0,0 +1,544 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/viper"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	jobDone      = "done"
+	jobScheduled = "scheduled"
+)
+
+// A crawl submitted to the service through POST /crawl. It has a visited set
+// of its own, so it fetches its addresses however often other jobs did. Jobs
+// with a cron spec crawl their addresses again whenever it comes round, each
+// time as a run of their own.
+type crawlJob struct {
+	id        string
+	submitted time.Time
+	spec      string
+	entry     cron.EntryID
+	seeds     []*crawlTarget
+	run       string
+	runs      int
+	visited   *visitedSet
+	pending   int
+	fetched   int
+	failed    int
+	assets    [][]byte
+	dropped   int
+	watchers  map[chan []byte]bool
+}
+
+// Runs the crawl as a long-running service, whose frontier stays open for
+// the jobs submitted to it until the service is stopped.
+type crawlService struct {
+	lock     *sync.Mutex
+	jobs     map[string]*crawlJob
+	order    []string
+	keep     int
+	watchers map[chan []byte]bool
+	schedule *cron.Cron
+}
+
+var service *crawlService
+
+func newJobID() string {
+	id := make([]byte, 6)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Jobs without a spec run once right away, the others whenever their spec
+// comes round.
+func (this *crawlService) submit(seeds []*crawlTarget, spec string) (*crawlJob, error) {
+	job := &crawlJob{
+		id:        newJobID(),
+		submitted: time.Now().UTC(),
+		spec:      spec,
+		seeds:     seeds,
+		assets:    make([][]byte, 0),
+		watchers:  make(map[chan []byte]bool),
+	}
+	if spec != "" {
+		entry, err := this.schedule.AddFunc(spec, func() { this.start(job) })
+		if err != nil {
+			return nil, fmt.Errorf("invalid spec %s: %s", spec, err.Error())
+		}
+		job.entry = entry
+	}
+	this.lock.Lock()
+	this.jobs[job.id] = job
+	this.order = append(this.order, job.id)
+	this.lock.Unlock()
+	if spec == "" {
+		this.start(job)
+	}
+	return job, nil
+}
+
+// Starts a run of the job, with a visited set and assets of its own. The
+// job counts as pending until all of its seeds are queued, so the run can't
+// be done before the last of them is.
+func (this *crawlService) start(job *crawlJob) {
+	this.lock.Lock()
+	if job.pending > 0 {
+		this.lock.Unlock()
+		slog.Warn("Not starting job, its last run is still going", "id", job.id, "run", job.run)
+		return
+	}
+	job.run = newRunID()
+	job.runs++
+	job.visited = &visitedSet{
+		lock:      &sync.Mutex{},
+		addresses: make(map[string]bool),
+	}
+	job.pending = 1
+	job.fetched, job.failed = 0, 0
+	job.assets, job.dropped = make([][]byte, 0), 0
+	this.lock.Unlock()
+	slog.Info("Starting job", "id", job.id, "run", job.run)
+	for _, nextSeed := range job.seeds {
+		seed := *nextSeed
+		seed.Job, seed.Run = job.id, job.run
+		seedTarget(&seed)
+	}
+	this.finished(&crawlTarget{Job: job.id})
+}
+
+// Stops the job from running again, leaving a run that is going to finish.
+func (this *crawlService) cancel(id string) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	job := this.jobs[id]
+	if job == nil {
+		return false
+	}
+	if job.spec != "" {
+		this.schedule.Remove(job.entry)
+		job.spec = ""
+	}
+	return true
+}
+
+// The visited set the target's job keeps, or the crawl's own for targets
+// that belong to no job.
+func visitedFor(target *crawlTarget) *visitedSet {
+	if service != nil && target.Job != "" {
+		service.lock.Lock()
+		defer service.lock.Unlock()
+		if job := service.jobs[target.Job]; job != nil && job.visited != nil {
+			return job.visited
+		}
+	}
+	return visited
+}
+
+func (this *crawlService) queued(target *crawlTarget) {
+	if target.Job == "" {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if job := this.jobs[target.Job]; job != nil {
+		job.pending++
+	}
+}
+
+func (this *crawlService) finished(target *crawlTarget) {
+	if target.Job == "" {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if job := this.jobs[target.Job]; job != nil {
+		job.release()
+	}
+}
+
+// Ends the streams watching the job once nothing of it is left queued.
+func (this *crawlJob) release() {
+	this.pending--
+	if this.pending > 0 {
+		return
+	}
+	for nextWatcher := range this.watchers {
+		close(nextWatcher)
+	}
+	this.watchers = make(map[chan []byte]bool)
+	slog.Info("Finished job", "id", this.id, "fetched", this.fetched, "failed", this.failed)
+}
+
+func (this *crawlService) attempted(target *crawlTarget, status int, err error) {
+	if target.Job == "" {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if job := this.jobs[target.Job]; job != nil {
+		if err != nil || status >= 400 {
+			job.failed++
+		} else {
+			job.fetched++
+		}
+	}
+}
+
+// Hands the asset to everyone watching, closing the streams of watchers too
+// slow to keep up rather than holding up the crawl.
+func broadcast(watchers map[chan []byte]bool, rawAsset []byte) {
+	for nextWatcher := range watchers {
+		select {
+		case nextWatcher <- rawAsset:
+		default:
+			close(nextWatcher)
+			delete(watchers, nextWatcher)
+		}
+	}
+}
+
+func (this *crawlService) written(asset *asset) {
+	rawAsset, err := encodeAsset(asset, strings.ToLower(viper.GetString("Output.Data")))
+	if err == nil {
+		rawAsset, err = selectFields(rawAsset, parseFields(viper.GetString("Output.Fields")))
+	}
+	if err != nil {
+		slog.Error("Cannot encode asset", "url", asset.Address, failed(err))
+		return
+	}
+	rawAsset = append(rawAsset, '\n')
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	broadcast(this.watchers, rawAsset)
+	job := this.jobs[asset.Job]
+	if job == nil {
+		return
+	}
+	if len(job.assets) < this.keep {
+		job.assets = append(job.assets, rawAsset)
+	} else {
+		job.dropped++
+	}
+	broadcast(job.watchers, rawAsset)
+}
+
+// Starts watching the assets of a job, or of every job when id is empty.
+// Watching a job gives the assets it already has first, and nothing more
+// once it is done.
+func (this *crawlService) watch(id string) ([][]byte, chan []byte, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	stream := make(chan []byte, 256)
+	if id == "" {
+		this.watchers[stream] = true
+		return nil, stream, true
+	}
+	job := this.jobs[id]
+	if job == nil {
+		return nil, nil, false
+	}
+	backlog := append([][]byte{}, job.assets...)
+	if job.pending > 0 {
+		job.watchers[stream] = true
+	} else {
+		close(stream)
+	}
+	return backlog, stream, true
+}
+
+func (this *crawlService) unwatch(id string, stream chan []byte) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	watchers := this.watchers
+	if job := this.jobs[id]; job != nil {
+		watchers = job.watchers
+	}
+	if watchers[stream] {
+		delete(watchers, stream)
+		close(stream)
+	}
+}
+
+func (this *crawlJob) status(paused bool) *jobStatus {
+	state := jobRunning
+	switch {
+	case this.pending == 0 && this.spec != "":
+		state = jobScheduled
+	case this.pending == 0:
+		state = jobDone
+	case paused:
+		state = jobPaused
+	}
+	submitted := this.submitted
+	status := &jobStatus{
+		Name:      this.id,
+		Run:       this.run,
+		State:     state,
+		Submitted: &submitted,
+		Spec:      this.spec,
+		Runs:      this.runs,
+		Seeds:     len(this.seeds),
+		Pending:   this.pending,
+		Fetched:   this.fetched,
+		Failed:    this.failed,
+		Assets:    len(this.assets) + this.dropped,
+	}
+	if this.spec != "" {
+		next := service.schedule.Entry(this.entry).Next
+		status.Next = &next
+	}
+	return status
+}
+
+func (this *crawlService) statuses() []*jobStatus {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	buf := make([]*jobStatus, 0, len(this.order))
+	for _, nextID := range this.order {
+		buf = append(buf, this.jobs[nextID].status(gateFor(nextID).isPaused()))
+	}
+	return buf
+}
+
+func (this *crawlService) jobStatus(id string) *jobStatus {
+	paused := gateFor(id).isPaused()
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	job := this.jobs[id]
+	if job == nil {
+		return nil
+	}
+	return job.status(paused)
+}
+
+// A job submitted as a JSON object rather than as input.
+type jobRequest struct {
+	URLs []string `json:"urls"`
+	Spec string   `json:"spec"`
+}
+
+// Serves POST /crawl, whose body is either a JSON object with the urls to
+// crawl and the spec to crawl them on, or lists the addresses the way the
+// input does, in Input.Format unless the format parameter says otherwise
+// and on the spec parameter's schedule.
+func serveCrawl(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	spec := request.URL.Query().Get("spec")
+	targets := make([]*crawlTarget, 0)
+	var err error
+	if mediaType, _, _ := strings.Cut(request.Header.Get("Content-Type"), ";"); strings.TrimSpace(mediaType) == "application/json" {
+		submitted := &jobRequest{}
+		err = json.NewDecoder(request.Body).Decode(submitted)
+		for _, nextURL := range submitted.URLs {
+			targets = append(targets, &crawlTarget{Address: nextURL})
+		}
+		if submitted.Spec != "" {
+			spec = submitted.Spec
+		}
+	} else {
+		format := strings.ToLower(request.URL.Query().Get("format"))
+		if format == "" {
+			format = strings.ToLower(viper.GetString("Input.Format"))
+		}
+		err = readInput(request.Body, format, func(target *crawlTarget) {
+			targets = append(targets, target)
+		})
+	}
+	if err != nil {
+		writeJSON(writer, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if len(targets) == 0 {
+		writeJSON(writer, http.StatusBadRequest, map[string]string{"error": "no addresses to crawl"})
+		return
+	}
+	job, err := service.submit(targets, spec)
+	if err != nil {
+		writeJSON(writer, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	slog.Info("Submitted job", "id", job.id, "count", len(targets), "spec", spec)
+	writer.Header().Set("Location", "/jobs/"+job.id)
+	writeJSON(writer, http.StatusAccepted, service.jobStatus(job.id))
+}
+
+// Serves GET /assets, streaming assets as JSON lines while they are output.
+// With a job parameter, the stream holds that job's assets and ends once it
+// is done.
+func serveAssets(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := request.URL.Query().Get("job")
+	backlog, stream, ok := service.watch(id)
+	if !ok {
+		writeJSON(writer, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("no job named %s", id)})
+		return
+	}
+	defer service.unwatch(id, stream)
+	writer.Header().Set("Content-Type", "application/x-ndjson")
+	writer.WriteHeader(http.StatusOK)
+	flusher, _ := writer.(http.Flusher)
+	for _, nextAsset := range backlog {
+		writer.Write(nextAsset)
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+	for {
+		select {
+		case rawAsset, open := <-stream:
+			if !open {
+				return
+			}
+			_, err := writer.Write(rawAsset)
+			if err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-request.Context().Done():
+			return
+		}
+	}
+}
+
+// Serves GET /assets/ws, which streams the same assets as GET /assets over
+// a WebSocket, a text message each, for dashboards watching the crawl from
+// a browser. Browsers may only connect from the service's own host or the
+// origins in Control.Origins, so other sites can't read the crawl through
+// their visitors.
+var serveAssetSocket = &websocket.Server{
+	Handshake: func(config *websocket.Config, request *http.Request) error {
+		return allowedOrigin(request)
+	},
+	Handler: func(conn *websocket.Conn) {
+		id := conn.Request().URL.Query().Get("job")
+		backlog, stream, ok := service.watch(id)
+		if !ok {
+			websocket.JSON.Send(conn, map[string]string{"error": fmt.Sprintf("no job named %s", id)})
+			return
+		}
+		defer service.unwatch(id, stream)
+		// Nothing is read from clients, besides telling when they leave.
+		gone := make(chan struct{})
+		go func() {
+			io.Copy(io.Discard, conn)
+			close(gone)
+		}()
+		sendAsset := func(rawAsset []byte) error {
+			return websocket.Message.Send(conn, string(bytes.TrimRight(rawAsset, "\n")))
+		}
+		for _, nextAsset := range backlog {
+			if sendAsset(nextAsset) != nil {
+				return
+			}
+		}
+		for {
+			select {
+			case rawAsset, open := <-stream:
+				if !open || sendAsset(rawAsset) != nil {
+					return
+				}
+			case <-gone:
+				return
+			}
+		}
+	},
+}
+
+func serveAPI(writer http.ResponseWriter, request *http.Request) {
+	switch strings.Trim(request.URL.Path, "/") {
+	case "crawl":
+		serveCrawl(writer, request)
+	case "assets":
+		serveAssets(writer, request)
+	case "assets/ws":
+		serveAssetSocket.ServeHTTP(writer, request)
+	default:
+		serveControl(writer, request)
+	}
+}
+
+// Starts the service on Control.Serve, which then crawls whatever is
+// submitted to it instead of reading input.
+func initService() bool {
+	listen := viper.GetString("Control.Serve")
+	if listen == "" {
+		return false
+	}
+	service = &crawlService{
+		lock:     &sync.Mutex{},
+		jobs:     make(map[string]*crawlJob),
+		order:    make([]string, 0),
+		keep:     viper.GetInt("Control.KeepAssets"),
+		watchers: make(map[chan []byte]bool),
+		schedule: cron.New(),
+	}
+	service.schedule.Start()
+	go func() {
+		err := http.ListenAndServe(listen, http.HandlerFunc(serveAPI))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, fmt.Sprintf("Cannot serve on %s: %s", listen, err.Error()))
+			os.Exit(1)
+		}
+	}()
+	slog.Info("Serving", "listen", listen)
+	return true
+}
+
+// Clients other than browsers send no Origin and are let through.
+func allowedOrigin(request *http.Request) error {
+	origin := request.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	parsed, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(parsed.Host, request.Host) {
+		return nil
+	}
+	for _, nextOrigin := range parseFields(viper.GetString("Control.Origins")) {
+		if nextOrigin == "*" || strings.EqualFold(strings.TrimSuffix(nextOrigin, "/"), origin) {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %s isn't allowed", origin)
+}
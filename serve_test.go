@@ -0,0 +1,59 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestAllowedOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		origin  string
+		allowed string
+		ok      bool
+	}{
+		{name: "no origin", ok: true},
+		{name: "own host", origin: "http://crawler.internal:8080", ok: true},
+		{name: "own host in another case", origin: "http://Crawler.Internal:8080", ok: true},
+		{name: "other port", origin: "http://crawler.internal:9090", ok: false},
+		{name: "other site", origin: "https://evil.example", ok: false},
+		{name: "listed", origin: "https://dashboard.example.com", allowed: "https://other.example, https://dashboard.example.com/", ok: true},
+		{name: "not listed", origin: "https://evil.example", allowed: "https://dashboard.example.com", ok: false},
+		{name: "any", origin: "https://evil.example", allowed: "*", ok: true},
+	}
+	defer viper.Set("Control.Origins", "")
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			viper.Set("Control.Origins", nextTest.allowed)
+			request, err := http.NewRequest(http.MethodGet, "http://crawler.internal:8080/assets/ws", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if nextTest.origin != "" {
+				request.Header.Set("Origin", nextTest.origin)
+			}
+			if err := allowedOrigin(request); (err == nil) != nextTest.ok {
+				t.Errorf("allowedOrigin(%s) = %v, want allowed %v", nextTest.origin, err, nextTest.ok)
+			}
+		})
+	}
+}
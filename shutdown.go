@@ -0,0 +1,128 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var finishOnce = &sync.Once{}
+
+// Writes out everything the crawl kept until the end, exactly once however
+// the crawl ends. Outputs are closed while holding the output lock, so no
+// asset is cut off halfway.
+func finish() {
+	finishOnce.Do(func() {
+		err := saveState()
+		if err != nil {
+			slog.Error("Cannot save crawl state", failed(err))
+		}
+		if ordering != nil {
+			ordering.flush()
+		}
+		writeReports()
+		err = writeSummary()
+		if err != nil {
+			slog.Error("Cannot write crawl summary", failed(err))
+		}
+		if index != nil {
+			err := index.save()
+			if err != nil {
+				slog.Error("Cannot save search index", failed(err))
+			}
+		}
+		if pageMonitor != nil {
+			err := pageMonitor.save()
+			if err != nil {
+				slog.Error("Cannot save monitor state", failed(err))
+			}
+		}
+		if graph != nil {
+			err := graph.save()
+			if err != nil {
+				slog.Error("Cannot save link graph", failed(err))
+			}
+		}
+		if har != nil {
+			err := har.save()
+			if err != nil {
+				slog.Error("Cannot save HAR", failed(err))
+			}
+		}
+		if retries != nil {
+			err := retries.save()
+			if err != nil {
+				slog.Error("Cannot save retry store", failed(err))
+			}
+		}
+		err = saveRobotsCache()
+		if err != nil {
+			slog.Error("Cannot save robots.txt cache", failed(err))
+		}
+		err = saveCookies()
+		if err != nil {
+			slog.Error("Cannot save cookies", failed(err))
+		}
+		if validators != nil {
+			err := validators.save()
+			if err != nil {
+				slog.Error("Cannot save cache", failed(err))
+			}
+		}
+		for _, nextOutput := range outputs {
+			nextOutput.close()
+		}
+	})
+}
+
+// The first interrupt stops the crawl taking on addresses and lets the
+// fetches underway finish for up to Job.ShutdownTimeout seconds before
+// everything is written out. A second one quits at once.
+func handleSignals(group *sync.WaitGroup) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		received := <-signals
+		dropped := frontier.stop()
+		slog.Warn("Stopping, leaving queued addresses unfetched", "signal", received.String(), "dropped", dropped)
+		done := make(chan struct{})
+		go func() {
+			group.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Duration(viper.GetFloat64("Job.ShutdownTimeout") * float64(time.Second))):
+			slog.Warn("Gave up waiting for fetches underway")
+		case received = <-signals:
+			slog.Warn("Quitting", "signal", received.String())
+			os.Exit(1)
+		}
+		// Another interrupt while writing out quits the usual way.
+		signal.Stop(signals)
+		finish()
+		os.Exit(1)
+	}()
+}
@@ -0,0 +1,99 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Sitemap indexes may point at more indexes, but not deeper than this.
+const maxSitemapDepth = 3
+
+type sitemapDocument struct {
+	XMLName  xml.Name
+	URLs     []string `xml:"url>loc"`
+	Sitemaps []string `xml:"sitemap>loc"`
+}
+
+// Lists every page address in the sitemap, following sitemap indexes.
+// Gzipped and plain text sitemaps are understood too.
+func readSitemap(where string) []string {
+	return readSitemapDepth(where, 0)
+}
+
+func readSitemapDepth(where string, depth int) []string {
+	response, rawSitemap, err := retrieve(where)
+	if err != nil {
+		slog.Error("Cannot fetch sitemap", "url", where, failed(err))
+		return nil
+	}
+	if response.StatusCode >= 400 {
+		slog.Error("Cannot fetch sitemap", "url", where, "status", response.StatusCode)
+		return nil
+	}
+	buf, document, err := parseSitemap(rawSitemap)
+	if err != nil {
+		slog.Error("Cannot read sitemap", "url", where, failed(err))
+		return nil
+	}
+	if document == nil {
+		return buf
+	}
+	if depth+1 >= maxSitemapDepth {
+		return buf
+	}
+	for _, nextSitemap := range document.Sitemaps {
+		buf = append(buf, readSitemapDepth(strings.TrimSpace(nextSitemap), depth+1)...)
+	}
+	return buf
+}
+
+// Returns the page addresses of the sitemap, along with the parsed document
+// unless the sitemap was plain text.
+func parseSitemap(rawSitemap []byte) ([]string, *sitemapDocument, error) {
+	if bytes.HasPrefix(rawSitemap, []byte{0x1f, 0x8b}) {
+		reader, err := gzip.NewReader(bytes.NewReader(rawSitemap))
+		if err == nil {
+			rawSitemap, err = io.ReadAll(reader)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	buf := make([]string, 0)
+	document := &sitemapDocument{}
+	err := xml.Unmarshal(rawSitemap, document)
+	if err != nil {
+		for _, nextLine := range strings.Split(string(rawSitemap), "\n") {
+			nextLine = strings.TrimSpace(nextLine)
+			if strings.HasPrefix(nextLine, "http://") || strings.HasPrefix(nextLine, "https://") {
+				buf = append(buf, nextLine)
+			}
+		}
+		return buf, nil, nil
+	}
+	for _, nextURL := range document.URLs {
+		buf = append(buf, strings.TrimSpace(nextURL))
+	}
+	return buf, document, nil
+}
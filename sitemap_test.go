@@ -0,0 +1,111 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"testing"
+)
+
+func gzipped(t *testing.T, raw string) []byte {
+	buf := &bytes.Buffer{}
+	writer := gzip.NewWriter(buf)
+	_, err := writer.Write([]byte(raw))
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseSitemap(t *testing.T) {
+	urlset := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc> https://example.com/a </loc></url>
+	<url><loc>https://example.com/b</loc><lastmod>2024-01-01</lastmod></url>
+</urlset>`
+	sitemapIndex := `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<sitemap><loc>https://example.com/pages.xml</loc></sitemap>
+	<sitemap><loc>https://example.com/posts.xml</loc></sitemap>
+</sitemapindex>`
+	tests := []struct {
+		name      string
+		raw       []byte
+		addresses []string
+		sitemaps  []string
+		plain     bool
+	}{
+		{
+			name:      "urlset",
+			raw:       []byte(urlset),
+			addresses: []string{"https://example.com/a", "https://example.com/b"},
+		},
+		{
+			name:      "index",
+			raw:       []byte(sitemapIndex),
+			addresses: []string{},
+			sitemaps:  []string{"https://example.com/pages.xml", "https://example.com/posts.xml"},
+		},
+		{
+			name:      "gzipped",
+			raw:       gzipped(t, urlset),
+			addresses: []string{"https://example.com/a", "https://example.com/b"},
+		},
+		{
+			name:      "plain text",
+			raw:       []byte("https://example.com/a\r\n\nnot an address\n  http://example.com/b  \n"),
+			addresses: []string{"https://example.com/a", "http://example.com/b"},
+			plain:     true,
+		},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			addresses, document, err := parseSitemap(nextTest.raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(addresses, nextTest.addresses) {
+				t.Errorf("addresses = %v, want %v", addresses, nextTest.addresses)
+			}
+			if nextTest.plain {
+				if document != nil {
+					t.Errorf("document = %v, want none for plain text", document)
+				}
+				return
+			}
+			if document == nil {
+				t.Fatal("document = nil")
+			}
+			if !reflect.DeepEqual(document.Sitemaps, nextTest.sitemaps) {
+				t.Errorf("sitemaps = %v, want %v", document.Sitemaps, nextTest.sitemaps)
+			}
+		})
+	}
+}
+
+func TestParseSitemapBrokenGzip(t *testing.T) {
+	_, _, err := parseSitemap([]byte{0x1f, 0x8b, 0x00})
+	if err == nil {
+		t.Error("err = nil, want an error for a broken gzip stream")
+	}
+}
@@ -0,0 +1,141 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const databaseSchema = `
+CREATE TABLE IF NOT EXISTS assets (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	job TEXT NOT NULL,
+	run TEXT NOT NULL,
+	address TEXT NOT NULL,
+	final_address TEXT,
+	accessed TEXT NOT NULL,
+	depth INTEGER NOT NULL,
+	referrer TEXT,
+	title TEXT,
+	hash TEXT,
+	asset TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS assets_address ON assets (address);
+CREATE INDEX IF NOT EXISTS assets_accessed ON assets (accessed);
+CREATE TABLE IF NOT EXISTS edges (
+	asset INTEGER NOT NULL REFERENCES assets (id),
+	source TEXT NOT NULL,
+	target TEXT NOT NULL,
+	element TEXT,
+	attribute TEXT
+);
+CREATE INDEX IF NOT EXISTS edges_source ON edges (source);
+CREATE INDEX IF NOT EXISTS edges_target ON edges (target);
+`
+
+// Keeps every asset output in an SQLite database, along with an edge for
+// every reference between pages, so crawls can be queried without
+// processing the JSON first.
+// Its assets are stored whole, whatever the output options say.
+type assetDatabase struct {
+	path string
+	db   *sql.DB
+}
+
+func (this *assetDatabase) Open() error {
+	db, err := sql.Open("sqlite", this.path)
+	if err != nil {
+		return err
+	}
+	// SQLite only lets one connection write at a time.
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(databaseSchema)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	this.db = db
+	return nil
+}
+
+func (this *assetDatabase) Write(asset *asset, rawOutput []byte) error {
+	rawAsset, err := json.Marshal(asset)
+	if err != nil {
+		return err
+	}
+	transaction, err := this.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback()
+	result, err := transaction.Exec(
+		"INSERT INTO assets (job, run, address, final_address, accessed, depth, referrer, title, hash, asset) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		asset.Job, asset.Run, asset.Address, asset.FinalAddress, asset.Accessed.Format(time.RFC3339Nano),
+		asset.Depth, asset.Referrer, asset.Title, asset.Hash, string(rawAsset),
+	)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	statement, err := transaction.Prepare("INSERT INTO edges (asset, source, target, element, attribute) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	if len(asset.ReferenceTags) > 0 {
+		for _, nextTag := range asset.ReferenceTags {
+			_, err = statement.Exec(id, asset.Address, nextTag.Address, nextTag.Element, nextTag.Attribute)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		for _, nextReference := range asset.References {
+			_, err = statement.Exec(id, asset.Address, nextReference, nil, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return transaction.Commit()
+}
+
+func (this *assetDatabase) Flush() error {
+	return nil
+}
+
+func (this *assetDatabase) Close() error {
+	return this.db.Close()
+}
+
+func init() {
+	registerSink("sqlite", "SQLite", func(target string) (sink, error) {
+		if target == "" {
+			return nil, fmt.Errorf("sqlite output has no path")
+		}
+		return &assetDatabase{path: target}, nil
+	})
+}
@@ -0,0 +1,160 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/quic-go/quic-go"
+	"github.com/spf13/viper"
+)
+
+var errPrivateAddress = errors.New("only resolves to private addresses")
+
+// Ranges net.IP has no method for: "this network", which connecting to
+// 0.0.0.0 reaches the machine itself through, and carrier-grade NAT, which
+// is as private as the ranges IsPrivate knows.
+var privateNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("100.64.0.0/10"),
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+func privateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, nextNetwork := range privateNetworks {
+		if nextNetwork.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolves the address and leaves out every private IP it resolves to. The
+// caller must connect to what this returns rather than resolving again, or
+// a second lookup could hand out a different, private IP.
+func publicAddresses(ctx context.Context, address string) ([]string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := lookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]string, 0, len(resolved))
+	for _, nextIP := range resolved {
+		if privateIP(nextIP) {
+			continue
+		}
+		buf = append(buf, net.JoinHostPort(nextIP.String(), port))
+	}
+	if len(buf) == 0 {
+		return nil, fmt.Errorf("refusing to connect to %s: %w", host, errPrivateAddress)
+	}
+	return buf, nil
+}
+
+// Checks a host someone else connects to, like a proxy, which may resolve it
+// again on its own.
+func publicHost(ctx context.Context, host string) error {
+	_, err := publicAddresses(ctx, net.JoinHostPort(host, "0"))
+	return err
+}
+
+func dialPublic(ctx context.Context, network string, address string) (net.Conn, error) {
+	addresses, err := publicAddresses(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	for _, nextAddress := range addresses {
+		var conn net.Conn
+		conn, err = directDialer.DialContext(ctx, network, nextAddress)
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+func dialPublicQUIC(ctx context.Context, address string, tlsConfig *tls.Config, config *quic.Config) (quic.EarlyConnection, error) {
+	addresses, err := publicAddresses(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	for _, nextAddress := range addresses {
+		var conn quic.EarlyConnection
+		conn, err = quic.DialAddrEarly(ctx, nextAddress, tlsConfig, config)
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// Resolves the host through the DNS cache, the way TCP connections are.
+func dialResolvedQUIC(ctx context.Context, address string, tlsConfig *tls.Config, config *quic.Config) (quic.EarlyConnection, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := lookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, nextIP := range ips {
+		var conn quic.EarlyConnection
+		conn, err = quic.DialAddrEarly(ctx, net.JoinHostPort(nextIP.String(), port), tlsConfig, config)
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// Whether private addresses are refused is looked up on every dial, since
+// --serve is only known once the client is set up.
+func dialQUIC(ctx context.Context, address string, tlsConfig *tls.Config, config *quic.Config) (quic.EarlyConnection, error) {
+	if blockPrivate() {
+		return dialPublicQUIC(ctx, address, tlsConfig, config)
+	}
+	return dialResolvedQUIC(ctx, address, tlsConfig, config)
+}
+
+// Anyone who can reach the service picks what it fetches, so it doesn't go
+// to private addresses unless Control.BlockPrivate says it may.
+func blockPrivate() bool {
+	if viper.GetString("Control.Serve") != "" && viper.GetBool("Control.BlockPrivate") {
+		return true
+	}
+	return viper.GetBool("Network.BlockPrivate")
+}
@@ -0,0 +1,172 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestPrivateIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		private bool
+	}{
+		{ip: "127.0.0.1", private: true},
+		{ip: "127.8.9.10", private: true},
+		{ip: "10.1.2.3", private: true},
+		{ip: "172.16.0.1", private: true},
+		{ip: "172.31.255.255", private: true},
+		{ip: "172.32.0.1", private: false},
+		{ip: "192.168.1.1", private: true},
+		{ip: "169.254.169.254", private: true},
+		{ip: "0.0.0.0", private: true},
+		{ip: "0.1.2.3", private: true},
+		{ip: "0.255.255.255", private: true},
+		{ip: "1.0.0.1", private: false},
+		{ip: "100.64.0.1", private: true},
+		{ip: "100.127.255.254", private: true},
+		{ip: "100.63.255.255", private: false},
+		{ip: "100.128.0.1", private: false},
+		{ip: "::ffff:100.64.0.1", private: true},
+		{ip: "8.8.8.8", private: false},
+		{ip: "93.184.216.34", private: false},
+		{ip: "::1", private: true},
+		{ip: "::", private: true},
+		{ip: "fe80::1", private: true},
+		{ip: "fd00::1", private: true},
+		{ip: "ff02::1", private: true},
+		{ip: "::ffff:127.0.0.1", private: true},
+		{ip: "::ffff:10.0.0.1", private: true},
+		{ip: "2606:4700:4700::1111", private: false},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.ip, func(t *testing.T) {
+			if private := privateIP(net.ParseIP(nextTest.ip)); private != nextTest.private {
+				t.Errorf("privateIP(%s) = %v, want %v", nextTest.ip, private, nextTest.private)
+			}
+		})
+	}
+}
+
+func TestPublicAddresses(t *testing.T) {
+	lookups := map[string][]net.IP{
+		"mixed.test":   {net.ParseIP("10.0.0.5"), net.ParseIP("93.184.216.34"), net.ParseIP("::1"), net.ParseIP("2606:4700::1")},
+		"private.test": {net.ParseIP("127.0.0.1"), net.ParseIP("192.168.0.10")},
+	}
+	previous := resolver
+	resolver = &dnsCache{
+		lock: &sync.Mutex{},
+		lookup: func(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+			return lookups[host], time.Minute, nil
+		},
+		ttl:     time.Minute,
+		entries: make(map[string]*dnsEntry),
+	}
+	defer func() {
+		resolver = previous
+	}()
+	tests := []struct {
+		name      string
+		address   string
+		addresses []string
+		private   bool
+	}{
+		{
+			name:      "private ones are left out",
+			address:   "mixed.test:443",
+			addresses: []string{"93.184.216.34:443", "[2606:4700::1]:443"},
+		},
+		{
+			name:    "only private ones",
+			address: "private.test:80",
+			private: true,
+		},
+		{
+			name:      "public literal",
+			address:   "93.184.216.34:80",
+			addresses: []string{"93.184.216.34:80"},
+		},
+		{
+			name:    "private literal",
+			address: "[::1]:8080",
+			private: true,
+		},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			addresses, err := publicAddresses(context.Background(), nextTest.address)
+			if nextTest.private {
+				if !errors.Is(err, errPrivateAddress) {
+					t.Errorf("publicAddresses(%s) = %v, %v, want errPrivateAddress", nextTest.address, addresses, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(addresses, nextTest.addresses) {
+				t.Errorf("publicAddresses(%s) = %v, want %v", nextTest.address, addresses, nextTest.addresses)
+			}
+		})
+	}
+}
+
+func TestProxyForPrivate(t *testing.T) {
+	viper.Set("Network.Proxy", "http://127.0.0.1:3128")
+	viper.Set("Network.BlockPrivate", true)
+	defer func() {
+		viper.Set("Network.Proxy", "")
+		viper.Set("Network.BlockPrivate", false)
+	}()
+	tests := []struct {
+		address string
+		private bool
+	}{
+		{address: "http://127.0.0.1/", private: true},
+		{address: "http://169.254.169.254/latest/meta-data/", private: true},
+		{address: "https://[::1]:8443/", private: true},
+		{address: "http://93.184.216.34/"},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.address, func(t *testing.T) {
+			request, err := http.NewRequest(http.MethodGet, nextTest.address, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			proxy, err := proxyFor(request)
+			if nextTest.private {
+				if !errors.Is(err, errPrivateAddress) {
+					t.Errorf("proxyFor(%s) = %v, %v, want errPrivateAddress", nextTest.address, proxy, err)
+				}
+				return
+			}
+			if err != nil || proxy == nil || proxy.Host != "127.0.0.1:3128" {
+				t.Errorf("proxyFor(%s) = %v, %v, want the proxy", nextTest.address, proxy, err)
+			}
+		})
+	}
+}
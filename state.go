@@ -0,0 +1,118 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const stateFile = "frontier.json"
+
+// What a crawl still had to do, and what it had already seen, so it can be
+// picked up again with --resume.
+type crawlState struct {
+	Pending []*crawlTarget `json:"pending"`
+	Visited []string       `json:"visited"`
+}
+
+func statePath() string {
+	directory := viper.GetString("Frontier.State")
+	if directory == "" {
+		return ""
+	}
+	return filepath.Join(directory, stateFile)
+}
+
+// Writes the state next to where it goes first, so a crawl killed halfway
+// through saving still leaves the previous state behind.
+func saveState() error {
+	path := statePath()
+	if path == "" {
+		return nil
+	}
+	rawState, err := json.Marshal(&crawlState{
+		Pending: frontier.pending(),
+		Visited: visited.all(),
+	})
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path+".new", rawState, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".new", path)
+}
+
+func loadState() (*crawlState, error) {
+	path := statePath()
+	if path == "" {
+		return nil, fmt.Errorf("no state directory configured, set Frontier.State or pass --state first")
+	}
+	rawState, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	loaded := &crawlState{}
+	err = json.Unmarshal(rawState, loaded)
+	if err != nil {
+		return nil, err
+	}
+	return loaded, nil
+}
+
+// Marks everything the earlier crawl saw as visited and queues what it
+// didn't get to.
+func resumeState(loaded *crawlState) {
+	visited.restore(loaded.Visited)
+	for _, nextTarget := range loaded.Pending {
+		if nextTarget.Depth == 0 {
+			authSeeded(nextTarget.Address)
+		}
+		enqueue(nextTarget)
+	}
+	slog.Info("Resuming", "pending", len(loaded.Pending), "seen", len(loaded.Visited))
+}
+
+// Saves the state every Frontier.StateInterval seconds, so even a crawl
+// that is killed outright can be resumed.
+func keepState() {
+	interval := time.Duration(viper.GetFloat64("Frontier.StateInterval") * float64(time.Second))
+	if statePath() == "" || interval <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			err := saveState()
+			if err != nil {
+				slog.Error("Cannot save crawl state", failed(err))
+			}
+		}
+	}()
+}
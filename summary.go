@@ -0,0 +1,216 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// How many of the fastest and slowest pages the summary names.
+const summaryPages = 5
+
+type pageTime struct {
+	Address      string  `json:"address"`
+	Milliseconds float64 `json:"milliseconds"`
+	took         time.Duration
+}
+
+// Adds up every fetch of the crawl, for what cron mails or a wrapper
+// script reads once the crawl is over.
+type crawlSummary struct {
+	lock      *sync.Mutex
+	started   time.Time
+	attempted int
+	succeeded int
+	failures  map[string]int
+	bytes     int64
+	hosts     map[string]bool
+	fastest   []pageTime
+	slowest   []pageTime
+}
+
+var stats *crawlSummary
+
+func initSummary() {
+	if (!viper.GetBool("Report.Summary") && viper.GetString("Report.SummaryPath") == "") || stats != nil {
+		return
+	}
+	stats = &crawlSummary{
+		lock:     &sync.Mutex{},
+		started:  time.Now(),
+		failures: make(map[string]int),
+		hosts:    make(map[string]bool),
+	}
+}
+
+// Keeps the list ordered by less and no longer than summaryPages.
+func rankPage(pages []pageTime, page pageTime, less func(a, b time.Duration) bool) []pageTime {
+	at := sort.Search(len(pages), func(i int) bool { return less(page.took, pages[i].took) })
+	if at >= summaryPages {
+		return pages
+	}
+	pages = append(pages, pageTime{})
+	copy(pages[at+1:], pages[at:])
+	pages[at] = page
+	if len(pages) > summaryPages {
+		pages = pages[:summaryPages]
+	}
+	return pages
+}
+
+// Counts a fetch that answered with status, or failed with err. Statuses of
+// 400 and up count as failures, grouped by the status itself, and only
+// pages that succeeded are ranked by speed.
+func (this *crawlSummary) fetched(where string, status int, size int64, took time.Duration, err error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.attempted++
+	this.bytes += size
+	if host := hostOf(where); host != "" {
+		this.hosts[host] = true
+	}
+	switch {
+	case err != nil:
+		this.failures[failureClass(0, err)]++
+		return
+	case status >= 400:
+		this.failures[fmt.Sprint(status)]++
+		return
+	default:
+		this.succeeded++
+	}
+	page := pageTime{
+		Address:      where,
+		Milliseconds: float64(took.Microseconds()) / 1000,
+		took:         took,
+	}
+	this.fastest = rankPage(this.fastest, page, func(a, b time.Duration) bool { return a < b })
+	this.slowest = rankPage(this.slowest, page, func(a, b time.Duration) bool { return a > b })
+}
+
+type summaryReport struct {
+	Attempted int            `json:"attempted"`
+	Succeeded int            `json:"succeeded"`
+	Failed    int            `json:"failed"`
+	Failures  map[string]int `json:"failures"`
+	Bytes     int64          `json:"bytes"`
+	Hosts     int            `json:"hosts"`
+	Elapsed   float64        `json:"elapsed"`
+	Fastest   []pageTime     `json:"fastest"`
+	Slowest   []pageTime     `json:"slowest"`
+}
+
+func (this *crawlSummary) report() *summaryReport {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	summary := &summaryReport{
+		Attempted: this.attempted,
+		Succeeded: this.succeeded,
+		Failed:    this.attempted - this.succeeded,
+		Failures:  make(map[string]int),
+		Bytes:     this.bytes,
+		Hosts:     len(this.hosts),
+		Elapsed:   time.Since(this.started).Seconds(),
+		Fastest:   append([]pageTime{}, this.fastest...),
+		Slowest:   append([]pageTime{}, this.slowest...),
+	}
+	for nextClass, nextCount := range this.failures {
+		summary.Failures[nextClass] = nextCount
+	}
+	return summary
+}
+
+func byteSize(size int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d B", size)
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+func (this *summaryReport) print(writer io.Writer) {
+	classes := make([]string, 0, len(this.Failures))
+	for nextClass, nextCount := range this.Failures {
+		classes = append(classes, fmt.Sprintf("%s %d", nextClass, nextCount))
+	}
+	sort.Strings(classes)
+	fmt.Fprintln(writer, "Crawl summary:")
+	fmt.Fprintf(writer, "  Attempted   %d\n", this.Attempted)
+	fmt.Fprintf(writer, "  Succeeded   %d\n", this.Succeeded)
+	if len(classes) > 0 {
+		fmt.Fprintf(writer, "  Failed      %d (%s)\n", this.Failed, strings.Join(classes, ", "))
+	} else {
+		fmt.Fprintf(writer, "  Failed      %d\n", this.Failed)
+	}
+	fmt.Fprintf(writer, "  Downloaded  %s\n", byteSize(this.Bytes))
+	fmt.Fprintf(writer, "  Hosts       %d\n", this.Hosts)
+	fmt.Fprintf(writer, "  Elapsed     %s\n", time.Duration(this.Elapsed*float64(time.Second)).Round(time.Millisecond))
+	for _, nextList := range []struct {
+		label string
+		pages []pageTime
+	}{{"Fastest", this.Fastest}, {"Slowest", this.Slowest}} {
+		for i, nextPage := range nextList.pages {
+			label := ""
+			if i == 0 {
+				label = nextList.label
+			}
+			fmt.Fprintf(writer, "  %-10s  %8.1fms  %s\n", label, nextPage.Milliseconds, nextPage.Address)
+		}
+	}
+}
+
+// Prints the summary to stderr under Report.Summary and writes it as JSON
+// to Report.SummaryPath, "-" being stdout.
+func writeSummary() error {
+	if stats == nil {
+		return nil
+	}
+	summary := stats.report()
+	if viper.GetBool("Report.Summary") {
+		summary.print(os.Stderr)
+	}
+	path := viper.GetString("Report.SummaryPath")
+	if path == "" {
+		return nil
+	}
+	rawSummary, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	rawSummary = append(rawSummary, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(rawSummary)
+		return err
+	}
+	return os.WriteFile(path, rawSummary, 0644)
+}
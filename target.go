@@ -0,0 +1,120 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/lunar-parklife/pagecrawl/pkg/crawler"
+	"github.com/spf13/viper"
+)
+
+// Something to fetch, along with how the crawl got to it.
+type crawlTarget struct {
+	Address string `json:"address"`
+	// How many links away from the input the address was found, which is
+	// zero for addresses read from the input.
+	Depth int `json:"depth"`
+	// The page the address was found on, empty for input addresses.
+	Referrer string `json:"referrer,omitempty"`
+	// How to ask for the address, which is a plain GET unless the input
+	// said otherwise.
+	Method  string            `json:"method,omitempty"`
+	Body    []byte            `json:"body,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// The job submitted to the service the address belongs to, which the
+	// addresses found on its page belong to as well.
+	Job string `json:"job,omitempty"`
+	// The run of that job the address was queued by.
+	Run string `json:"run,omitempty"`
+}
+
+func (this *crawlTarget) request() (*http.Request, error) {
+	method := strings.ToUpper(this.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if this.Body != nil {
+		body = bytes.NewReader(this.Body)
+	}
+	request, err := newRequest(method, this.Address, body)
+	if err != nil {
+		return nil, err
+	}
+	for nextName, nextValue := range this.Headers {
+		request.Header.Set(nextName, nextValue)
+	}
+	return request, nil
+}
+
+// Remembers every page each address was found on.
+type discoveryLog struct {
+	lock    *sync.Mutex
+	parents map[string]map[string]bool
+}
+
+var discoveries *discoveryLog
+
+func (this *discoveryLog) found(where string, references []string) {
+	base, err := url.Parse(where)
+	if err != nil {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for _, nextReference := range references {
+		resolved, err := crawler.ResolveReference(base, nextReference)
+		if err != nil {
+			continue
+		}
+		key := withoutFragment(resolved.String())
+		if this.parents[key] == nil {
+			this.parents[key] = make(map[string]bool)
+		}
+		this.parents[key][where] = true
+	}
+}
+
+// Lists the pages found linking to the address so far.
+func (this *discoveryLog) parentsOf(where string) []string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	buf := make([]string, 0, len(this.parents[withoutFragment(where)]))
+	for nextParent := range this.parents[withoutFragment(where)] {
+		buf = append(buf, nextParent)
+	}
+	sort.Strings(buf)
+	return buf
+}
+
+func initDiscoveries() {
+	if !viper.GetBool("Output.Parents") {
+		return
+	}
+	discoveries = &discoveryLog{
+		lock:    &sync.Mutex{},
+		parents: make(map[string]map[string]bool),
+	}
+}
@@ -0,0 +1,217 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"github.com/lunar-parklife/pagecrawl/pkg/crawler"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+type technology struct {
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	Version  string `json:"version,omitempty"`
+}
+
+// A fingerprint identifies one technology. Every pattern is optional and the
+// first capture group, if any, is taken as the version.
+type fingerprint struct {
+	name      string
+	category  string
+	headers   map[string]*regexp.Regexp
+	cookies   []string
+	scripts   *regexp.Regexp
+	generator *regexp.Regexp
+	markup    *regexp.Regexp
+}
+
+var fingerprints = []fingerprint{
+	{name: "Nginx", category: "Web server", headers: map[string]*regexp.Regexp{"Server": regexp.MustCompile(`(?i)nginx(?:/([\d.]+))?`)}},
+	{name: "Apache", category: "Web server", headers: map[string]*regexp.Regexp{"Server": regexp.MustCompile(`(?i)apache(?:/([\d.]+))?`)}},
+	{name: "Microsoft IIS", category: "Web server", headers: map[string]*regexp.Regexp{"Server": regexp.MustCompile(`(?i)microsoft-iis(?:/([\d.]+))?`)}},
+	{name: "LiteSpeed", category: "Web server", headers: map[string]*regexp.Regexp{"Server": regexp.MustCompile(`(?i)litespeed`)}},
+	{name: "Caddy", category: "Web server", headers: map[string]*regexp.Regexp{"Server": regexp.MustCompile(`(?i)caddy`)}},
+	{name: "OpenResty", category: "Web server", headers: map[string]*regexp.Regexp{"Server": regexp.MustCompile(`(?i)openresty(?:/([\d.]+))?`)}},
+	{name: "Cloudflare", category: "CDN", headers: map[string]*regexp.Regexp{"Server": regexp.MustCompile(`(?i)cloudflare`), "CF-Ray": regexp.MustCompile(`.`)}},
+	{name: "Amazon CloudFront", category: "CDN", headers: map[string]*regexp.Regexp{"X-Amz-Cf-Id": regexp.MustCompile(`.`)}},
+	{name: "Fastly", category: "CDN", headers: map[string]*regexp.Regexp{"X-Fastly-Request-Id": regexp.MustCompile(`.`)}},
+	{name: "Vercel", category: "Hosting", headers: map[string]*regexp.Regexp{"X-Vercel-Id": regexp.MustCompile(`.`)}},
+	{name: "GitHub Pages", category: "Hosting", headers: map[string]*regexp.Regexp{"X-GitHub-Request-Id": regexp.MustCompile(`.`)}},
+	{name: "PHP", category: "Language", headers: map[string]*regexp.Regexp{"X-Powered-By": regexp.MustCompile(`(?i)php(?:/([\d.]+))?`)}, cookies: []string{"PHPSESSID"}},
+	{name: "ASP.NET", category: "Framework", headers: map[string]*regexp.Regexp{"X-Powered-By": regexp.MustCompile(`(?i)asp\.net`), "X-AspNet-Version": regexp.MustCompile(`([\d.]+)`)}, cookies: []string{"ASP.NET_SessionId"}},
+	{name: "Express", category: "Framework", headers: map[string]*regexp.Regexp{"X-Powered-By": regexp.MustCompile(`(?i)express`)}},
+	{name: "Next.js", category: "Framework", headers: map[string]*regexp.Regexp{"X-Powered-By": regexp.MustCompile(`(?i)next\.js(?: ([\d.]+))?`)}, scripts: regexp.MustCompile(`/_next/`), markup: regexp.MustCompile(`__NEXT_DATA__`)},
+	{name: "Nuxt", category: "Framework", scripts: regexp.MustCompile(`/_nuxt/`), markup: regexp.MustCompile(`__NUXT__`)},
+	{name: "React", category: "JavaScript library", scripts: regexp.MustCompile(`react(?:-dom)?(?:[.-]([\d.]+))?(?:\.production)?(?:\.min)?\.js`), markup: regexp.MustCompile(`data-reactroot`)},
+	{name: "Vue.js", category: "JavaScript library", scripts: regexp.MustCompile(`vue(?:@([\d.]+))?(?:\.runtime)?(?:\.min)?\.js`), markup: regexp.MustCompile(`data-v-[0-9a-f]{8}`)},
+	{name: "Angular", category: "JavaScript library", markup: regexp.MustCompile(`ng-version="([\d.]+)"`)},
+	{name: "jQuery", category: "JavaScript library", scripts: regexp.MustCompile(`jquery(?:[.-]([\d.]+))?(?:\.slim)?(?:\.min)?\.js`)},
+	{name: "Bootstrap", category: "UI framework", scripts: regexp.MustCompile(`bootstrap(?:@([\d.]+))?.*\.js`)},
+	{name: "WordPress", category: "CMS", generator: regexp.MustCompile(`(?i)wordpress ?([\d.]+)?`), markup: regexp.MustCompile(`/wp-(?:content|includes)/`)},
+	{name: "Drupal", category: "CMS", headers: map[string]*regexp.Regexp{"X-Drupal-Cache": regexp.MustCompile(`.`), "X-Generator": regexp.MustCompile(`(?i)drupal ?([\d.]+)?`)}, generator: regexp.MustCompile(`(?i)drupal ?([\d.]+)?`)},
+	{name: "Joomla", category: "CMS", generator: regexp.MustCompile(`(?i)joomla!?(?: ([\d.]+))?`)},
+	{name: "Ghost", category: "CMS", generator: regexp.MustCompile(`(?i)ghost ?([\d.]+)?`)},
+	{name: "Hugo", category: "Static site generator", generator: regexp.MustCompile(`(?i)hugo ?([\d.]+)?`)},
+	{name: "Jekyll", category: "Static site generator", generator: regexp.MustCompile(`(?i)jekyll ?v?([\d.]+)?`)},
+	{name: "Wix", category: "Website builder", generator: regexp.MustCompile(`(?i)wix\.com`), headers: map[string]*regexp.Regexp{"X-Wix-Request-Id": regexp.MustCompile(`.`)}},
+	{name: "Squarespace", category: "Website builder", generator: regexp.MustCompile(`(?i)squarespace`)},
+	{name: "Shopify", category: "E-commerce", headers: map[string]*regexp.Regexp{"X-ShopId": regexp.MustCompile(`.`)}, scripts: regexp.MustCompile(`cdn\.shopify\.com`)},
+	{name: "Google Tag Manager", category: "Tag manager", scripts: regexp.MustCompile(`googletagmanager\.com/gtm\.js`)},
+	{name: "Google Analytics", category: "Analytics", scripts: regexp.MustCompile(`google-analytics\.com/(?:ga|analytics)\.js|googletagmanager\.com/gtag/js`)},
+	{name: "Plausible", category: "Analytics", scripts: regexp.MustCompile(`plausible\.io/js/`)},
+	{name: "Matomo", category: "Analytics", scripts: regexp.MustCompile(`matomo\.js|piwik\.js`)},
+	{name: "Hotjar", category: "Analytics", scripts: regexp.MustCompile(`static\.hotjar\.com`)},
+	{name: "Facebook Pixel", category: "Analytics", scripts: regexp.MustCompile(`connect\.facebook\.net/[^/]+/fbevents\.js`)},
+}
+
+func scriptSources(doc *html.Node) []string {
+	buf := make([]string, 0)
+	if doc.Type == html.ElementNode && doc.Data == "script" {
+		if source := crawler.Attribute(doc, "src"); source != "" {
+			buf = append(buf, source)
+		}
+	}
+	for next := doc.FirstChild; next != nil; next = next.NextSibling {
+		buf = append(buf, scriptSources(next)...)
+	}
+	return buf
+}
+
+func metaContent(doc *html.Node, name string) string {
+	if doc.Type == html.ElementNode && doc.Data == "meta" && strings.EqualFold(crawler.Attribute(doc, "name"), name) {
+		return crawler.Attribute(doc, "content")
+	}
+	for next := doc.FirstChild; next != nil; next = next.NextSibling {
+		if content := metaContent(next, name); content != "" {
+			return content
+		}
+	}
+	return ""
+}
+
+func matchVersion(pattern *regexp.Regexp, value string) (string, bool) {
+	if pattern == nil || value == "" {
+		return "", false
+	}
+	match := pattern.FindStringSubmatch(value)
+	if match == nil {
+		return "", false
+	}
+	if len(match) > 1 {
+		return match[1], true
+	}
+	return "", true
+}
+
+func fingerprintTechnology(response *http.Response, doc *html.Node, rawResponse []byte) []technology {
+	scripts := scriptSources(doc)
+	generator := metaContent(doc, "generator")
+	markup := string(rawResponse)
+	cookies := make(map[string]bool)
+	for _, nextCookie := range response.Cookies() {
+		cookies[nextCookie.Name] = true
+	}
+	found := make([]technology, 0)
+	for _, nextPrint := range fingerprints {
+		version, matched := "", false
+		check := func(pattern *regexp.Regexp, value string) {
+			if candidate, ok := matchVersion(pattern, value); ok {
+				matched = true
+				if version == "" {
+					version = candidate
+				}
+			}
+		}
+		// In order, so the same headers always give the same version.
+		headerNames := make([]string, 0, len(nextPrint.headers))
+		for nextName := range nextPrint.headers {
+			headerNames = append(headerNames, nextName)
+		}
+		sort.Strings(headerNames)
+		for _, nextName := range headerNames {
+			check(nextPrint.headers[nextName], response.Header.Get(nextName))
+		}
+		for _, nextScript := range scripts {
+			check(nextPrint.scripts, nextScript)
+		}
+		check(nextPrint.generator, generator)
+		check(nextPrint.markup, markup)
+		for _, nextCookie := range nextPrint.cookies {
+			matched = matched || cookies[nextCookie]
+		}
+		if matched {
+			found = append(found, technology{
+				Name:     nextPrint.name,
+				Category: nextPrint.category,
+				Version:  version,
+			})
+		}
+	}
+	return found
+}
+
+type technologyReport struct {
+	hosts map[string]map[string]int
+}
+
+func newTechnologyReport() *technologyReport {
+	return &technologyReport{
+		hosts: make(map[string]map[string]int),
+	}
+}
+
+func (this *technologyReport) name() string {
+	return "technology"
+}
+
+func (this *technologyReport) observe(asset *asset) {
+	if len(asset.Technologies) == 0 {
+		return
+	}
+	host := hostOf(asset.Address)
+	inventory, ok := this.hosts[host]
+	if !ok {
+		inventory = make(map[string]int)
+		this.hosts[host] = inventory
+	}
+	for _, nextTechnology := range asset.Technologies {
+		label := nextTechnology.Name
+		if nextTechnology.Version != "" {
+			label += " " + nextTechnology.Version
+		}
+		inventory[label]++
+	}
+}
+
+func (this *technologyReport) summary() any {
+	inventory := make(map[string][]string)
+	for host, technologies := range this.hosts {
+		names := make([]string, 0, len(technologies))
+		for name := range technologies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		inventory[host] = names
+	}
+	return inventory
+}
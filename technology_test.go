@@ -0,0 +1,75 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestFingerprintTechnology(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		page   string
+		found  []technology
+	}{
+		{
+			name:   "server version",
+			header: http.Header{"Server": {"nginx/1.25.3"}},
+			found:  []technology{{Name: "Nginx", Category: "Web server", Version: "1.25.3"}},
+		},
+		{
+			name:   "version from the one header that has it",
+			header: http.Header{"X-Powered-By": {"ASP.NET"}, "X-Aspnet-Version": {"4.0.30319"}},
+			found:  []technology{{Name: "ASP.NET", Category: "Framework", Version: "4.0.30319"}},
+		},
+		{
+			name:   "headers without versions",
+			header: http.Header{"X-Drupal-Cache": {"HIT"}, "X-Generator": {"Drupal 10"}},
+			found:  []technology{{Name: "Drupal", Category: "CMS", Version: "10"}},
+		},
+		{
+			name:  "generator and scripts",
+			page:  `<meta name="generator" content="WordPress 6.4.2"><script src="/js/jquery-3.7.1.min.js"></script>`,
+			found: []technology{{Name: "jQuery", Category: "JavaScript library", Version: "3.7.1"}, {Name: "WordPress", Category: "CMS", Version: "6.4.2"}},
+		},
+		{
+			name:  "nothing known",
+			page:  `<p>hand written</p>`,
+			found: []technology{},
+		},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			header := nextTest.header
+			if header == nil {
+				header = make(http.Header)
+			}
+			response := &http.Response{Header: header}
+			// Map order changes between runs, the versions found mustn't.
+			for i := 0; i < 20; i++ {
+				found := fingerprintTechnology(response, parsedPage(t, nextTest.page), []byte(nextTest.page))
+				if !reflect.DeepEqual(found, nextTest.found) {
+					t.Fatalf("fingerprintTechnology() = %v, want %v", found, nextTest.found)
+				}
+			}
+		})
+	}
+}
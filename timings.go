@@ -0,0 +1,93 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// Tracks the phases of a single request. The trace restarts whenever a new
+// connection is asked for, so after redirects it describes the last hop.
+type requestTrace struct {
+	start, connStart, dnsStart, dnsDone, connectStart, connectDone time.Time
+	tlsStart, tlsDone, gotConn, wroteRequest, firstByte            time.Time
+	reused                                                         bool
+}
+
+func (this *requestTrace) attach(request *http.Request) *http.Request {
+	trace := &httptrace.ClientTrace{
+		GetConn: func(string) {
+			*this = requestTrace{start: this.start, connStart: time.Now()}
+		},
+		DNSStart:          func(httptrace.DNSStartInfo) { this.dnsStart = time.Now() },
+		DNSDone:           func(httptrace.DNSDoneInfo) { this.dnsDone = time.Now() },
+		ConnectStart:      func(string, string) { this.connectStart = time.Now() },
+		ConnectDone:       func(string, string, error) { this.connectDone = time.Now() },
+		TLSHandshakeStart: func() { this.tlsStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { this.tlsDone = time.Now() },
+		GotConn: func(info httptrace.GotConnInfo) {
+			this.gotConn = time.Now()
+			this.reused = info.Reused
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) { this.wroteRequest = time.Now() },
+		GotFirstResponseByte: func() {
+			this.firstByte = time.Now()
+		},
+	}
+	return request.WithContext(httptrace.WithClientTrace(request.Context(), trace))
+}
+
+func milliseconds(from time.Time, to time.Time) float64 {
+	if from.IsZero() || to.IsZero() {
+		return -1
+	}
+	return float64(to.Sub(from).Microseconds()) / 1000
+}
+
+// How long each phase of fetching a page took, in milliseconds. Phases a
+// reused connection skipped are left out.
+type fetchTimings struct {
+	DNS      float64 `json:"dns,omitempty"`
+	Connect  float64 `json:"connect,omitempty"`
+	TLS      float64 `json:"tls,omitempty"`
+	TTFB     float64 `json:"ttfb"`
+	Transfer float64 `json:"transfer"`
+	Total    float64 `json:"total"`
+	Reused   bool    `json:"reused,omitempty"`
+}
+
+func (this *requestTrace) measure(done time.Time) *fetchTimings {
+	timings := &fetchTimings{
+		DNS:      milliseconds(this.dnsStart, this.dnsDone),
+		Connect:  milliseconds(this.connectStart, this.connectDone),
+		TLS:      milliseconds(this.tlsStart, this.tlsDone),
+		TTFB:     milliseconds(this.connStart, this.firstByte),
+		Transfer: milliseconds(this.firstByte, done),
+		Total:    milliseconds(this.start, done),
+		Reused:   this.reused,
+	}
+	for _, nextTiming := range []*float64{&timings.DNS, &timings.Connect, &timings.TLS, &timings.TTFB, &timings.Transfer} {
+		if *nextTiming < 0 {
+			*nextTiming = 0
+		}
+	}
+	return timings
+}
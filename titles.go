@@ -0,0 +1,82 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+func collapseSpace(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+type titleReport struct {
+	titles       map[string]map[string]bool
+	descriptions map[string]map[string]bool
+}
+
+func newTitleReport() *titleReport {
+	return &titleReport{
+		titles:       make(map[string]map[string]bool),
+		descriptions: make(map[string]map[string]bool),
+	}
+}
+
+func (this *titleReport) name() string {
+	return "duplicate-titles"
+}
+
+func (this *titleReport) observe(asset *asset) {
+	groupBy(this.titles, asset.Title, asset.Address)
+	groupBy(this.descriptions, asset.Description, asset.Address)
+}
+
+func groupBy(groups map[string]map[string]bool, value string, address string) {
+	if value == "" {
+		return
+	}
+	if groups[value] == nil {
+		groups[value] = make(map[string]bool)
+	}
+	groups[value][address] = true
+}
+
+// Only values shared by more than one page make it into the summary.
+func duplicatesOf(groups map[string]map[string]bool) map[string][]string {
+	buf := make(map[string][]string)
+	for nextValue, nextAddresses := range groups {
+		if len(nextAddresses) < 2 {
+			continue
+		}
+		addresses := make([]string, 0, len(nextAddresses))
+		for nextAddress := range nextAddresses {
+			addresses = append(addresses, nextAddress)
+		}
+		sort.Strings(addresses)
+		buf[nextValue] = addresses
+	}
+	return buf
+}
+
+func (this *titleReport) summary() any {
+	return map[string]map[string][]string{
+		"titles":       duplicatesOf(this.titles),
+		"descriptions": duplicatesOf(this.descriptions),
+	}
+}
@@ -0,0 +1,90 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// How the crawl's TLS connections are made, set up by initClient.
+var tlsConfig *tls.Config
+
+var minimumTLSVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Servers are trusted when the system trusts them or TLS.CAFile vouches for
+// them, and are shown TLS.ClientCert when they ask for a certificate.
+func newTLSConfig() (*tls.Config, error) {
+	minimum := strings.TrimPrefix(strings.ToLower(viper.GetString("TLS.MinVersion")), "tls")
+	version, ok := minimumTLSVersions[minimum]
+	if !ok {
+		return nil, fmt.Errorf("unknown TLS version %s, expected 1.0, 1.1, 1.2 or 1.3", viper.GetString("TLS.MinVersion"))
+	}
+	config := &tls.Config{
+		MinVersion:         version,
+		InsecureSkipVerify: viper.GetBool("TLS.Insecure"),
+	}
+	if path := viper.GetString("TLS.CAFile"); path != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		rawCertificates, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(rawCertificates) {
+			return nil, fmt.Errorf("no PEM certificates in %s", path)
+		}
+		config.RootCAs = pool
+	}
+	if certificate := viper.GetString("TLS.ClientCert"); certificate != "" {
+		key := viper.GetString("TLS.ClientKey")
+		if key == "" {
+			key = certificate
+		}
+		pair, err := tls.LoadX509KeyPair(certificate, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	if config.InsecureSkipVerify {
+		slog.Warn("Not verifying TLS certificates")
+	}
+	return config, nil
+}
+
+func initTLS() {
+	config, err := newTLSConfig()
+	if err != nil {
+		panic(fmt.Sprintf("Invalid TLS settings: %s", err.Error()))
+	}
+	tlsConfig = config
+}
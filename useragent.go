@@ -0,0 +1,52 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"strings"
+
+	"github.com/lunar-parklife/pagecrawl/pkg/crawler"
+	"github.com/spf13/viper"
+)
+
+const (
+	agentPagecrawl = "pagecrawl"
+	agentBot       = "bot"
+	agentBrowser   = "browser"
+)
+
+const agentVersion = "{version}"
+
+// Network.UserAgent can name one of these instead of spelling one out.
+var agentPresets = map[string]string{
+	agentPagecrawl: "pagecrawl; " + agentVersion,
+	agentBot:       "Mozilla/5.0 (compatible; pagecrawl/" + agentVersion + ")",
+	agentBrowser:   "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+}
+
+func initUserAgent() {
+	agent := strings.TrimSpace(viper.GetString("Network.UserAgent"))
+	if preset, ok := agentPresets[strings.ToLower(agent)]; ok {
+		agent = preset
+	}
+	if agent == "" {
+		agent = agentPresets[agentPagecrawl]
+	}
+	pageCrawler.UserAgent = strings.ReplaceAll(agent, agentVersion, crawler.Version)
+	pageCrawler.From = viper.GetString("Network.From")
+}
@@ -0,0 +1,132 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	waybackAvailability = "https://archive.org/wayback/available?url="
+	waybackSave         = "https://web.archive.org/save/"
+)
+
+type waybackRecord struct {
+	Snapshot  string `json:"snapshot,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
+	Saved     string `json:"saved,omitempty"`
+}
+
+type waybackAvailable struct {
+	ArchivedSnapshots struct {
+		Closest *struct {
+			Available bool   `json:"available"`
+			URL       string `json:"url"`
+			Timestamp string `json:"timestamp"`
+		} `json:"closest"`
+	} `json:"archived_snapshots"`
+}
+
+// The Wayback Machine is told who is asking, but gets none of the headers
+// or credentials meant for the sites crawled.
+func newWaybackRequest(where string) (*http.Request, error) {
+	request, err := http.NewRequest(http.MethodGet, where, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", pageCrawler.UserAgent)
+	return request, nil
+}
+
+func checkWayback(where string, record *waybackRecord) error {
+	request, err := newWaybackRequest(waybackAvailability + url.QueryEscape(where))
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+	available := &waybackAvailable{}
+	err = json.NewDecoder(response.Body).Decode(available)
+	if err != nil {
+		return err
+	}
+	closest := available.ArchivedSnapshots.Closest
+	if closest != nil && closest.Available {
+		record.Snapshot = closest.URL
+		record.Timestamp = closest.Timestamp
+	}
+	return nil
+}
+
+// Save Page Now redirects to the fresh snapshot once it has been taken.
+func saveWayback(where string, record *waybackRecord) error {
+	request, err := newWaybackRequest(waybackSave + where)
+	if err != nil {
+		return err
+	}
+	if accessKey := viper.GetString("Wayback.AccessKey"); accessKey != "" {
+		request.Header.Add("Authorization", fmt.Sprintf("LOW %s:%s", accessKey, viper.GetString("Wayback.SecretKey")))
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	if response.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+	record.Saved = response.Request.URL.String()
+	if location := response.Header.Get("Content-Location"); location != "" {
+		record.Saved = "https://web.archive.org" + location
+	}
+	return nil
+}
+
+func archiveInWayback(where string) *waybackRecord {
+	record := &waybackRecord{}
+	if viper.GetBool("Wayback.Check") {
+		err := checkWayback(where, record)
+		if err != nil {
+			slog.Warn("Cannot check the Wayback Machine", "url", where, failed(err))
+		}
+	}
+	if viper.GetBool("Wayback.Save") {
+		err := saveWayback(where, record)
+		if err != nil {
+			slog.Warn("Cannot save to the Wayback Machine", "url", where, failed(err))
+		}
+	}
+	if *record == (waybackRecord{}) {
+		return nil
+	}
+	return record
+}
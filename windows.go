@@ -0,0 +1,122 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// A daily stretch of time, in minutes since midnight. Windows that end
+// before they start run past midnight.
+type timeWindow struct {
+	start int
+	end   int
+}
+
+func parseClock(clock string) (int, error) {
+	parsed, err := time.Parse("15:04", strings.TrimSpace(clock))
+	if err != nil {
+		return 0, err
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+func parseWindow(window string) (timeWindow, error) {
+	start, end, ok := strings.Cut(window, "-")
+	if !ok {
+		return timeWindow{}, fmt.Errorf("window %s is not written as HH:MM-HH:MM", window)
+	}
+	startMinute, err := parseClock(start)
+	if err != nil {
+		return timeWindow{}, err
+	}
+	endMinute, err := parseClock(end)
+	if err != nil {
+		return timeWindow{}, err
+	}
+	return timeWindow{start: startMinute, end: endMinute}, nil
+}
+
+func (this timeWindow) contains(minute int) bool {
+	if this.start <= this.end {
+		return minute >= this.start && minute < this.end
+	}
+	return minute >= this.start || minute < this.end
+}
+
+func (this *hostConfig) initWindows() error {
+	this.location = time.Local
+	if this.TimeZone != "" {
+		location, err := time.LoadLocation(this.TimeZone)
+		if err != nil {
+			return err
+		}
+		this.location = location
+	}
+	this.windows = make([]timeWindow, 0, len(this.Windows))
+	for _, nextWindow := range this.Windows {
+		window, err := parseWindow(nextWindow)
+		if err != nil {
+			return err
+		}
+		this.windows = append(this.windows, window)
+	}
+	return nil
+}
+
+// How long until the host may be crawled, which is zero while one of its
+// windows is open or when it has none.
+func (this *hostConfig) untilOpen(now time.Time) time.Duration {
+	if len(this.windows) == 0 {
+		return 0
+	}
+	local := now.In(this.location)
+	minute := local.Hour()*60 + local.Minute()
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, this.location)
+	soonest := time.Duration(-1)
+	for _, nextWindow := range this.windows {
+		if nextWindow.contains(minute) {
+			return 0
+		}
+		opens := midnight.Add(time.Duration(nextWindow.start) * time.Minute)
+		if !opens.After(local) {
+			opens = opens.AddDate(0, 0, 1)
+		}
+		if wait := opens.Sub(local); soonest < 0 || wait < soonest {
+			soonest = wait
+		}
+	}
+	return soonest
+}
+
+func untilWindowOpens(where string) time.Duration {
+	return configFor(hostOf(where)).untilOpen(time.Now())
+}
+
+// Holds a fetch back until its host's crawl window opens.
+func waitForWindow(where string) {
+	wait := untilWindowOpens(where)
+	if wait <= 0 {
+		return
+	}
+	slog.Info("Holding until the crawl window opens", "url", where, "wait", wait.Round(time.Second))
+	time.Sleep(wait)
+}
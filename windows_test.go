@@ -0,0 +1,84 @@
+/*
+ *   Copyright (C) 2023  Luna
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	tests := []struct {
+		window string
+		start  int
+		end    int
+		err    bool
+	}{
+		{window: "01:00-06:30", start: 60, end: 390},
+		{window: " 22:00 - 02:00 ", start: 1320, end: 120},
+		{window: "0:00-23:59", start: 0, end: 1439},
+		{window: "01:00", err: true},
+		{window: "25:00-26:00", err: true},
+		{window: "night-day", err: true},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.window, func(t *testing.T) {
+			window, err := parseWindow(nextTest.window)
+			if (err != nil) != nextTest.err {
+				t.Fatalf("parseWindow(%q) error = %v, want error %v", nextTest.window, err, nextTest.err)
+			}
+			if err == nil && (window.start != nextTest.start || window.end != nextTest.end) {
+				t.Errorf("parseWindow(%q) = %d-%d, want %d-%d", nextTest.window, window.start, window.end, nextTest.start, nextTest.end)
+			}
+		})
+	}
+}
+
+func TestUntilOpen(t *testing.T) {
+	tests := []struct {
+		name    string
+		windows []string
+		now     string
+		wait    time.Duration
+	}{
+		{name: "no windows", now: "12:00", wait: 0},
+		{name: "inside", windows: []string{"01:00-06:00"}, now: "03:15", wait: 0},
+		{name: "before", windows: []string{"01:00-06:00"}, now: "00:30", wait: 30 * time.Minute},
+		{name: "after waits for tomorrow", windows: []string{"01:00-06:00"}, now: "06:00", wait: 19 * time.Hour},
+		{name: "past midnight, late", windows: []string{"22:00-02:00"}, now: "23:30", wait: 0},
+		{name: "past midnight, early", windows: []string{"22:00-02:00"}, now: "01:59", wait: 0},
+		{name: "past midnight, closed", windows: []string{"22:00-02:00"}, now: "12:00", wait: 10 * time.Hour},
+		{name: "soonest of several", windows: []string{"20:00-21:00", "13:00-14:00"}, now: "12:00", wait: time.Hour},
+	}
+	for _, nextTest := range tests {
+		t.Run(nextTest.name, func(t *testing.T) {
+			config := &hostConfig{Windows: nextTest.windows, TimeZone: "UTC"}
+			err := config.initWindows()
+			if err != nil {
+				t.Fatal(err)
+			}
+			now, err := time.Parse("2006-01-02 15:04", "2024-03-01 "+nextTest.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wait := config.untilOpen(now); wait != nextTest.wait {
+				t.Errorf("untilOpen(%s) = %s, want %s", nextTest.now, wait, nextTest.wait)
+			}
+		})
+	}
+}